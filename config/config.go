@@ -0,0 +1,671 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every tuning knob of the service. Values come from the optional
+// file named by CONFIG_FILE (YAML or JSON) and are overridden by environment variables.
+type Config struct {
+	Port                  int     `yaml:"port"`
+	MaxConcurrentRequests int     `yaml:"max_concurrent_requests"`
+	EnableGzip            bool    `yaml:"enable_gzip"`
+	TempDir               string  `yaml:"temp_dir"`
+	MinFreeDiskMB         int     `yaml:"min_free_disk_mb"`
+	DiskSpaceMultiplier   float64 `yaml:"disk_space_multiplier"`
+	// MultipartMemoryMB of an upload form are kept in memory, the rest spills to temp
+	// files. MaxUploadMB caps the request body, 0 accepts any size.
+	MultipartMemoryMB int `yaml:"multipart_memory_mb"`
+	MaxUploadMB       int `yaml:"max_upload_mb"`
+	// StrictMultipart rejects upload forms with more than one file part or repeated
+	// fields instead of using the first one
+	StrictMultipart bool `yaml:"strict_multipart"`
+	// MetaMaxFields and MetaMaxBytes (keys and values together) bound the custom
+	// metadata an upload can pass through with meta[...] or meta fields
+	MetaMaxFields int `yaml:"meta_max_fields"`
+	MetaMaxBytes  int `yaml:"meta_max_bytes"`
+	// TempFileMode is the octal mode of temp files, e.g. 0600. Umask applies to the
+	// whole process, including files ffmpeg writes; empty keeps the inherited one.
+	TempFileMode string `yaml:"temp_file_mode"`
+	Umask        string `yaml:"umask"`
+
+	Server      ServerConfig      `yaml:"server"`
+	AWS         AWSConfig         `yaml:"aws"`
+	Azure       AzureConfig       `yaml:"azure"`
+	Auth        AuthConfig        `yaml:"auth"`
+	CORS        CORSConfig        `yaml:"cors"`
+	Logging     LoggingConfig     `yaml:"logging"`
+	Storage     StorageConfig     `yaml:"storage"`
+	RemoteFetch RemoteFetchConfig `yaml:"remote_fetch"`
+	Batch       BatchConfig       `yaml:"batch"`
+	Jobs        JobsConfig        `yaml:"jobs"`
+	Moderation  ModerationConfig  `yaml:"moderation"`
+	Media       MediaConfig       `yaml:"media"`
+}
+
+// ServerConfig controls the HTTP server. Uploads can be large and slow, so the read and
+// write timeouts are generous while headers must arrive quickly.
+type ServerConfig struct {
+	ReadHeaderTimeout time.Duration `yaml:"read_header_timeout"`
+	ReadTimeout       time.Duration `yaml:"read_timeout"`
+	WriteTimeout      time.Duration `yaml:"write_timeout"`
+	IdleTimeout       time.Duration `yaml:"idle_timeout"`
+	EnableH2C         bool          `yaml:"enable_h2c"`
+	TLSCertFile       string        `yaml:"tls_cert_file"`
+	TLSKeyFile        string        `yaml:"tls_key_file"`
+}
+
+// AWSConfig holds the S3 credentials and buckets
+type AWSConfig struct {
+	AccessKeyID     string `yaml:"access_key_id"`
+	SecretAccessKey string `yaml:"secret_access_key"`
+	Region          string `yaml:"region"`
+	Bucket          string `yaml:"bucket"`
+	BackupBucket    string `yaml:"backup_bucket"`
+	BackupRegion    string `yaml:"backup_region"`
+	FallbackBucket  string `yaml:"fallback_bucket"`
+	FallbackRegion  string `yaml:"fallback_region"`
+	CACertFile      string `yaml:"ca_cert_file"`
+	// MaxConns caps the connections to each S3 endpoint shared by all requests, 0 is
+	// unlimited. Up to MaxIdleConns of them stay open for IdleConnTimeout to be reused.
+	MaxConns        int           `yaml:"max_conns"`
+	MaxIdleConns    int           `yaml:"max_idle_conns"`
+	IdleConnTimeout time.Duration `yaml:"idle_conn_timeout"`
+}
+
+// AzureConfig holds the Azure Blob Storage account used when storage.backend is azure
+type AzureConfig struct {
+	AccountName  string `yaml:"account_name"`
+	AccountKey   string `yaml:"account_key"`
+	Container    string `yaml:"container"`
+	Endpoint     string `yaml:"endpoint"`
+	PublicAccess bool   `yaml:"public_access"`
+}
+
+// AuthConfig holds the API keys accepted on authenticated endpoints
+type AuthConfig struct {
+	APIKeys []string `yaml:"api_keys"`
+	// AdminAPIKeys unlock debugging options such as debug=true
+	AdminAPIKeys []string `yaml:"admin_api_keys"`
+}
+
+// LoggingConfig controls the log level and which request headers are logged
+type LoggingConfig struct {
+	Level string `yaml:"level"`
+	// Headers are logged at debug level; credentials are never logged
+	Headers []string `yaml:"headers"`
+}
+
+// CORSConfig controls which browser origins may call the API
+type CORSConfig struct {
+	AllowedOrigins []string `yaml:"allowed_origins"`
+}
+
+// StorageConfig selects the storage backend and controls how object keys are built
+type StorageConfig struct {
+	Backend           string        `yaml:"backend"`
+	FilenameStrategy  string        `yaml:"filename_strategy"`
+	PartitionScheme   string        `yaml:"partition_scheme"`
+	KeyCase           string        `yaml:"key_case"`
+	ChecksumAlgorithm string        `yaml:"checksum_algorithm"`
+	PresignExpiry     time.Duration `yaml:"presign_expiry"`
+	// VerifyUpload checks that every object exists with the right size after uploading
+	VerifyUpload bool `yaml:"verify_upload"`
+	// DirectUploadPrefix is prepended to presigned upload keys, /finalize only
+	// accepts keys under it
+	DirectUploadPrefix string `yaml:"direct_upload_prefix"`
+	// ReprocessPrefix limits /reprocess to objects under it. Empty allows any source
+	// object but no overwrite or target_key, so nothing else in the bucket is replaced.
+	ReprocessPrefix string `yaml:"reprocess_prefix"`
+	// PresignDownloadPrefix limits /presign-download to objects under it, which is
+	// refused while it is empty
+	PresignDownloadPrefix string `yaml:"presign_download_prefix"`
+	// ProcessedNaming names transcoded videos: suffix (clip_processed.mp4), extension
+	// (clip.mp4) or original (clip.mov, holding the MP4)
+	ProcessedNaming string `yaml:"processed_naming"`
+	// KeyStrategy names the registered key strategies that build object keys, chained
+	// in order
+	KeyStrategy []string `yaml:"key_strategy"`
+}
+
+// RemoteFetchConfig controls downloads of remote source files
+type RemoteFetchConfig struct {
+	Timeout      time.Duration `yaml:"timeout"`
+	Retries      int           `yaml:"retries"`
+	RequireHTTPS bool          `yaml:"require_https"`
+	// MaxMB caps every remote download, larger sources fail with source_too_large
+	MaxMB int `yaml:"max_mb"`
+	// AllowPrivateNetworks lets source URLs reach loopback, private and link-local
+	// addresses, e.g. a local MinIO in development
+	AllowPrivateNetworks bool `yaml:"allow_private_networks"`
+}
+
+// BatchConfig controls the batch aspect-ratio endpoint
+type BatchConfig struct {
+	Concurrency int           `yaml:"concurrency"`
+	URLTimeout  time.Duration `yaml:"url_timeout"`
+	// MaxConcurrency caps the concurrency a request can ask for
+	MaxConcurrency int `yaml:"max_concurrency"`
+}
+
+// JobsConfig controls asynchronous uploads
+type JobsConfig struct {
+	// TTL is how long finished jobs can be looked up
+	TTL time.Duration `yaml:"ttl"`
+	// Workers process jobs concurrently, QueueSize more can wait for a worker
+	Workers   int `yaml:"workers"`
+	QueueSize int `yaml:"queue_size"`
+}
+
+// ModerationConfig controls the optional content check run before files are stored.
+// It is disabled while URL is empty.
+type ModerationConfig struct {
+	URL     string        `yaml:"url"`
+	Timeout time.Duration `yaml:"timeout"`
+	// FailOpen stores files when the moderation service can't be reached
+	FailOpen bool `yaml:"fail_open"`
+}
+
+// MediaConfig controls media detection and analysis
+type MediaConfig struct {
+	ContentSniffBytes int     `yaml:"content_sniff_bytes"`
+	BlurThreshold     float64 `yaml:"blur_threshold"`
+	// RatioMaxDenominator caps the denominator of reported aspect ratios, a
+	// RatioTolerance above 0 prefers simpler fractions within that relative error
+	RatioMaxDenominator int     `yaml:"ratio_max_denominator"`
+	RatioTolerance      float64 `yaml:"ratio_tolerance"`
+	// SquareTolerance is how much longer the long side may be, relative to the
+	// short one, for a file's orientation to still be reported as square
+	SquareTolerance     float64 `yaml:"square_tolerance"`
+	NoVideoStreamPolicy string  `yaml:"no_video_stream_policy"`
+	// FormatMatchTolerance above 0 is the largest relative difference between the
+	// aspect ratios of a file and its matched format, further off files match none
+	FormatMatchTolerance float64 `yaml:"format_match_tolerance"`
+	// SkipOptimizedTranscode stores H.264 faststart MP4s at or below
+	// SkipTranscodeMaxBitRate (bits per second) without transcoding
+	SkipOptimizedTranscode  bool  `yaml:"skip_optimized_transcode"`
+	SkipTranscodeMaxBitRate int64 `yaml:"skip_transcode_max_bitrate"`
+	// TranscodeMinBytes stores smaller videos without transcoding, 0 transcodes all
+	TranscodeMinBytes int64 `yaml:"transcode_min_bytes"`
+	// TranscodeBitrateFloor (bits per second) spares videos below it the usual
+	// compression: they are remuxed when MP4 can hold them, otherwise encoded at a higher
+	// quality. 0 disables the floor.
+	TranscodeBitrateFloor int64 `yaml:"transcode_bitrate_floor"`
+	// PreferSmaller stores the original video when processing didn't make it smaller
+	PreferSmaller bool `yaml:"prefer_smaller"`
+	// Faststart is the default for moving the moov atom of processed videos to the front
+	Faststart bool `yaml:"faststart"`
+	// AudioBitrate is used when the audio of a processed video has to be re-encoded
+	// to AAC, in ffmpeg notation (e.g. 96k)
+	AudioBitrate string `yaml:"audio_bitrate"`
+	// FFmpegTimeout kills ffmpeg and ffprobe runs that take longer, 0 disables it
+	FFmpegTimeout time.Duration `yaml:"ffmpeg_timeout"`
+	// MaxGIFFrames bounds the frames of animated GIFs that are resized frame by frame
+	MaxGIFFrames int `yaml:"max_gif_frames"`
+	// Default encoder qualities (1-100) when uploads don't set quality
+	JPEGQuality int `yaml:"jpeg_quality"`
+	WebPQuality int `yaml:"webp_quality"`
+	// AllowedOutputFormats are the output_format values clients may request
+	AllowedOutputFormats []string `yaml:"allowed_output_formats"`
+	// VideoCodecs are the ffmpeg video encoders uploads may pick with video_codec
+	VideoCodecs []string `yaml:"video_codecs"`
+}
+
+// Default returns the configuration used when nothing is set
+func Default() *Config {
+	return &Config{
+		Port:                8080,
+		TempFileMode:        "0600",
+		Umask:               "0077",
+		MinFreeDiskMB:       100,
+		MultipartMemoryMB:   10,
+		DiskSpaceMultiplier: 3,
+		MetaMaxFields:       20,
+		MetaMaxBytes:        2048,
+		Server: ServerConfig{
+			ReadHeaderTimeout: 10 * time.Second,
+			ReadTimeout:       15 * time.Minute,
+			WriteTimeout:      15 * time.Minute,
+			IdleTimeout:       2 * time.Minute,
+			EnableH2C:         true,
+		},
+		AWS: AWSConfig{
+			MaxIdleConns:    10,
+			IdleConnTimeout: 30 * time.Second,
+		},
+		CORS: CORSConfig{
+			AllowedOrigins: []string{"*"},
+		},
+		Logging: LoggingConfig{
+			Level:   "info",
+			Headers: []string{"Content-Type", "Content-Length", "User-Agent"},
+		},
+		Storage: StorageConfig{
+			Backend:           "s3",
+			FilenameStrategy:  "keep",
+			PartitionScheme:   "none",
+			KeyStrategy:       []string{"original"},
+			KeyCase:           "preserve",
+			ChecksumAlgorithm: "sha256",
+			PresignExpiry:     15 * time.Minute,
+			ProcessedNaming:   "suffix",
+		},
+		RemoteFetch: RemoteFetchConfig{
+			Timeout: 30 * time.Second,
+			Retries: 2,
+			MaxMB:   1024,
+		},
+		Batch: BatchConfig{
+			Concurrency:    4,
+			URLTimeout:     60 * time.Second,
+			MaxConcurrency: 16,
+		},
+		Jobs: JobsConfig{
+			TTL:       time.Hour,
+			Workers:   2,
+			QueueSize: 100,
+		},
+		Moderation: ModerationConfig{
+			Timeout: 10 * time.Second,
+		},
+		Media: MediaConfig{
+			ContentSniffBytes:       261,
+			BlurThreshold:           100,
+			RatioMaxDenominator:     100,
+			SquareTolerance:         0.05,
+			NoVideoStreamPolicy:     "audio",
+			SkipTranscodeMaxBitRate: 4_000_000,
+			Faststart:               true,
+			AudioBitrate:            "96k",
+			FFmpegTimeout:           10 * time.Minute,
+			MaxGIFFrames:            500,
+			JPEGQuality:             90,
+			WebPQuality:             80,
+			AllowedOutputFormats:    []string{"gif", "webp"},
+			VideoCodecs:             []string{"libx264"},
+		},
+	}
+}
+
+// Load builds the configuration from defaults, the CONFIG_FILE (if set) and
+// environment variables, in increasing order of precedence, and validates it
+func Load() (*Config, error) {
+	cfg := Default()
+
+	if path := os.Getenv("CONFIG_FILE"); path != "" {
+		if err := cfg.loadFile(path); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := cfg.applyEnv(); err != nil {
+		return nil, err
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile reads a YAML or JSON config file; JSON is valid YAML so one parser handles both
+func (c *Config) loadFile(path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, c); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// applyEnv overrides file values with any environment variables that are set
+func (c *Config) applyEnv() error {
+	setString(&c.TempDir, "TEMP_DIR")
+	setString(&c.TempFileMode, "TEMP_FILE_MODE")
+	setString(&c.Umask, "UMASK")
+	setString(&c.Server.TLSCertFile, "TLS_CERT_FILE")
+	setString(&c.Server.TLSKeyFile, "TLS_KEY_FILE")
+	setString(&c.AWS.AccessKeyID, "AWS_ACCESS_KEY_ID")
+	setString(&c.AWS.SecretAccessKey, "AWS_SECRET_ACCESS_KEY")
+	setString(&c.AWS.Region, "AWS_REGION")
+	setString(&c.AWS.Bucket, "AWS_S3_BUCKET")
+	setString(&c.AWS.BackupBucket, "S3_BACKUP_BUCKET")
+	setString(&c.AWS.BackupRegion, "S3_BACKUP_REGION")
+	setString(&c.AWS.FallbackBucket, "S3_FALLBACK_BUCKET")
+	setString(&c.AWS.FallbackRegion, "S3_FALLBACK_REGION")
+	setString(&c.AWS.CACertFile, "SSL_CERT_FILE")
+	setString(&c.Azure.AccountName, "AZURE_STORAGE_ACCOUNT")
+	setString(&c.Azure.AccountKey, "AZURE_STORAGE_KEY")
+	setString(&c.Azure.Container, "AZURE_STORAGE_CONTAINER")
+	setString(&c.Azure.Endpoint, "AZURE_STORAGE_ENDPOINT")
+	setStringList(&c.Auth.APIKeys, "API_KEYS")
+	setStringList(&c.Auth.AdminAPIKeys, "ADMIN_API_KEYS")
+	setStringList(&c.CORS.AllowedOrigins, "CORS_ALLOWED_ORIGINS")
+	setStringList(&c.Storage.KeyStrategy, "KEY_STRATEGY")
+	setString(&c.Logging.Level, "LOG_LEVEL")
+	setStringList(&c.Logging.Headers, "LOG_HEADERS")
+	setString(&c.Storage.Backend, "STORAGE_BACKEND")
+	setString(&c.Storage.FilenameStrategy, "FILENAME_STRATEGY")
+	setString(&c.Storage.PartitionScheme, "PARTITION_SCHEME")
+	setString(&c.Storage.KeyCase, "KEY_CASE")
+	setString(&c.Storage.DirectUploadPrefix, "DIRECT_UPLOAD_PREFIX")
+	setString(&c.Storage.ReprocessPrefix, "REPROCESS_PREFIX")
+	setString(&c.Storage.PresignDownloadPrefix, "PRESIGN_DOWNLOAD_PREFIX")
+	setString(&c.Storage.ProcessedNaming, "PROCESSED_NAMING")
+	setString(&c.Storage.ChecksumAlgorithm, "CHECKSUM_ALGORITHM")
+	setString(&c.Moderation.URL, "MODERATION_URL")
+	setString(&c.Media.NoVideoStreamPolicy, "NO_VIDEO_STREAM_POLICY")
+	setString(&c.Media.AudioBitrate, "FALLBACK_AUDIO_BITRATE")
+	setStringList(&c.Media.AllowedOutputFormats, "ALLOWED_OUTPUT_FORMATS")
+	setStringList(&c.Media.VideoCodecs, "VIDEO_CODECS")
+
+	for _, err := range []error{
+		setInt(&c.Port, "PORT"),
+		setInt(&c.MaxConcurrentRequests, "MAX_CONCURRENT_REQUESTS"),
+		setInt(&c.MinFreeDiskMB, "MIN_FREE_DISK_MB"),
+		setInt(&c.MultipartMemoryMB, "MULTIPART_MEMORY_MB"),
+		setInt(&c.MaxUploadMB, "MAX_UPLOAD_MB"),
+		setBool(&c.StrictMultipart, "STRICT_MULTIPART"),
+		setInt(&c.MetaMaxFields, "META_MAX_FIELDS"),
+		setInt(&c.MetaMaxBytes, "META_MAX_BYTES"),
+		setFloat(&c.DiskSpaceMultiplier, "DISK_SPACE_MULTIPLIER"),
+		setBool(&c.EnableGzip, "ENABLE_GZIP"),
+		setDuration(&c.Server.ReadHeaderTimeout, "SERVER_READ_HEADER_TIMEOUT"),
+		setDuration(&c.Server.ReadTimeout, "SERVER_READ_TIMEOUT"),
+		setDuration(&c.Server.WriteTimeout, "SERVER_WRITE_TIMEOUT"),
+		setDuration(&c.Server.IdleTimeout, "SERVER_IDLE_TIMEOUT"),
+		setBool(&c.Server.EnableH2C, "ENABLE_H2C"),
+		setInt(&c.AWS.MaxConns, "S3_MAX_CONNS"),
+		setInt(&c.AWS.MaxIdleConns, "S3_MAX_IDLE_CONNS"),
+		setDuration(&c.AWS.IdleConnTimeout, "S3_IDLE_CONN_TIMEOUT"),
+		setBool(&c.Azure.PublicAccess, "AZURE_PUBLIC_ACCESS"),
+		setBool(&c.Storage.VerifyUpload, "VERIFY_UPLOAD"),
+		setDuration(&c.Storage.PresignExpiry, "PRESIGN_EXPIRY"),
+		setDuration(&c.RemoteFetch.Timeout, "REMOTE_FETCH_TIMEOUT"),
+		setInt(&c.RemoteFetch.Retries, "REMOTE_FETCH_RETRIES"),
+		setInt(&c.RemoteFetch.MaxMB, "REMOTE_FETCH_MAX_MB"),
+		setBool(&c.RemoteFetch.RequireHTTPS, "REQUIRE_HTTPS_SOURCE"),
+		setBool(&c.RemoteFetch.AllowPrivateNetworks, "REMOTE_FETCH_ALLOW_PRIVATE"),
+		setInt(&c.Batch.Concurrency, "BATCH_CONCURRENCY"),
+		setDuration(&c.Batch.URLTimeout, "BATCH_URL_TIMEOUT"),
+		setInt(&c.Batch.MaxConcurrency, "BATCH_MAX_CONCURRENCY"),
+		setDuration(&c.Jobs.TTL, "JOB_TTL"),
+		setInt(&c.Jobs.Workers, "JOB_WORKERS"),
+		setInt(&c.Jobs.QueueSize, "JOB_QUEUE_SIZE"),
+		setDuration(&c.Moderation.Timeout, "MODERATION_TIMEOUT"),
+		setBool(&c.Moderation.FailOpen, "MODERATION_FAIL_OPEN"),
+		setInt(&c.Media.ContentSniffBytes, "CONTENT_SNIFF_BYTES"),
+		setFloat(&c.Media.BlurThreshold, "BLUR_THRESHOLD"),
+		setInt(&c.Media.RatioMaxDenominator, "RATIO_MAX_DENOMINATOR"),
+		setFloat(&c.Media.RatioTolerance, "RATIO_TOLERANCE"),
+		setFloat(&c.Media.SquareTolerance, "SQUARE_TOLERANCE"),
+		setFloat(&c.Media.FormatMatchTolerance, "FORMAT_MATCH_TOLERANCE"),
+		setBool(&c.Media.SkipOptimizedTranscode, "SKIP_OPTIMIZED_TRANSCODE"),
+		setBool(&c.Media.Faststart, "FASTSTART"),
+		setInt64(&c.Media.SkipTranscodeMaxBitRate, "SKIP_TRANSCODE_MAX_BITRATE"),
+		setInt64(&c.Media.TranscodeMinBytes, "TRANSCODE_MIN_BYTES"),
+		setInt64(&c.Media.TranscodeBitrateFloor, "TRANSCODE_BITRATE_FLOOR"),
+		setBool(&c.Media.PreferSmaller, "PREFER_SMALLER"),
+		setDuration(&c.Media.FFmpegTimeout, "FFMPEG_TIMEOUT"),
+		setInt(&c.Media.MaxGIFFrames, "MAX_GIF_FRAMES"),
+		setInt(&c.Media.JPEGQuality, "JPEG_QUALITY"),
+		setInt(&c.Media.WebPQuality, "WEBP_QUALITY"),
+	} {
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks that all values are usable
+func (c *Config) Validate() error {
+	fileMode, fileModeErr := ParseMode(c.TempFileMode)
+	umask, umaskErr := ParseMode(c.Umask)
+	switch {
+	case fileModeErr != nil || fileMode&0o600 != 0o600:
+		return fmt.Errorf("temp_file_mode must be an octal mode that lets the owner read and write, e.g. 0600, got %q", c.TempFileMode)
+	case c.Umask != "" && (umaskErr != nil || umask&0o700 != 0):
+		return fmt.Errorf("umask must be an octal mask that keeps the owner's permissions, e.g. 0077, got %q", c.Umask)
+	case c.Storage.Backend != "s3" && c.Storage.Backend != "azure":
+		return fmt.Errorf("storage.backend must be s3 or azure, got %q", c.Storage.Backend)
+	case c.Storage.Backend == "s3" && (c.AWS.AccessKeyID == "" || c.AWS.SecretAccessKey == ""):
+		return fmt.Errorf("AWS credentials are required (AWS_ACCESS_KEY_ID, AWS_SECRET_ACCESS_KEY)")
+	case c.Storage.Backend == "s3" && (c.AWS.Region == "" || c.AWS.Bucket == ""):
+		return fmt.Errorf("AWS region and bucket are required (AWS_REGION, AWS_S3_BUCKET)")
+	case c.AWS.MaxConns < 0 || c.AWS.MaxIdleConns < 0 || c.AWS.IdleConnTimeout < 0:
+		return fmt.Errorf("aws.max_conns, aws.max_idle_conns and aws.idle_conn_timeout must not be negative")
+	case c.Storage.Backend == "azure" && (c.Azure.AccountName == "" || c.Azure.AccountKey == "" || c.Azure.Container == ""):
+		return fmt.Errorf("Azure account, key and container are required (AZURE_STORAGE_ACCOUNT, AZURE_STORAGE_KEY, AZURE_STORAGE_CONTAINER)")
+	case c.Port <= 0 || c.Port > 65535:
+		return fmt.Errorf("port must be between 1 and 65535, got %d", c.Port)
+	case c.Server.ReadHeaderTimeout <= 0:
+		return fmt.Errorf("server.read_header_timeout must be positive")
+	case c.Server.ReadTimeout < 0 || c.Server.WriteTimeout < 0 || c.Server.IdleTimeout < 0:
+		return fmt.Errorf("server timeouts must not be negative")
+	case (c.Server.TLSCertFile == "") != (c.Server.TLSKeyFile == ""):
+		return fmt.Errorf("server.tls_cert_file and server.tls_key_file must be set together")
+	case len(c.CORS.AllowedOrigins) == 0:
+		return fmt.Errorf("cors.allowed_origins must list at least one origin (use \"*\" to allow all)")
+	case c.Storage.FilenameStrategy != "keep" && c.Storage.FilenameStrategy != "ascii" && c.Storage.FilenameStrategy != "slug":
+		return fmt.Errorf("storage.filename_strategy must be keep, ascii or slug, got %q", c.Storage.FilenameStrategy)
+	case c.Storage.PartitionScheme != "none" && c.Storage.PartitionScheme != "ymd" && c.Storage.PartitionScheme != "hive":
+		return fmt.Errorf("storage.partition_scheme must be none, ymd or hive, got %q", c.Storage.PartitionScheme)
+	case c.Storage.KeyCase != "preserve" && c.Storage.KeyCase != "lower" && c.Storage.KeyCase != "upper":
+		return fmt.Errorf("storage.key_case must be preserve, lower or upper, got %q", c.Storage.KeyCase)
+	case c.Storage.ProcessedNaming != "suffix" && c.Storage.ProcessedNaming != "extension" && c.Storage.ProcessedNaming != "original":
+		return fmt.Errorf("storage.processed_naming must be suffix, extension or original, got %q", c.Storage.ProcessedNaming)
+	case !validChecksumAlgorithm(c.Storage.ChecksumAlgorithm):
+		return fmt.Errorf("storage.checksum_algorithm must be none, crc32, crc32c, sha1, sha256 or md5, got %q", c.Storage.ChecksumAlgorithm)
+	case c.Storage.PresignExpiry <= 0 || c.Storage.PresignExpiry > 7*24*time.Hour:
+		return fmt.Errorf("storage.presign_expiry must be positive and at most 7 days")
+	case !validLogLevel(c.Logging.Level):
+		return fmt.Errorf("logging.level must be debug, info, warn or error, got %q", c.Logging.Level)
+	case c.MultipartMemoryMB <= 0:
+		return fmt.Errorf("multipart_memory_mb must be positive")
+	case c.MaxUploadMB < 0:
+		return fmt.Errorf("max_upload_mb must not be negative")
+	case c.MetaMaxFields <= 0 || c.MetaMaxBytes <= 0:
+		return fmt.Errorf("meta_max_fields and meta_max_bytes must be positive")
+	case c.MinFreeDiskMB < 0 || c.DiskSpaceMultiplier < 0:
+		return fmt.Errorf("min_free_disk_mb and disk_space_multiplier must not be negative")
+	case c.MaxConcurrentRequests < 0:
+		return fmt.Errorf("max_concurrent_requests must not be negative")
+	case c.RemoteFetch.Timeout <= 0:
+		return fmt.Errorf("remote_fetch.timeout must be positive")
+	case c.RemoteFetch.Retries < 0:
+		return fmt.Errorf("remote_fetch.retries must not be negative")
+	case c.RemoteFetch.MaxMB <= 0:
+		return fmt.Errorf("remote_fetch.max_mb must be positive")
+	case c.Batch.Concurrency <= 0:
+		return fmt.Errorf("batch.concurrency must be positive")
+	case c.Batch.MaxConcurrency < c.Batch.Concurrency:
+		return fmt.Errorf("batch.max_concurrency must be at least batch.concurrency")
+	case c.Batch.URLTimeout <= 0:
+		return fmt.Errorf("batch.url_timeout must be positive")
+	case c.Jobs.TTL <= 0:
+		return fmt.Errorf("jobs.ttl must be positive")
+	case c.Jobs.Workers <= 0:
+		return fmt.Errorf("jobs.workers must be positive")
+	case c.Jobs.QueueSize < 0:
+		return fmt.Errorf("jobs.queue_size must not be negative")
+	case c.Moderation.URL != "" && !strings.HasPrefix(c.Moderation.URL, "http://") && !strings.HasPrefix(c.Moderation.URL, "https://"):
+		return fmt.Errorf("moderation.url must be an http(s) URL")
+	case c.Moderation.Timeout <= 0:
+		return fmt.Errorf("moderation.timeout must be positive")
+	case c.Media.ContentSniffBytes < 261:
+		return fmt.Errorf("media.content_sniff_bytes must be at least 261")
+	case c.Media.BlurThreshold < 0:
+		return fmt.Errorf("media.blur_threshold must not be negative")
+	case c.Media.RatioMaxDenominator <= 0:
+		return fmt.Errorf("media.ratio_max_denominator must be positive")
+	case c.Media.RatioTolerance < 0 || c.Media.RatioTolerance >= 1:
+		return fmt.Errorf("media.ratio_tolerance must be between 0 and 1")
+	case c.Media.SquareTolerance < 0 || c.Media.SquareTolerance >= 1:
+		return fmt.Errorf("media.square_tolerance must be between 0 and 1")
+	case c.Media.FormatMatchTolerance < 0:
+		return fmt.Errorf("media.format_match_tolerance must not be negative")
+	case c.Media.SkipTranscodeMaxBitRate <= 0:
+		return fmt.Errorf("media.skip_transcode_max_bitrate must be positive")
+	case c.Media.TranscodeMinBytes < 0:
+		return fmt.Errorf("media.transcode_min_bytes must not be negative")
+	case c.Media.TranscodeBitrateFloor < 0:
+		return fmt.Errorf("media.transcode_bitrate_floor must not be negative")
+	case c.Media.FFmpegTimeout < 0:
+		return fmt.Errorf("media.ffmpeg_timeout must not be negative")
+	case c.Media.MaxGIFFrames <= 0:
+		return fmt.Errorf("media.max_gif_frames must be positive")
+	case c.Media.JPEGQuality < 1 || c.Media.JPEGQuality > 100:
+		return fmt.Errorf("media.jpeg_quality must be between 1 and 100")
+	case c.Media.WebPQuality < 1 || c.Media.WebPQuality > 100:
+		return fmt.Errorf("media.webp_quality must be between 1 and 100")
+	case !validOutputFormats(c.Media.AllowedOutputFormats):
+		return fmt.Errorf("media.allowed_output_formats may only list gif and webp, got %q", c.Media.AllowedOutputFormats)
+	case c.Media.NoVideoStreamPolicy != "audio" && c.Media.NoVideoStreamPolicy != "reject":
+		return fmt.Errorf("media.no_video_stream_policy must be audio or reject, got %q", c.Media.NoVideoStreamPolicy)
+	case !validEncoderNames(c.Media.VideoCodecs):
+		return fmt.Errorf("media.video_codecs must list ffmpeg encoder names like libx264 or h264_nvenc, got %q", c.Media.VideoCodecs)
+	case !validAudioBitrate(c.Media.AudioBitrate):
+		return fmt.Errorf("media.audio_bitrate must be between 32k and 512k, e.g. 128k, got %q", c.Media.AudioBitrate)
+	}
+	return nil
+}
+
+func validChecksumAlgorithm(alg string) bool {
+	switch alg {
+	case "none", "crc32", "crc32c", "sha1", "sha256", "md5":
+		return true
+	}
+	return false
+}
+
+func validOutputFormats(formats []string) bool {
+	for _, format := range formats {
+		if format != "gif" && format != "webp" {
+			return false
+		}
+	}
+	return true
+}
+
+// validEncoderNames accepts ffmpeg encoder names, which end up on its command line
+func validEncoderNames(names []string) bool {
+	for _, name := range names {
+		if name == "" || name[0] == '-' || strings.TrimLeft(strings.ToLower(name), "abcdefghijklmnopqrstuvwxyz0123456789_-") != "" {
+			return false
+		}
+	}
+	return true
+}
+
+// validAudioBitrate accepts bitrates like 128k or 128000 in the range AAC encoders support
+func validAudioBitrate(bitrate string) bool {
+	bits, err := strconv.Atoi(strings.TrimSuffix(bitrate, "k"))
+	if err != nil {
+		return false
+	}
+	if strings.HasSuffix(bitrate, "k") {
+		bits *= 1000
+	}
+	return bits >= 32_000 && bits <= 512_000
+}
+
+// ParseMode parses octal permission bits like 0600 or 600
+func ParseMode(s string) (os.FileMode, error) {
+	mode, err := strconv.ParseUint(strings.TrimPrefix(s, "0o"), 8, 32)
+	if err != nil || mode > 0o777 {
+		return 0, fmt.Errorf("invalid octal mode %q", s)
+	}
+	return os.FileMode(mode), nil
+}
+
+func validLogLevel(level string) bool {
+	switch level {
+	case "debug", "info", "warn", "error":
+		return true
+	}
+	return false
+}
+
+func setString(dst *string, key string) {
+	if v, ok := os.LookupEnv(key); ok {
+		*dst = v
+	}
+}
+
+// setStringList reads a comma separated list, ignoring empty entries
+func setStringList(dst *[]string, key string) {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		var list []string
+		for _, item := range strings.Split(v, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				list = append(list, item)
+			}
+		}
+		*dst = list
+	}
+}
+
+func setInt(dst *int, key string) error {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", key, v, err)
+		}
+		*dst = n
+	}
+	return nil
+}
+
+func setInt64(dst *int64, key string) error {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		n, err := strconv.ParseInt(v, 10, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", key, v, err)
+		}
+		*dst = n
+	}
+	return nil
+}
+
+func setFloat(dst *float64, key string) error {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		f, err := strconv.ParseFloat(v, 64)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", key, v, err)
+		}
+		*dst = f
+	}
+	return nil
+}
+
+func setBool(dst *bool, key string) error {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		b, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", key, v, err)
+		}
+		*dst = b
+	}
+	return nil
+}
+
+func setDuration(dst *time.Duration, key string) error {
+	if v, ok := os.LookupEnv(key); ok && v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return fmt.Errorf("invalid %s %q: %w", key, v, err)
+		}
+		*dst = d
+	}
+	return nil
+}
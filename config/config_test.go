@@ -0,0 +1,19 @@
+package config
+
+import "testing"
+
+func TestLoadDefaults(t *testing.T) {
+	t.Setenv("CONFIG_FILE", "")
+	t.Setenv("AWS_ACCESS_KEY_ID", "key")
+	t.Setenv("AWS_SECRET_ACCESS_KEY", "secret")
+	t.Setenv("AWS_REGION", "us-east-1")
+	t.Setenv("AWS_S3_BUCKET", "assets")
+
+	cfg, err := Load()
+	if err != nil {
+		t.Fatalf("Load() with only the required settings failed: %v", err)
+	}
+	if cfg.Media.RatioMaxDenominator != 100 {
+		t.Errorf("RatioMaxDenominator = %d, want 100", cfg.Media.RatioMaxDenominator)
+	}
+}
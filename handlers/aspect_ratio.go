@@ -1,10 +1,17 @@
 package handlers
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net/http"
-	"net/url"
+	"strconv"
+	"sync"
+	"time"
 
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/asset_upload_service/models"
+	"github.com/asset_upload_service/services"
 	"github.com/asset_upload_service/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -15,31 +22,155 @@ func (h *UploadHandler) GetVideoAspectRatioHandler(c *gin.Context) {
 	// Get the video URL from the query parameter
 	videoURL := c.Query("url")
 	if videoURL == "" {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Missing required 'url' parameter",
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "missing_url",
+			Message: "Missing required 'url' parameter",
 		})
 		return
 	}
 
 	// Validate the URL
-	_, err := url.ParseRequestURI(videoURL)
-	if err != nil {
-		c.JSON(http.StatusBadRequest, gin.H{
-			"error": "Invalid URL format",
+	if err := utils.ValidateSourceURL(videoURL, h.cfg.RemoteFetch.RequireHTTPS); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_url",
+			Message: err.Error(),
 		})
 		return
 	}
 
 	// Get the aspect ratio from the URL
-	aspectRatio, err := utils.GetVideoAspectRatioFromURL(videoURL)
+	aspectRatio, err := utils.GetVideoAspectRatioFromURL(c.Request.Context(), videoURL, h.fetchOptions())
 	if err != nil {
 		logrus.Errorf("Failed to get aspect ratio: %v", err)
-		c.JSON(http.StatusInternalServerError, gin.H{
-			"error": fmt.Sprintf("Failed to get aspect ratio: %v", err),
+		status, code := aspectRatioError(err)
+		c.JSON(status, models.ErrorResponse{
+			Code:    code,
+			Message: fmt.Sprintf("Failed to get aspect ratio: %v", err),
 		})
 		return
 	}
+	if c.Query("rank_formats") == "true" {
+		aspectRatio.RankedFormats = rankFormats(services.DefaultResizer(), aspectRatio.Width, aspectRatio.Height)
+	}
 
 	// Return the aspect ratio
 	c.JSON(http.StatusOK, aspectRatio)
 }
+
+const maxBatchURLs = 100
+
+// GetVideoAspectRatioBatchHandler retrieves the aspect ratios for a JSON array of video URLs.
+// URLs are processed concurrently with a bounded pool and results keep the input order.
+func (h *UploadHandler) GetVideoAspectRatioBatchHandler(c *gin.Context) {
+	var urls []string
+	if err := c.ShouldBindJSON(&urls); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_request",
+			Message: "Request body must be a JSON array of URLs",
+		})
+		return
+	}
+	if len(urls) == 0 {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "missing_url",
+			Message: "At least one URL is required",
+		})
+		return
+	}
+	if len(urls) > maxBatchURLs {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "too_many_urls",
+			Message: fmt.Sprintf("Too many URLs, maximum is %d", maxBatchURLs),
+		})
+		return
+	}
+
+	// Clients can lower or raise the parallelism up to the server's maximum
+	concurrency := min(h.cfg.Batch.Concurrency, h.cfg.Batch.MaxConcurrency)
+	if v := c.Query("concurrency"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Code:    "invalid_concurrency",
+				Message: "concurrency must be a positive number",
+			})
+			return
+		}
+		concurrency = min(n, h.cfg.Batch.MaxConcurrency)
+	}
+	wantRanked := c.Query("rank_formats") == "true"
+	wantTiming := c.Query("debug") == "true"
+	timeout := h.cfg.Batch.URLTimeout
+
+	results := make([]models.VideoAspectRatioResult, len(urls))
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+
+	for i, videoURL := range urls {
+		results[i].URL = videoURL
+		if err := utils.ValidateSourceURL(videoURL, h.cfg.RemoteFetch.RequireHTTPS); err != nil {
+			results[i].Code = "invalid_url"
+			results[i].Error = err.Error()
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, videoURL string) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			ctx, cancel := context.WithTimeout(c.Request.Context(), timeout)
+			defer cancel()
+			if wantTiming {
+				start := time.Now()
+				defer func() { results[i].DurationMS = time.Since(start).Milliseconds() }()
+			}
+
+			aspectRatio, err := utils.GetVideoAspectRatioFromURL(ctx, videoURL, h.fetchOptions())
+			if err != nil {
+				logrus.Errorf("Failed to get aspect ratio for %s: %v", videoURL, err)
+				_, results[i].Code = aspectRatioError(err)
+				results[i].Error = fmt.Sprintf("Failed to get aspect ratio: %v", err)
+				return
+			}
+			if wantRanked {
+				aspectRatio.RankedFormats = rankFormats(services.DefaultResizer(), aspectRatio.Width, aspectRatio.Height)
+			}
+			results[i].AspectRatio = aspectRatio
+		}(i, videoURL)
+	}
+	wg.Wait()
+
+	c.JSON(http.StatusOK, results)
+}
+
+// aspectRatioError maps a failed aspect ratio lookup to a status and error code. The
+// video either couldn't be fetched or ffprobe couldn't read it.
+func aspectRatioError(err error) (int, string) {
+	switch {
+	case errors.Is(err, mediaexec.ErrTimeout):
+		return http.StatusUnprocessableEntity, "processing_timeout"
+	case errors.Is(err, utils.ErrProbeFailed):
+		return http.StatusUnprocessableEntity, "probe_failed"
+	case errors.Is(err, utils.ErrRemoteNotFound):
+		return http.StatusNotFound, "download_failed"
+	case errors.Is(err, utils.ErrRemoteTimeout):
+		return http.StatusGatewayTimeout, "download_failed"
+	case errors.Is(err, utils.ErrSourceTooLarge):
+		return http.StatusUnprocessableEntity, "source_too_large"
+	case errors.Is(err, utils.ErrBlockedAddress):
+		return http.StatusBadRequest, "invalid_url"
+	}
+	return http.StatusBadGateway, "download_failed"
+}
+
+// fetchOptions returns the configured settings for downloading remote files
+func (h *UploadHandler) fetchOptions() utils.FetchOptions {
+	return utils.FetchOptions{
+		Timeout:              h.cfg.RemoteFetch.Timeout,
+		MaxRetries:           h.cfg.RemoteFetch.Retries,
+		MaxBytes:             int64(h.cfg.RemoteFetch.MaxMB) << 20,
+		AllowPrivateNetworks: h.cfg.RemoteFetch.AllowPrivateNetworks,
+	}
+}
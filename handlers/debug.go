@@ -0,0 +1,84 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/asset_upload_service/middleware"
+	"github.com/asset_upload_service/models"
+	"github.com/gin-gonic/gin"
+)
+
+// debugAllowed checks that requests with debug=true carry an admin API key, ffmpeg
+// output reveals paths and build details. It writes the error response and returns
+// false otherwise.
+func (h *UploadHandler) debugAllowed(c *gin.Context) bool {
+	if c.Request.FormValue("debug") != "true" || middleware.HasAPIKey(c, h.cfg.Auth.AdminAPIKeys) {
+		return true
+	}
+	c.JSON(http.StatusForbidden, models.UploadResponse{
+		Code:    "admin_key_required",
+		Message: "debug=true requires an admin API key",
+	})
+	return false
+}
+
+// Phases of an upload reported in the timings of debug=true responses
+type phase int
+
+const (
+	phaseRead phase = iota
+	phaseMetadata
+	phaseTranscode
+	phaseUpload
+	phaseCount
+)
+
+// phaseTimer attributes the time spent on an upload to phases. Methods are no-ops on
+// a nil timer, which is what uploads without debug=true get.
+type phaseTimer struct {
+	start time.Time
+	last  time.Time
+	spent [phaseCount]time.Duration
+}
+
+// newPhaseTimer returns a timer for an upload that started at start, nil unless enabled
+func newPhaseTimer(start time.Time, enabled bool) *phaseTimer {
+	if !enabled {
+		return nil
+	}
+	return &phaseTimer{start: start, last: start}
+}
+
+// lap adds the time since the previous lap to p
+func (t *phaseTimer) lap(p phase) {
+	if t == nil {
+		return
+	}
+	now := time.Now()
+	t.spent[p] += now.Sub(t.last)
+	t.last = now
+}
+
+// skip starts the next lap without attributing the time since the previous one, e.g.
+// to moderation; it still counts towards the total
+func (t *phaseTimer) skip() {
+	if t == nil {
+		return
+	}
+	t.last = time.Now()
+}
+
+// timings returns the time spent per phase so far
+func (t *phaseTimer) timings() *models.Timings {
+	if t == nil {
+		return nil
+	}
+	return &models.Timings{
+		ReadMS:      t.spent[phaseRead].Milliseconds(),
+		MetadataMS:  t.spent[phaseMetadata].Milliseconds(),
+		TranscodeMS: t.spent[phaseTranscode].Milliseconds(),
+		UploadMS:    t.spent[phaseUpload].Milliseconds(),
+		TotalMS:     time.Since(t.start).Milliseconds(),
+	}
+}
@@ -0,0 +1,77 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/asset_upload_service/models"
+	"github.com/gin-gonic/gin"
+)
+
+// dimensionBounds holds the optional min_width, min_height, max_width and max_height
+// upload options. A zero value means the bound is not set.
+type dimensionBounds struct {
+	MinWidth  int
+	MinHeight int
+	MaxWidth  int
+	MaxHeight int
+}
+
+// parseDimensionBounds reads the dimension bounds from the request form
+func parseDimensionBounds(c *gin.Context) (dimensionBounds, error) {
+	var b dimensionBounds
+	for _, field := range []struct {
+		name string
+		dst  *int
+	}{
+		{"min_width", &b.MinWidth},
+		{"min_height", &b.MinHeight},
+		{"max_width", &b.MaxWidth},
+		{"max_height", &b.MaxHeight},
+	} {
+		v := c.Request.FormValue(field.name)
+		if v == "" {
+			continue
+		}
+		n, err := strconv.Atoi(v)
+		if err != nil || n <= 0 {
+			return b, fmt.Errorf("%s must be a positive integer, got %q", field.name, v)
+		}
+		*field.dst = n
+	}
+
+	if b.MinWidth > 0 && b.MaxWidth > 0 && b.MinWidth > b.MaxWidth {
+		return b, fmt.Errorf("min_width (%d) is greater than max_width (%d)", b.MinWidth, b.MaxWidth)
+	}
+	if b.MinHeight > 0 && b.MaxHeight > 0 && b.MinHeight > b.MaxHeight {
+		return b, fmt.Errorf("min_height (%d) is greater than max_height (%d)", b.MinHeight, b.MaxHeight)
+	}
+	return b, nil
+}
+
+// check returns an error describing the first bound the dimensions violate
+func (b dimensionBounds) check(width, height int) error {
+	switch {
+	case b.MinWidth > 0 && width < b.MinWidth:
+		return fmt.Errorf("width %d is below the minimum of %d", width, b.MinWidth)
+	case b.MinHeight > 0 && height < b.MinHeight:
+		return fmt.Errorf("height %d is below the minimum of %d", height, b.MinHeight)
+	case b.MaxWidth > 0 && width > b.MaxWidth:
+		return fmt.Errorf("width %d exceeds the maximum of %d", width, b.MaxWidth)
+	case b.MaxHeight > 0 && height > b.MaxHeight:
+		return fmt.Errorf("height %d exceeds the maximum of %d", height, b.MaxHeight)
+	}
+	return nil
+}
+
+// rejectDimensions returns a dimensions_out_of_range error carrying the actual size
+func rejectDimensions(fileName string, width, height int, err error) (int, models.UploadResponse) {
+	return http.StatusUnprocessableEntity, models.UploadResponse{
+		Code:     "dimensions_out_of_range",
+		Message:  fmt.Sprintf("Dimensions %dx%d are out of range: %v", width, height, err),
+		FileName: fileName,
+		Width:    width,
+		Height:   height,
+	}
+}
@@ -0,0 +1,158 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"net/textproto"
+	"os"
+	"sync"
+	"testing"
+
+	"github.com/asset_upload_service/config"
+	"github.com/asset_upload_service/jobs"
+	"github.com/asset_upload_service/keys"
+	"github.com/asset_upload_service/models"
+	"github.com/asset_upload_service/moderation"
+	"github.com/asset_upload_service/storage"
+	"github.com/asset_upload_service/utils"
+	"github.com/gin-gonic/gin"
+)
+
+// testBucketURL is where memoryStorage pretends objects are served from
+const testBucketURL = "https://assets.example.com/"
+
+// memoryStorage keeps uploaded objects in memory
+type memoryStorage struct {
+	mu      sync.Mutex
+	objects map[string][]byte
+}
+
+func (s *memoryStorage) Upload(file *os.File, key string, opts storage.UploadOptions) (*storage.UploadResult, error) {
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return nil, err
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.objects[key] = data
+	return &storage.UploadResult{URL: testBucketURL + key}, nil
+}
+
+func (s *memoryStorage) Download(key string, file *os.File) (string, error) {
+	s.mu.Lock()
+	data, ok := s.objects[key]
+	s.mu.Unlock()
+	if !ok {
+		return "", storage.ErrNotFound
+	}
+	if _, err := file.Write(data); err != nil {
+		return "", err
+	}
+	return testBucketURL + key, nil
+}
+
+func (s *memoryStorage) ObjectURL(key string) (string, error) {
+	return testBucketURL + key, nil
+}
+
+// keys returns the keys of the stored objects
+func (s *memoryStorage) keys() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var keys []string
+	for k := range s.objects {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+// newTestHandler returns a handler with the default configuration, storing into
+// memory. Temp files go to a directory of the test, utils.TempDir() while it runs.
+func newTestHandler(t *testing.T) (*UploadHandler, *memoryStorage) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	cfg := config.Default()
+	if err := utils.SetTempDir(t.TempDir(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keyGen, err := keys.New(cfg.Storage.KeyStrategy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := &memoryStorage{objects: map[string][]byte{}}
+	h := NewUploadHandler(cfg, store, jobs.NewStore(cfg.Jobs.TTL), jobs.NewPool(1, 1), moderation.New(cfg.Moderation), keyGen)
+	return h, store
+}
+
+// formPart is a field or file of a test upload form
+type formPart struct {
+	field, fileName string
+	data            []byte
+	header          textproto.MIMEHeader
+}
+
+// multipartForm encodes parts as a multipart/form-data body and returns it with its
+// Content-Type
+func multipartForm(t *testing.T, parts ...formPart) ([]byte, string) {
+	t.Helper()
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for _, p := range parts {
+		header := textproto.MIMEHeader{}
+		if p.fileName != "" {
+			header.Set("Content-Disposition", fmt.Sprintf(`form-data; name=%q; filename=%q`, p.field, p.fileName))
+			header.Set("Content-Type", "application/octet-stream")
+		} else {
+			header.Set("Content-Disposition", `form-data; name="`+p.field+`"`)
+		}
+		for k, v := range p.header {
+			header[k] = v
+		}
+		pw, err := w.CreatePart(header)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := pw.Write(p.data); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+	return body.Bytes(), w.FormDataContentType()
+}
+
+// fileUpload is a form holding data in the file field
+func fileUpload(t *testing.T, fileName string, data []byte) ([]byte, string) {
+	return multipartForm(t, formPart{field: "file", fileName: fileName, data: data})
+}
+
+// serve runs handler on a POST of body and decodes the response
+func serve(t *testing.T, handler gin.HandlerFunc, req *http.Request) (int, models.UploadResponse) {
+	t.Helper()
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = req
+	handler(c)
+
+	var response models.UploadResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &response); err != nil {
+		t.Fatalf("invalid JSON response %q: %v", w.Body.String(), err)
+	}
+	return w.Code, response
+}
+
+// uploadRequest is a POST of body to path with the given Content-Type
+func uploadRequest(path string, body []byte, contentType string) *http.Request {
+	req := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(body))
+	if contentType != "" {
+		req.Header.Set("Content-Type", contentType)
+	}
+	return req
+}
@@ -0,0 +1,98 @@
+package handlers
+
+import (
+	"maps"
+	"net/http"
+	"os"
+
+	"github.com/asset_upload_service/models"
+	"github.com/asset_upload_service/storage"
+	"github.com/asset_upload_service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// startAsyncUpload stores the original file, responds with a pending job and processes
+// the file in the background. The job's result is what the synchronous upload would
+// have returned; processed output is stored next to the original as usual.
+func (h *UploadHandler) startAsyncUpload(c *gin.Context, fileBytes, contentHash []byte, fileName, originalFileName, keyPrefix string, bounds dimensionBounds, meta map[string]string, timer *phaseTimer) {
+	// Refuse before storing anything when the workers can't keep up
+	if h.pool.Full() {
+		rejectQueueFull(c)
+		return
+	}
+
+	key := keyPrefix + fileName
+	result, err := h.uploadBytes(fileBytes, key, storage.UploadOptions{SHA256: contentHash})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.UploadResponse{
+			Message: "Failed to upload to S3: " + err.Error(),
+		})
+		return
+	}
+
+	timer.lap(phaseUpload)
+
+	job := h.jobs.Create(key, result.URL, meta)
+	form := maps.Clone(c.Request.Form)
+	queued := h.pool.Submit(func() {
+		h.jobs.Start(job.ID)
+		// Time spent in the queue isn't part of any phase
+		timer.skip()
+		status, response := h.processUpload(form, fileBytes, contentHash, fileName, originalFileName, keyPrefix, bounds, result.URL, timer)
+		response.Meta = meta
+		h.jobs.Finish(job.ID, status, response)
+		logrus.Infof("Async upload job %s for %s finished with status %d", job.ID, key, status)
+	})
+	if !queued {
+		// The queue filled up while the original was being stored
+		h.jobs.Finish(job.ID, http.StatusServiceUnavailable, models.UploadResponse{
+			Message: "Job queue is full",
+			Meta:    meta,
+		})
+		rejectQueueFull(c)
+		return
+	}
+
+	c.Header("Location", "/jobs/"+job.ID)
+	c.JSON(http.StatusAccepted, job)
+}
+
+// rejectQueueFull responds with 503 when no more async jobs can be queued
+func rejectQueueFull(c *gin.Context) {
+	c.Header("Retry-After", "30")
+	c.JSON(http.StatusServiceUnavailable, models.UploadResponse{
+		Code:    "queue_full",
+		Message: "Too many uploads are waiting to be processed, please retry later",
+	})
+}
+
+// GetJobHandler returns the state of an asynchronous upload
+func (h *UploadHandler) GetJobHandler(c *gin.Context) {
+	job, ok := h.jobs.Get(c.Param("id"))
+	if !ok {
+		c.JSON(http.StatusNotFound, gin.H{
+			"error": "Job not found or expired",
+		})
+		return
+	}
+	c.JSON(http.StatusOK, job)
+}
+
+// uploadBytes stores data under key through a temporary file
+func (h *UploadHandler) uploadBytes(data []byte, key string, opts storage.UploadOptions) (*storage.UploadResult, error) {
+	tempFile, err := utils.CreateTemp("upload-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	if _, err := tempFile.Write(data); err != nil {
+		return nil, err
+	}
+	if _, err := tempFile.Seek(0, 0); err != nil {
+		return nil, err
+	}
+	return h.storage.Upload(tempFile, key, opts)
+}
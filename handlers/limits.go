@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Headers HEAD requests on the upload endpoints report the server limits in
+const (
+	HeaderMaxUploadSize    = "X-Max-Upload-Size"
+	HeaderAllowedMimeTypes = "X-Allowed-Mime-Types"
+	HeaderMaxVideoDuration = "X-Max-Video-Duration"
+	HeaderOutputFormats    = "X-Output-Formats"
+)
+
+// LimitHeaders lists the headers HandleLimits sets, for CORS to expose them
+var LimitHeaders = []string{HeaderMaxUploadSize, HeaderAllowedMimeTypes, HeaderMaxVideoDuration, HeaderOutputFormats}
+
+// HandleLimits returns a handler answering HEAD requests on an upload endpoint with
+// the limits its uploads are checked against, so clients can adapt before sending a
+// file instead of getting a 413 or 415 mid-transfer. Endpoints cut videos to their own
+// maxVideoDuration; X-Output-Formats is only sent by those taking output_format, and
+// X-Max-Upload-Size is left out when bodies aren't capped.
+func (h *UploadHandler) HandleLimits(maxVideoDuration int, outputFormats bool) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if h.cfg.MaxUploadMB > 0 {
+			c.Header(HeaderMaxUploadSize, strconv.FormatInt(int64(h.cfg.MaxUploadMB)<<20, 10))
+		}
+		// Any file is stored; images and videos are processed, everything else is kept as is
+		c.Header(HeaderAllowedMimeTypes, "*/*")
+		// Longer videos aren't rejected, they are cut to this many seconds when processed
+		c.Header(HeaderMaxVideoDuration, strconv.Itoa(maxVideoDuration))
+		if outputFormats {
+			c.Header(HeaderOutputFormats, strings.Join(h.cfg.Media.AllowedOutputFormats, ","))
+		}
+		c.Status(http.StatusOK)
+	}
+}
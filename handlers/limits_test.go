@@ -0,0 +1,40 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/asset_upload_service/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleLimits(t *testing.T) {
+	h, _ := newTestHandler(t)
+	h.cfg.Media.AllowedOutputFormats = []string{"gif", "webp"}
+	tests := []struct {
+		path          string
+		handler       gin.HandlerFunc
+		duration      string
+		outputFormats string
+	}{
+		{"/upload", h.HandleLimits(utils.MaxVideoDuration, true), "59", "gif,webp"},
+		{"/upload/simple", h.HandleLimits(utils.SimpleVideoDuration, false), "30", ""},
+	}
+	for _, tt := range tests {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodHead, tt.path, nil)
+		tt.handler(c)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("HEAD %s: status %d, want 200", tt.path, w.Code)
+		}
+		if got := w.Header().Get(HeaderMaxVideoDuration); got != tt.duration {
+			t.Errorf("HEAD %s: %s = %q, want %q", tt.path, HeaderMaxVideoDuration, got, tt.duration)
+		}
+		if got := w.Header().Get(HeaderOutputFormats); got != tt.outputFormats {
+			t.Errorf("HEAD %s: %s = %q, want %q", tt.path, HeaderOutputFormats, got, tt.outputFormats)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+
+	"github.com/asset_upload_service/models"
+)
+
+// maxMetaKeyLength is the longest key accepted in custom metadata
+const maxMetaKeyLength = 64
+
+// parseMeta returns the custom metadata of an upload, sent as meta[<key>] fields or
+// as a meta field holding a JSON object of scalars; meta[<key>] wins where both set a
+// key. Keys are letters, digits, '_', '-' and '.', and the fields and their size are
+// bounded by META_MAX_FIELDS and META_MAX_BYTES. It returns nil when there is none.
+func (h *UploadHandler) parseMeta(form url.Values) (map[string]string, error) {
+	meta := map[string]string{}
+	if raw := form.Get("meta"); raw != "" {
+		decoder := json.NewDecoder(strings.NewReader(raw))
+		decoder.UseNumber()
+		var values map[string]any
+		if err := decoder.Decode(&values); err != nil || values == nil {
+			return nil, fmt.Errorf("meta must be a JSON object")
+		}
+		for k, v := range values {
+			switch v := v.(type) {
+			case string:
+				meta[k] = v
+			case json.Number:
+				meta[k] = v.String()
+			case bool:
+				meta[k] = strconv.FormatBool(v)
+			default:
+				return nil, fmt.Errorf("meta value of %q must be a string, number or boolean", k)
+			}
+		}
+	}
+	for field := range form {
+		if k, ok := strings.CutPrefix(field, "meta["); ok && strings.HasSuffix(k, "]") {
+			meta[strings.TrimSuffix(k, "]")] = form.Get(field)
+		}
+	}
+	if len(meta) == 0 {
+		return nil, nil
+	}
+
+	if len(meta) > h.cfg.MetaMaxFields {
+		return nil, fmt.Errorf("at most %d meta fields are allowed, got %d", h.cfg.MetaMaxFields, len(meta))
+	}
+	size := 0
+	for _, k := range slices.Sorted(maps.Keys(meta)) {
+		if !validMetaKey(k) {
+			return nil, fmt.Errorf("invalid meta key %q, expected up to %d letters, digits, '_', '-' or '.'", k, maxMetaKeyLength)
+		}
+		size += len(k) + len(meta[k])
+	}
+	if size > h.cfg.MetaMaxBytes {
+		return nil, fmt.Errorf("meta is %d bytes, at most %d are allowed", size, h.cfg.MetaMaxBytes)
+	}
+	return meta, nil
+}
+
+func validMetaKey(key string) bool {
+	if key == "" || len(key) > maxMetaKeyLength {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '_' || r == '-' || r == '.') {
+			return false
+		}
+	}
+	return true
+}
+
+// metaObjectMetadata maps custom metadata to object metadata keys, prefixed with meta-
+// so they can't clash with the file's properties. Storage keys only allow lowercase
+// letters, digits and dashes.
+func metaObjectMetadata(meta map[string]string) map[string]string {
+	objectMeta := make(map[string]string, len(meta))
+	for k, v := range meta {
+		key := strings.Map(func(r rune) rune {
+			if r == '_' || r == '.' {
+				return '-'
+			}
+			return r
+		}, strings.ToLower(k))
+		objectMeta["meta-"+key] = v
+	}
+	return objectMeta
+}
+
+// invalidMeta is the response for uploads with metadata parseMeta rejects
+func invalidMeta(err error) (int, models.UploadResponse) {
+	return http.StatusBadRequest, models.UploadResponse{
+		Code:    "invalid_meta",
+		Message: "Invalid meta: " + err.Error(),
+	}
+}
@@ -0,0 +1,26 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// runtimeVars are published by the expvar package itself. They are left out of
+// /debug/vars: cmdline shows the command line, which may hold secrets, and memstats
+// stops the world to collect.
+var runtimeVars = map[string]bool{"cmdline": true, "memstats": true}
+
+// HandleMetrics serves the service's own counters (in-flight requests, job pool and
+// remote probe counters) in expvar's JSON format
+func HandleMetrics(c *gin.Context) {
+	metrics := map[string]json.RawMessage{}
+	expvar.Do(func(kv expvar.KeyValue) {
+		if !runtimeVars[kv.Key] {
+			metrics[kv.Key] = json.RawMessage(kv.Value.String())
+		}
+	})
+	c.JSON(http.StatusOK, metrics)
+}
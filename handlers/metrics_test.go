@@ -0,0 +1,36 @@
+package handlers
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+var testCounter = expvar.NewInt("test_metrics_counter")
+
+func TestHandleMetrics(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	testCounter.Set(3)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = httptest.NewRequest(http.MethodGet, "/debug/vars", nil)
+	HandleMetrics(c)
+
+	var metrics map[string]json.RawMessage
+	if err := json.Unmarshal(w.Body.Bytes(), &metrics); err != nil {
+		t.Fatalf("invalid JSON %q: %v", w.Body.String(), err)
+	}
+	if got := string(metrics["test_metrics_counter"]); got != "3" {
+		t.Errorf("test_metrics_counter = %q, want 3", got)
+	}
+	for name := range runtimeVars {
+		if _, ok := metrics[name]; ok {
+			t.Errorf("%s is published", name)
+		}
+	}
+}
@@ -0,0 +1,43 @@
+package handlers
+
+import (
+	"net/http"
+
+	"github.com/asset_upload_service/models"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// moderate runs the content check on a file before it is stored. It writes the error
+// response and returns false when the file must not be stored.
+func (h *UploadHandler) moderate(c *gin.Context, fileBytes []byte, fileName string) bool {
+	allowed, reason, err := h.moderator.Moderate(c.Request.Context(), fileBytes, http.DetectContentType(fileBytes))
+	if err != nil {
+		if h.cfg.Moderation.FailOpen {
+			logrus.Warnf("Content moderation of %s failed, storing it anyway: %v", fileName, err)
+			return true
+		}
+		logrus.Errorf("Content moderation of %s failed: %v", fileName, err)
+		c.Header("Retry-After", "30")
+		c.JSON(http.StatusServiceUnavailable, models.UploadResponse{
+			Code:     "moderation_unavailable",
+			Message:  "Content moderation is unavailable, please retry later",
+			FileName: fileName,
+		})
+		return false
+	}
+	if !allowed {
+		logrus.Infof("Content moderation rejected %s: %s", fileName, reason)
+		message := "File was rejected by content moderation"
+		if reason != "" {
+			message += ": " + reason
+		}
+		c.JSON(http.StatusUnprocessableEntity, models.UploadResponse{
+			Code:     "content_rejected",
+			Message:  message,
+			FileName: fileName,
+		})
+		return false
+	}
+	return true
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/asset_upload_service/models"
+	"github.com/asset_upload_service/storage"
+	"github.com/asset_upload_service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// presignRequest is the body of POST /presign-upload, as JSON or form fields
+type presignRequest struct {
+	FileName        string `json:"file_name" form:"file_name" binding:"required"`
+	ContentType     string `json:"content_type" form:"content_type"`
+	KeyPrefix       string `json:"key_prefix" form:"key_prefix"`
+	PartitionScheme string `json:"partition_scheme" form:"partition_scheme"`
+	KeyCase         string `json:"key_case" form:"key_case"`
+}
+
+// HandlePresignUpload returns a presigned URL the client uploads the file to directly,
+// so large files don't have to pass through the service
+func (h *UploadHandler) HandlePresignUpload(c *gin.Context) {
+	presigner, ok := h.storage.(storage.Presigner)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, models.ErrorResponse{
+			Code:    "not_supported",
+			Message: "Presigned uploads are not supported by the " + h.cfg.Storage.Backend + " storage backend",
+		})
+		return
+	}
+
+	var req presignRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_request",
+			Message: "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	keyCase, err := h.keyCase(req.KeyCase)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_key",
+			Message: "Invalid object key options: " + err.Error(),
+		})
+		return
+	}
+
+	// Direct uploads are kept under their own prefix so /finalize can't be pointed at
+	// arbitrary objects. The configured prefix keeps its case for the same reason.
+	prefix := strings.Trim(h.cfg.Storage.DirectUploadPrefix, "/") + "/" + utils.ApplyKeyCase(strings.Trim(req.KeyPrefix, "/"), keyCase)
+	keyPrefix, err := h.buildKeyPrefix(prefix, req.PartitionScheme)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_key",
+			Message: "Invalid object key options: " + err.Error(),
+		})
+		return
+	}
+	fileName := utils.ApplyKeyCase(utils.NormalizeFilename(req.FileName, h.cfg.Storage.FilenameStrategy), keyCase)
+	key := keyPrefix + fileName
+	// A key /finalize would reject, e.g. from a file name with slashes, could never be
+	// finalized after the upload
+	if _, _, err := utils.SplitObjectKey(key, h.cfg.Storage.DirectUploadPrefix); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_key",
+			Message: "Invalid file_name or key_prefix: " + err.Error(),
+		})
+		return
+	}
+
+	contentType := req.ContentType
+	if contentType == "" {
+		contentType = mime.TypeByExtension(filepath.Ext(fileName))
+	}
+	if contentType == "" {
+		contentType = "application/octet-stream"
+	}
+
+	presigned, err := presigner.PresignUpload(key, contentType, h.cfg.Storage.PresignExpiry)
+	if err != nil {
+		logrus.Errorf("Failed to presign upload for %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "presign_failed",
+			Message: "Failed to presign upload: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PresignResponse{
+		Key:       key,
+		FileName:  fileName,
+		UploadURL: presigned.UploadURL,
+		Method:    presigned.Method,
+		Headers:   presigned.Headers,
+		FileURL:   presigned.ObjectURL,
+		ExpiresAt: presigned.ExpiresAt,
+	})
+}
+
+// presignDownloadRequest is the body of POST /presign-download, as JSON or form fields
+type presignDownloadRequest struct {
+	Key                string `json:"key" form:"key" binding:"required"`
+	ContentDisposition string `json:"content_disposition" form:"content_disposition"`
+	FileName           string `json:"file_name" form:"file_name"`
+	ContentType        string `json:"content_type" form:"content_type"`
+}
+
+// HandlePresignDownload returns a presigned URL the client downloads an object from
+// directly, e.g. from a private bucket. The response headers of the download can be
+// overridden to force a download name ("download as") or a content type.
+func (h *UploadHandler) HandlePresignDownload(c *gin.Context) {
+	presigner, ok := h.storage.(storage.Presigner)
+	if !ok {
+		c.JSON(http.StatusNotImplemented, models.ErrorResponse{
+			Code:    "not_supported",
+			Message: "Presigned downloads are not supported by the " + h.cfg.Storage.Backend + " storage backend",
+		})
+		return
+	}
+
+	var req presignDownloadRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_request",
+			Message: "Invalid request: " + err.Error(),
+		})
+		return
+	}
+	if _, _, err := utils.SplitObjectKey(req.Key, ""); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_key",
+			Message: "Invalid key: " + err.Error(),
+		})
+		return
+	}
+	// Only objects meant to be handed out, anything else in the bucket stays private
+	prefix := strings.Trim(h.cfg.Storage.PresignDownloadPrefix, "/")
+	if _, _, err := utils.SplitObjectKey(req.Key, prefix); prefix == "" || err != nil {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Code:    "key_not_allowed",
+			Message: "Object " + req.Key + " is outside PRESIGN_DOWNLOAD_PREFIX and can't be presigned",
+		})
+		return
+	}
+
+	// A download name alone means the file should be saved, not displayed
+	var overrides storage.ResponseHeaders
+	disposition := req.ContentDisposition
+	if disposition == "" && req.FileName != "" {
+		disposition = "attachment"
+	}
+	if disposition != "" {
+		if !utils.ValidDisposition(disposition) {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Code:    "invalid_request",
+				Message: "Unsupported content_disposition: " + disposition + " (expected inline or attachment)",
+			})
+			return
+		}
+		fileName := req.FileName
+		if fileName == "" {
+			fileName = path.Base(req.Key)
+		}
+		overrides.ContentDisposition = utils.ContentDisposition(disposition, fileName)
+	}
+	if req.ContentType != "" {
+		mediaType, params, err := mime.ParseMediaType(req.ContentType)
+		if err != nil || !strings.Contains(mediaType, "/") {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Code:    "invalid_request",
+				Message: "Invalid content_type: " + req.ContentType + " (expected a media type like application/pdf)",
+			})
+			return
+		}
+		overrides.ContentType = mime.FormatMediaType(mediaType, params)
+	}
+
+	downloadURL, err := presigner.PresignDownload(req.Key, overrides, h.cfg.Storage.PresignExpiry)
+	if err != nil {
+		logrus.Errorf("Failed to presign download for %s: %v", req.Key, err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "presign_failed",
+			Message: "Failed to presign download: " + err.Error(),
+		})
+		return
+	}
+
+	c.JSON(http.StatusOK, models.PresignDownloadResponse{
+		Key:                req.Key,
+		DownloadURL:        downloadURL,
+		ContentDisposition: overrides.ContentDisposition,
+		ContentType:        overrides.ContentType,
+		ExpiresAt:          time.Now().Add(h.cfg.Storage.PresignExpiry).UTC(),
+	})
+}
+
+// HandleFinalize processes an object that was uploaded through a presigned URL. It
+// takes the object's key and the same options as /upload (as form fields or query
+// parameters), downloads the object and returns the usual upload response. The
+// object is only stored again when processing changed it.
+func (h *UploadHandler) HandleFinalize(c *gin.Context) {
+	start := time.Now()
+	defer removeSpillFiles(c.Request)
+	if err := h.parseMultipartForm(c); err != nil && !errors.Is(err, http.ErrNotMultipart) {
+		c.JSON(formParseError(err))
+		return
+	}
+
+	key := c.Request.FormValue("key")
+	keyPrefix, fileName, err := utils.SplitObjectKey(key, h.cfg.Storage.DirectUploadPrefix)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Message: "Invalid key: " + err.Error(),
+		})
+		return
+	}
+
+	bounds, err := parseDimensionBounds(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Message: "Invalid dimension bounds: " + err.Error(),
+		})
+		return
+	}
+
+	meta, err := h.parseMeta(c.Request.Form)
+	if err != nil {
+		c.JSON(invalidMeta(err))
+		return
+	}
+
+	tempFile, err := utils.CreateTemp("finalize-*")
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.UploadResponse{
+			Message: "Failed to create temporary file: " + err.Error(),
+		})
+		return
+	}
+	defer os.Remove(tempFile.Name())
+	defer tempFile.Close()
+
+	storedURL, err := h.storage.Download(key, tempFile)
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.UploadResponse{
+			Message: "Object not found: " + key,
+		})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to download %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, models.UploadResponse{
+			Message: "Failed to download object: " + err.Error(),
+		})
+		return
+	}
+
+	if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+		c.JSON(http.StatusInternalServerError, models.UploadResponse{
+			Message: "Failed to read file: " + err.Error(),
+		})
+		return
+	}
+	fileBytes, contentHash, err := utils.ReadAllSHA256(tempFile)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.UploadResponse{
+			Message: "Failed to read file: " + err.Error(),
+		})
+		return
+	}
+	if len(fileBytes) == 0 {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:     "empty_file",
+			Message:  "File is empty",
+			FileName: fileName,
+		})
+		return
+	}
+
+	// The object is already stored, a rejection only means it isn't processed
+	if !h.debugAllowed(c) {
+		return
+	}
+	// Reading the upload means downloading the stored object here
+	timer := newPhaseTimer(start, c.Request.FormValue("debug") == "true")
+	timer.lap(phaseRead)
+	if !h.moderate(c, fileBytes, fileName) {
+		return
+	}
+	timer.skip()
+
+	status, response := h.processUpload(c.Request.Form, fileBytes, contentHash, fileName, fileName, keyPrefix, bounds, storedURL, timer)
+	response.Meta = meta
+	c.JSON(status, response)
+}
@@ -0,0 +1,102 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/asset_upload_service/storage"
+)
+
+// presignStorage is a memoryStorage that signs URLs
+type presignStorage struct {
+	*memoryStorage
+}
+
+func (s *presignStorage) PresignUpload(key, contentType string, expires time.Duration) (*storage.PresignedUpload, error) {
+	return &storage.PresignedUpload{
+		UploadURL: testBucketURL + key + "?signed",
+		Method:    http.MethodPut,
+		Headers:   map[string]string{"Content-Type": contentType},
+		ObjectURL: testBucketURL + key,
+		ExpiresAt: time.Now().Add(expires),
+	}, nil
+}
+
+func (s *presignStorage) PresignDownload(key string, overrides storage.ResponseHeaders, expires time.Duration) (string, error) {
+	return testBucketURL + key + "?signed", nil
+}
+
+// jsonRequest is a POST of a JSON body to path
+func jsonRequest(path, body string) *http.Request {
+	req, _ := http.NewRequest(http.MethodPost, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	return req
+}
+
+func TestPresignUploadValidatesKey(t *testing.T) {
+	tests := []struct {
+		prefix string
+		body   string
+		status int
+		key    string
+	}{
+		{"direct", `{"file_name":"photo.jpg"}`, http.StatusOK, "direct/photo.jpg"},
+		{"direct", `{"file_name":"photo.jpg","key_prefix":"users/42"}`, http.StatusOK, "direct/users/42/photo.jpg"},
+		{"", `{"file_name":"photo.jpg"}`, http.StatusOK, "photo.jpg"},
+		// Slashes in the name are subfolders, as long as /finalize accepts the key
+		{"direct", `{"file_name":"a/b.jpg"}`, http.StatusOK, "direct/a/b.jpg"},
+		{"direct", `{"file_name":"/photo.jpg"}`, http.StatusBadRequest, ""},
+		{"direct", `{"file_name":"../photo.jpg"}`, http.StatusBadRequest, ""},
+		{"direct", `{"file_name":"a//photo.jpg"}`, http.StatusBadRequest, ""},
+		{"direct", `{"file_name":"photo.jpg","key_prefix":"a//b"}`, http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		h, store := newTestHandler(t)
+		h.storage = &presignStorage{store}
+		h.cfg.Storage.DirectUploadPrefix = tt.prefix
+
+		status, response := serve(t, h.HandlePresignUpload, jsonRequest("/presign-upload", tt.body))
+		if status != tt.status || response.Key != tt.key {
+			t.Errorf("%s with prefix %q: got %d key %q (%s), want %d key %q", tt.body, tt.prefix, status, response.Key, response.Message, tt.status, tt.key)
+		}
+		if status == http.StatusBadRequest && response.Code != "invalid_key" {
+			t.Errorf("%s: code = %q, want invalid_key", tt.body, response.Code)
+		}
+	}
+}
+
+func TestPresignUploadNotSupported(t *testing.T) {
+	h, _ := newTestHandler(t)
+	status, response := serve(t, h.HandlePresignUpload, jsonRequest("/presign-upload", `{"file_name":"photo.jpg"}`))
+	if status != http.StatusNotImplemented || response.Code != "not_supported" {
+		t.Errorf("got %d %q (%s), want 501 not_supported", status, response.Code, response.Message)
+	}
+}
+
+func TestPresignDownloadNeedsPrefix(t *testing.T) {
+	tests := []struct {
+		prefix string
+		key    string
+		status int
+		code   string
+	}{
+		{"", "public/report.pdf", http.StatusForbidden, "key_not_allowed"},
+		{"public", "public/report.pdf", http.StatusOK, ""},
+		{"public/", "public/2024/report.pdf", http.StatusOK, ""},
+		{"public", "private/report.pdf", http.StatusForbidden, "key_not_allowed"},
+		{"public", "publication.pdf", http.StatusForbidden, "key_not_allowed"},
+		{"public", "public/../private/report.pdf", http.StatusBadRequest, "invalid_key"},
+	}
+	for _, tt := range tests {
+		h, store := newTestHandler(t)
+		h.storage = &presignStorage{store}
+		h.cfg.Storage.PresignDownloadPrefix = tt.prefix
+
+		status, response := serve(t, h.HandlePresignDownload, jsonRequest("/presign-download", `{"key":"`+tt.key+`"}`))
+		if status != tt.status || response.Code != tt.code {
+			t.Errorf("%s with prefix %q: got %d %q (%s), want %d %q", tt.key, tt.prefix, status, response.Code, response.Message, tt.status, tt.code)
+		}
+	}
+}
@@ -0,0 +1,284 @@
+package handlers
+
+import (
+	"cmp"
+	"errors"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/asset_upload_service/models"
+	"github.com/asset_upload_service/storage"
+	"github.com/asset_upload_service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// reprocessRequest is the body of POST /reprocess, as JSON or form fields. The video
+// is named by its key or its URL.
+type reprocessRequest struct {
+	Key              string `json:"key" form:"key"`
+	URL              string `json:"url" form:"url"`
+	TargetKey        string `json:"target_key" form:"target_key"`
+	Overwrite        bool   `json:"overwrite" form:"overwrite"`
+	VideoCodec       string `json:"video_codec" form:"video_codec"`
+	Faststart        *bool  `json:"faststart" form:"faststart"`
+	KeyframeInterval string `json:"keyframe_interval" form:"keyframe_interval"`
+	NormalizeStreams bool   `json:"normalize_streams" form:"normalize_streams"`
+	DownmixStereo    bool   `json:"downmix_stereo" form:"downmix_stereo"`
+}
+
+// HandleReprocess transcodes a stored video again with new settings, e.g. after the
+// encoding standards changed. The result is stored next to the source under
+// <name>_reprocessed.mp4 or target_key, or replaces the source with overwrite. Both
+// objects must be in the storage bucket under REPROCESS_PREFIX.
+func (h *UploadHandler) HandleReprocess(c *gin.Context) {
+	var req reprocessRequest
+	if err := c.ShouldBind(&req); err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_request",
+			Message: "Invalid request: " + err.Error(),
+		})
+		return
+	}
+
+	key := req.Key
+	if (req.Key == "") == (req.URL == "") {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_request",
+			Message: "Exactly one of key and url is required",
+		})
+		return
+	}
+	if req.URL != "" {
+		var err error
+		if key, err = storage.KeyFromURL(h.storage, req.URL); err != nil {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Code:    "source_not_owned",
+				Message: "Invalid url: " + err.Error(),
+			})
+			return
+		}
+	}
+	dir, name, err := utils.SplitObjectKey(key, "")
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_key",
+			Message: "Invalid key: " + err.Error(),
+		})
+		return
+	}
+	if !h.reprocessAllowed(key) {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Code:    "source_not_owned",
+			Message: "Object " + key + " is outside " + h.cfg.Storage.ReprocessPrefix + " and can't be reprocessed",
+		})
+		return
+	}
+
+	// Without a prefix any object in the bucket could be replaced
+	if (req.Overwrite || req.TargetKey != "") && strings.Trim(h.cfg.Storage.ReprocessPrefix, "/") == "" {
+		c.JSON(http.StatusForbidden, models.ErrorResponse{
+			Code:    "target_not_owned",
+			Message: "overwrite and target_key require REPROCESS_PREFIX to be set",
+		})
+		return
+	}
+
+	// Where the result goes: next to the source by default
+	targetKey := dir + strings.TrimSuffix(name, filepath.Ext(name)) + "_reprocessed.mp4"
+	switch {
+	case req.Overwrite && req.TargetKey != "":
+		c.JSON(http.StatusBadRequest, models.ErrorResponse{
+			Code:    "invalid_request",
+			Message: "target_key and overwrite can't be combined",
+		})
+		return
+	case req.Overwrite:
+		targetKey = key
+	case req.TargetKey != "":
+		if _, _, err := utils.SplitObjectKey(req.TargetKey, ""); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Code:    "invalid_key",
+				Message: "Invalid target_key: " + err.Error(),
+			})
+			return
+		}
+		if !h.reprocessAllowed(req.TargetKey) {
+			c.JSON(http.StatusForbidden, models.ErrorResponse{
+				Code:    "target_not_owned",
+				Message: "target_key " + req.TargetKey + " is outside " + h.cfg.Storage.ReprocessPrefix,
+			})
+			return
+		}
+		if req.TargetKey == key {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Code:    "invalid_request",
+				Message: "target_key is the source key, set overwrite to replace it",
+			})
+			return
+		}
+		targetKey = req.TargetKey
+	}
+
+	processOpts := utils.VideoProcessOptions{
+		Faststart:        h.cfg.Media.Faststart,
+		AudioBitrate:     h.cfg.Media.AudioBitrate,
+		NormalizeStreams: req.NormalizeStreams,
+		VideoCodec:       req.VideoCodec,
+		// Unlike uploads the stored video is kept whole, overwrite would lose the rest
+		FullLength: true,
+	}
+	if req.Faststart != nil {
+		processOpts.Faststart = *req.Faststart
+	}
+	if req.VideoCodec != "" {
+		if status, code, err := h.checkVideoCodec(req.VideoCodec); err != nil {
+			c.JSON(status, models.ErrorResponse{Code: code, Message: err.Error()})
+			return
+		}
+	}
+	var keyframes utils.KeyframeInterval
+	if req.KeyframeInterval != "" {
+		if keyframes, err = utils.ParseKeyframeInterval(req.KeyframeInterval); err != nil {
+			c.JSON(http.StatusBadRequest, models.ErrorResponse{
+				Code:    "invalid_request",
+				Message: "Invalid keyframe_interval: " + err.Error(),
+			})
+			return
+		}
+	}
+
+	// ffmpeg picks the demuxer by the extension
+	tempFile, err := utils.CreateTemp("reprocess-*" + filepath.Ext(name))
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "internal_error",
+			Message: "Failed to create temporary file: " + err.Error(),
+		})
+		return
+	}
+	sourcePath := tempFile.Name()
+	defer os.Remove(sourcePath)
+	_, err = h.storage.Download(key, tempFile)
+	tempFile.Close()
+	if errors.Is(err, storage.ErrNotFound) {
+		c.JSON(http.StatusNotFound, models.ErrorResponse{
+			Code:    "not_found",
+			Message: "Object not found: " + key,
+		})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to download %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "download_failed",
+			Message: "Failed to download object: " + err.Error(),
+		})
+		return
+	}
+
+	probe, err := utils.ProbeMedia(sourcePath)
+	if err != nil || !probe.HasVideoStream() {
+		c.JSON(http.StatusUnprocessableEntity, models.ErrorResponse{
+			Code:    "no_video_stream",
+			Message: "Object " + key + " is not a video",
+		})
+		return
+	}
+	processOpts.TranscodeAudio = !utils.MP4AudioCompatible(probe)
+	if req.DownmixStereo {
+		for _, audio := range probe.StreamsOfType("audio") {
+			if audio.Channels > 2 {
+				processOpts.DownmixStereo = true
+			}
+		}
+	}
+	if keyframes != (utils.KeyframeInterval{}) {
+		processOpts.Keyframes = keyframes.Resolve(probe.VideoStream().FrameRate())
+	}
+
+	logrus.Infof("Reprocessing %s into %s", key, targetKey)
+	processedPath, processed, audioSettings, err := utils.ProcessVideoWithBitrateReduction(sourcePath, processOpts)
+	if processedPath != "" && processedPath != sourcePath {
+		defer os.Remove(processedPath)
+	}
+	if err == nil && !processed {
+		err = errors.New("not a video")
+	}
+	if errors.Is(err, mediaexec.ErrTimeout) {
+		logrus.Errorf("Reprocessing %s timed out: %v", key, err)
+		c.JSON(http.StatusUnprocessableEntity, models.ErrorResponse{
+			Code:    "processing_timeout",
+			Message: "Processing took too long and was aborted: " + err.Error(),
+		})
+		return
+	}
+	if err != nil {
+		logrus.Errorf("Failed to reprocess %s: %v", key, err)
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "processing_failed",
+			Message: "Failed to process video: " + err.Error(),
+		})
+		return
+	}
+
+	output, err := os.Open(processedPath)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "internal_error",
+			Message: "Failed to read processed video: " + err.Error(),
+		})
+		return
+	}
+	defer output.Close()
+	// The source key may have another extension when it is overwritten
+	result, err := h.storage.Upload(output, targetKey, storage.UploadOptions{ContentType: "video/mp4"})
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, models.ErrorResponse{
+			Code:    "upload_failed",
+			Message: "Failed to store processed video: " + err.Error(),
+		})
+		return
+	}
+
+	artifact := models.Artifact{
+		Type:   artifactProcessed,
+		URL:    result.URL,
+		Key:    targetKey,
+		Format: "mp4",
+	}
+	if info, err := output.Stat(); err == nil {
+		artifact.Size = info.Size()
+	}
+	var duration float64
+	if dimensions, err := utils.GetVideoMetadata(processedPath); err == nil {
+		artifact.Width = dimensions.Width
+		artifact.Height = dimensions.Height
+		duration = dimensions.Duration
+	}
+
+	message := "Video was reprocessed and stored under " + targetKey
+	if req.Overwrite {
+		message = "Video was reprocessed and replaced"
+	}
+	c.JSON(http.StatusOK, models.ReprocessResponse{
+		SourceKey:     key,
+		Artifact:      artifact,
+		Overwritten:   req.Overwrite,
+		Duration:      duration,
+		VideoCodec:    cmp.Or(processOpts.VideoCodec, utils.DefaultVideoCodec),
+		Faststart:     processOpts.Faststart,
+		AudioSettings: audioSettings,
+		Message:       message,
+	})
+}
+
+// reprocessAllowed reports whether key lies under REPROCESS_PREFIX. Without one any
+// key is allowed as a source, HandleReprocess refuses to write anywhere but next to it.
+func (h *UploadHandler) reprocessAllowed(key string) bool {
+	prefix := strings.Trim(h.cfg.Storage.ReprocessPrefix, "/")
+	return prefix == "" || strings.HasPrefix(key, prefix+"/")
+}
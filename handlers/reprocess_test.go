@@ -0,0 +1,35 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestReprocessWritesNeedPrefix(t *testing.T) {
+	tests := []struct {
+		name   string
+		prefix string
+		body   string
+		status int
+		code   string
+	}{
+		{"overwrite without prefix", "", `{"key":"videos/clip.mp4","overwrite":true}`, http.StatusForbidden, "target_not_owned"},
+		{"target_key without prefix", "", `{"key":"videos/clip.mp4","target_key":"site/index.html"}`, http.StatusForbidden, "target_not_owned"},
+		{"next to the source without prefix", "", `{"key":"videos/clip.mp4"}`, http.StatusNotFound, "not_found"},
+		{"overwrite under prefix", "videos", `{"key":"videos/clip.mp4","overwrite":true}`, http.StatusNotFound, "not_found"},
+		{"source outside prefix", "videos", `{"key":"site/clip.mp4","overwrite":true}`, http.StatusForbidden, "source_not_owned"},
+	}
+	for _, tt := range tests {
+		h, _ := newTestHandler(t)
+		h.cfg.Storage.ReprocessPrefix = tt.prefix
+		req, _ := http.NewRequest(http.MethodPost, "/reprocess", strings.NewReader(tt.body))
+		req.Header.Set("Content-Type", "application/json")
+
+		// The source doesn't exist, so allowed requests end at the download
+		status, response := serve(t, h.HandleReprocess, req)
+		if status != tt.status || response.Code != tt.code {
+			t.Errorf("%s: got %d %q (%s), want %d %s", tt.name, status, response.Code, response.Message, tt.status, tt.code)
+		}
+	}
+}
@@ -1,372 +1,1899 @@
 package handlers
 
 import (
-	"crypto/tls"
-	"crypto/x509"
+	"bytes"
+	"cmp"
+	"encoding/hex"
+	"errors"
 	"fmt"
+	"image"
+	"image/color"
 	"io"
+	"maps"
+	"mime"
+	"mime/multipart"
 	"net/http"
+	"net/url"
 	"os"
 	"path/filepath"
+	"slices"
+	"strconv"
 	"strings"
 	"time"
 
+	"github.com/asset_upload_service/config"
+	"github.com/asset_upload_service/jobs"
+	"github.com/asset_upload_service/keys"
+	"github.com/asset_upload_service/mediaexec"
 	"github.com/asset_upload_service/models"
+	"github.com/asset_upload_service/moderation"
 	"github.com/asset_upload_service/services"
-	"github.com/aws/aws-sdk-go/aws"
-	"github.com/aws/aws-sdk-go/aws/credentials"
-	"github.com/aws/aws-sdk-go/aws/session"
-	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/asset_upload_service/storage"
+	"github.com/disintegration/imaging"
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
 
 	"github.com/asset_upload_service/utils"
 )
 
-type UploadHandler struct{}
+type UploadHandler struct {
+	cfg       *config.Config
+	storage   storage.ObjectStorage
+	jobs      *jobs.Store
+	pool      *jobs.Pool
+	moderator moderation.Moderator
+	keys      keys.KeyGenerator
+}
+
+func NewUploadHandler(cfg *config.Config, store storage.ObjectStorage, jobStore *jobs.Store, pool *jobs.Pool, moderator moderation.Moderator, keyGen keys.KeyGenerator) *UploadHandler {
+	return &UploadHandler{cfg: cfg, storage: store, jobs: jobStore, pool: pool, moderator: moderator, keys: keyGen}
+}
+
+// generateKey names an upload with the configured key strategy. Directories the
+// strategy adds become part of the prefix, so derived files (previews, posters) are
+// stored next to the file. contentHash is the SHA-256 of data, when known.
+func (h *UploadHandler) generateKey(keyPrefix, fileName string, data, contentHash []byte, keyCase string) (string, string, error) {
+	key := h.keys.GenerateKey(keys.UploadMeta{FileName: fileName, Data: data, Time: time.Now(), SHA256: contentHash})
+	dir, name, err := utils.SplitObjectKey(utils.ApplyKeyCase(key, keyCase), "")
+	if err != nil {
+		return "", "", err
+	}
+	return keyPrefix + dir, name, nil
+}
+
+// keyPrefix builds the object key prefix from the key_prefix, key_case and
+// partition_scheme options, falling back to the configured partition scheme
+func (h *UploadHandler) keyPrefix(c *gin.Context) (string, error) {
+	keyCase, err := h.keyCase(c.Request.FormValue("key_case"))
+	if err != nil {
+		return "", err
+	}
+	return h.buildKeyPrefix(utils.ApplyKeyCase(c.Request.FormValue("key_prefix"), keyCase), c.Request.FormValue("partition_scheme"))
+}
+
+// keyCase validates a key_case option, falling back to the configured mode when it
+// is empty
+func (h *UploadHandler) keyCase(mode string) (string, error) {
+	if mode == "" {
+		return h.cfg.Storage.KeyCase, nil
+	}
+	if !utils.ValidKeyCase(mode) {
+		return "", fmt.Errorf("unsupported key_case: %s (expected preserve, lower or upper)", mode)
+	}
+	return mode, nil
+}
+
+// buildKeyPrefix combines an explicit prefix with the date partition of scheme, or of
+// the configured scheme when it is empty
+func (h *UploadHandler) buildKeyPrefix(prefix, scheme string) (string, error) {
+	if scheme == "" {
+		scheme = h.cfg.Storage.PartitionScheme
+	}
+	if !utils.ValidPartitionScheme(scheme) {
+		return "", fmt.Errorf("unsupported partition_scheme: %s (expected none, ymd or hive)", scheme)
+	}
+	return utils.BuildKeyPrefix(prefix, scheme, time.Now())
+}
+
+// parseMultipartForm parses the upload form. Parts up to MULTIPART_MEMORY_MB are kept
+// in memory, larger ones spill to temp files; bodies over MAX_UPLOAD_MB are cut off.
+func (h *UploadHandler) parseMultipartForm(c *gin.Context) error {
+	if h.cfg.MaxUploadMB > 0 {
+		c.Request.Body = http.MaxBytesReader(c.Writer, c.Request.Body, int64(h.cfg.MaxUploadMB)<<20)
+	}
+	return c.Request.ParseMultipartForm(int64(h.cfg.MultipartMemoryMB) << 20)
+}
+
+// formParseError returns the response for a form that couldn't be parsed
+func formParseError(err error) (int, models.UploadResponse) {
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		return http.StatusRequestEntityTooLarge, models.UploadResponse{
+			Code:    "file_too_large",
+			Message: fmt.Sprintf("Upload exceeds the maximum size of %d MB", tooLarge.Limit>>20),
+		}
+	}
+	// The body ended before the closing boundary, e.g. the client disconnected
+	if errors.Is(err, io.ErrUnexpectedEOF) {
+		return http.StatusBadRequest, models.UploadResponse{
+			Code:    "truncated_upload",
+			Message: "Upload is incomplete: " + err.Error(),
+		}
+	}
+	// Usually the body doesn't use the boundary the Content-Type declares
+	return http.StatusBadRequest, models.UploadResponse{
+		Code:    "malformed_multipart",
+		Message: "Failed to parse multipart form: " + err.Error(),
+	}
+}
+
+// contentTypeError returns the response for uploads whose Content-Type isn't
+// multipart/form-data with a valid boundary; ok is true when it is
+func contentTypeError(contentType string) (status int, response models.UploadResponse, ok bool) {
+	if contentType == "" {
+		return http.StatusBadRequest, models.UploadResponse{
+			Code:    "malformed_multipart",
+			Message: "Missing Content-Type header, uploads must be sent as multipart/form-data with the file in the file field",
+		}, false
+	}
+	mediaType, params, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return http.StatusBadRequest, models.UploadResponse{
+			Code:    "malformed_multipart",
+			Message: "Invalid Content-Type header: " + err.Error(),
+		}, false
+	}
+	if mediaType != "multipart/form-data" {
+		return http.StatusUnsupportedMediaType, models.UploadResponse{
+			Code:    "unsupported_media_type",
+			Message: fmt.Sprintf("Uploads must be sent as multipart/form-data with the file in the file field, got %s", mediaType),
+		}, false
+	}
+	if err := validBoundary(params["boundary"]); err != nil {
+		return http.StatusBadRequest, models.UploadResponse{
+			Code:    "malformed_multipart",
+			Message: "Invalid multipart/form-data Content-Type: " + err.Error(),
+		}, false
+	}
+	return 0, models.UploadResponse{}, true
+}
+
+// validBoundary checks a multipart boundary against RFC 2046: 1 to 70 characters out
+// of a restricted set, not ending with a space
+func validBoundary(boundary string) error {
+	if boundary == "" {
+		return errors.New("boundary parameter is missing")
+	}
+	if len(boundary) > 70 {
+		return errors.New("boundary is longer than 70 characters")
+	}
+	for _, r := range boundary {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || strings.ContainsRune("'()+_,-./:=? ", r)) {
+			return fmt.Errorf("boundary contains invalid character %q", r)
+		}
+	}
+	if strings.HasSuffix(boundary, " ") {
+		return errors.New("boundary must not end with a space")
+	}
+	return nil
+}
+
+// removeSpillFiles deletes the temp files parseMultipartForm spilled large parts to,
+// right when the handler is done instead of whenever the server gets to it
+func removeSpillFiles(r *http.Request) {
+	if r.MultipartForm == nil {
+		return
+	}
+	if err := r.MultipartForm.RemoveAll(); err != nil {
+		logrus.Warnf("Failed to remove multipart temp files: %v", err)
+	}
+}
+
+// checkComplete returns an error when the n bytes read from a file part differ from
+// what it declared: its own Content-Length header, when the client sent one, or the
+// size the form parser saw
+func checkComplete(header *multipart.FileHeader, n int) error {
+	if v := header.Header.Get("Content-Length"); v != "" {
+		if declared, err := strconv.ParseInt(v, 10, 64); err == nil && declared != int64(n) {
+			return fmt.Errorf("received %d of %d bytes", n, declared)
+		}
+	}
+	if header.Size != int64(n) {
+		return fmt.Errorf("received %d of %d bytes", n, header.Size)
+	}
+	return nil
+}
 
-func NewUploadHandler() *UploadHandler {
-	return &UploadHandler{}
+// errExtraParts is returned by formFile for strict forms with parts it would ignore
+var errExtraParts = errors.New("unexpected multipart parts")
+
+// formFile opens the upload from the multipart field named by the field query
+// parameter, "file" by default. When the field holds no file the error lists the
+// fields that do. Leniently the first file part of the field is used and other parts
+// are ignored; strict forms must hold no other file parts and no repeated fields.
+func formFile(c *gin.Context, strict bool) (multipart.File, *multipart.FileHeader, error) {
+	field := c.DefaultQuery("field", "file")
+	form := c.Request.MultipartForm
+	if form == nil || len(form.File[field]) == 0 {
+		if form == nil || len(form.File) == 0 {
+			return nil, nil, fmt.Errorf("no file in form field %q, the request contains no files", field)
+		}
+		available := slices.Sorted(maps.Keys(form.File))
+		return nil, nil, fmt.Errorf("no file in form field %q, files were sent in: %s", field, strings.Join(available, ", "))
+	}
+	if strict {
+		if err := checkExtraParts(form, field); err != nil {
+			return nil, nil, err
+		}
+	}
+	return c.Request.FormFile(field)
 }
 
-func (h *UploadHandler) HandleUpload(c *gin.Context) { // Parse form data (10MB max)
+// checkExtraParts returns errExtraParts describing the parts of form besides one file
+// in field and one value per text field
+func checkExtraParts(form *multipart.Form, field string) error {
+	var extra []string
+	for _, name := range slices.Sorted(maps.Keys(form.File)) {
+		switch n := len(form.File[name]); {
+		case name == field && n > 1:
+			extra = append(extra, fmt.Sprintf("%d files in %q", n, name))
+		case name != field && n == 1:
+			extra = append(extra, fmt.Sprintf("a file in %q", name))
+		case name != field:
+			extra = append(extra, fmt.Sprintf("%d files in %q", n, name))
+		}
+	}
+	for _, name := range slices.Sorted(maps.Keys(form.Value)) {
+		if n := len(form.Value[name]); n > 1 {
+			extra = append(extra, fmt.Sprintf("%d values of %q", n, name))
+		}
+	}
+	if len(extra) > 0 {
+		return fmt.Errorf("%w: %s", errExtraParts, strings.Join(extra, ", "))
+	}
+	return nil
+}
+
+// strictMultipart reports whether the upload form is checked for extra parts, per
+// the strict_multipart option or STRICT_MULTIPART
+func (h *UploadHandler) strictMultipart(c *gin.Context) bool {
+	if v := c.Request.FormValue("strict_multipart"); v != "" {
+		return v == "true"
+	}
+	return h.cfg.StrictMultipart
+}
+
+func (h *UploadHandler) HandleUpload(c *gin.Context) {
+	start := time.Now()
+
 	// Log Content-Type header to debug issues with multipart form parsing
 	contentType := c.GetHeader("Content-Type")
 	logrus.Infof("Received request with Content-Type: %s", contentType)
 
-	// Handle special case when content type might have issues with boundary
-	if !strings.Contains(contentType, "boundary=") {
-		logrus.Warnf("Content-Type doesn't contain boundary parameter, trying to handle anyway")
+	// Reject bodies that can't be multipart forms before reading them
+	if status, response, ok := contentTypeError(contentType); !ok {
+		logrus.Warnf("Rejecting upload with Content-Type %q: %s", contentType, response.Message)
+		c.JSON(status, response)
+		return
 	}
 
 	// Try to parse the multipart form
-	if err := c.Request.ParseMultipartForm(10 << 20); err != nil {
+	defer removeSpillFiles(c.Request)
+	if err := h.parseMultipartForm(c); err != nil {
 		logrus.Errorf("Failed to parse multipart form: %v", err)
-		c.JSON(http.StatusBadRequest, models.UploadResponse{
-			Message: "Failed to parse multipart form: " + err.Error(),
-		})
+		c.JSON(formParseError(err))
 		return
 	}
 
-	resizer := services.NewResizer(90)
-	awsConfig := models.UploadRequest{
-		AWSAccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
-		AWSSecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
-		AWSRegion:          os.Getenv("AWS_REGION"),
-		S3BucketName:       os.Getenv("AWS_S3_BUCKET"),
+	bounds, err := parseDimensionBounds(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Message: "Invalid dimension bounds: " + err.Error(),
+		})
+		return
 	}
 
-	// Validate AWS credentials
-	if awsConfig.AWSAccessKeyID == "" || awsConfig.AWSSecretAccessKey == "" ||
-		awsConfig.AWSRegion == "" || awsConfig.S3BucketName == "" {
+	keyPrefix, err := h.keyPrefix(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.UploadResponse{
-			Message: "AWS credentials and configuration are required",
+			Message: "Invalid object key options: " + err.Error(),
 		})
 		return
 	}
 
+	meta, err := h.parseMeta(c.Request.Form)
+	if err != nil {
+		c.JSON(invalidMeta(err))
+		return
+	}
+
 	// Get the file from form data
-	file, header, err := c.Request.FormFile("file")
+	file, header, err := formFile(c, h.strictMultipart(c))
+	if errors.Is(err, errExtraParts) {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:    "unexpected_parts",
+			Message: "Rejected strict multipart form: " + err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:    "missing_file",
 			Message: "Failed to get file from form data: " + err.Error(),
 		})
 		return
 	}
 	defer file.Close()
 
-	// Read file into memory
-	fileBytes, err := io.ReadAll(file)
+	// Normalize the name used for the object key, keeping the original for the response
+	originalFileName := header.Filename
+	keyCase, _ := h.keyCase(c.Request.FormValue("key_case")) // validated with the key prefix
+	header.Filename = utils.ApplyKeyCase(utils.NormalizeFilename(header.Filename, h.cfg.Storage.FilenameStrategy), keyCase)
+
+	// Read file into memory, hashing it on the way for the key and checksum
+	fileBytes, contentHash, err := utils.ReadAllSHA256(file)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.UploadResponse{
 			Message: "Failed to read file: " + err.Error(),
 		})
 		return
 	}
-	// Get file type without processing
+
+	// A client that disconnected mid-upload leaves a partial file ffprobe may misread
+	if err := checkComplete(header, len(fileBytes)); err != nil {
+		logrus.Warnf("Rejecting truncated upload of %s: %v", header.Filename, err)
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:     "truncated_upload",
+			Message:  "Upload is incomplete: " + err.Error(),
+			FileName: header.Filename,
+		})
+		return
+	}
+
+	// Empty files would be stored as junk 0-byte objects
+	if len(fileBytes) == 0 {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:     "empty_file",
+			Message:  "File is empty",
+			FileName: header.Filename,
+		})
+		return
+	}
+
+	if !h.debugAllowed(c) {
+		return
+	}
+	// Time the phases of debug=true uploads, reading includes receiving the body
+	timer := newPhaseTimer(start, c.Request.FormValue("debug") == "true")
+	timer.lap(phaseRead)
+	if !h.moderate(c, fileBytes, header.Filename) {
+		return
+	}
+
+	// Name the object with the configured key strategy
+	if keyPrefix, header.Filename, err = h.generateKey(keyPrefix, header.Filename, fileBytes, contentHash, keyCase); err != nil {
+		c.JSON(http.StatusInternalServerError, models.UploadResponse{
+			Message: "Failed to generate object key: " + err.Error(),
+		})
+		return
+	}
+	timer.skip()
+
+	// Large videos can take minutes to transcode, async uploads return a job right away
+	if c.Request.FormValue("async") == "true" {
+		h.startAsyncUpload(c, fileBytes, contentHash, header.Filename, originalFileName, keyPrefix, bounds, meta, timer)
+		return
+	}
+	status, response := h.processUpload(c.Request.Form, fileBytes, contentHash, header.Filename, originalFileName, keyPrefix, bounds, "", timer)
+	response.Meta = meta
+	c.JSON(status, response)
+}
+
+// processUpload analyzes and optionally processes a file according to the upload
+// options in form, stores the result under keyPrefix and returns the response status
+// and body. It doesn't touch the request, so it can also run in the background.
+// storedURL is set when the file is already stored under keyPrefix+fileName; it is
+// then only uploaded again when processing changed it. contentHash is the SHA-256 of
+// fileBytes. timer, nil unless debug=true, gets the time of each phase.
+func (h *UploadHandler) processUpload(form url.Values, fileBytes, contentHash []byte, fileName, originalFileName, keyPrefix string, bounds dimensionBounds, storedURL string, timer *phaseTimer) (int, models.UploadResponse) {
+	var err error
+	var modified bool
+
+	// Encoders use the configured quality of their format unless the upload sets one
+	resizer := services.DefaultResizer()
+	if v := form.Get("quality"); v != "" {
+		quality, err := strconv.Atoi(v)
+		if err != nil || quality < 1 || quality > 100 {
+			return http.StatusBadRequest, models.UploadResponse{
+				Message: "Invalid quality: " + v + " (expected 1 to 100)",
+			}
+		}
+		resizer.Quality, resizer.WebPQuality = quality, quality
+	}
+
+	disposition := form.Get("content_disposition")
+	if disposition != "" && !utils.ValidDisposition(disposition) {
+		return http.StatusBadRequest, models.UploadResponse{
+			Message: "Unsupported content_disposition: " + disposition + " (expected inline or attachment)",
+		}
+	}
+
+	// Optionally tag the file and its derived files for a lifecycle rule to delete
+	var expiresInDays int
+	if v := form.Get("expires_in"); v != "" {
+		if expiresInDays, err = utils.ParseExpiresIn(v); err != nil {
+			return http.StatusBadRequest, models.UploadResponse{
+				Message: err.Error(),
+			}
+		}
+	}
+
+	// Get file type without processing. A content type the client declared takes
+	// precedence when the file's magic bytes agree with it, otherwise the extension
+	// hint picks the pipeline for files sniffing can't identify.
 	fileType := http.DetectContentType(fileBytes)
+	declaredType := form.Get("content_type")
+	var hinted bool
+	if declaredType != "" {
+		if fileType, err = utils.CheckContentType(declaredType, fileBytes); err != nil {
+			return http.StatusBadRequest, models.UploadResponse{
+				Code:     "content_type_mismatch",
+				Message:  err.Error(),
+				FileName: fileName,
+			}
+		}
+	} else if ext := form.Get("original_extension"); ext != "" {
+		if fileType, hinted, err = utils.HintedContentType(fileType, fileBytes, ext); err != nil {
+			return http.StatusBadRequest, models.UploadResponse{
+				Message: err.Error(),
+			}
+		}
+	}
 	var fileInfo *models.FileInfo
 	var message string
-
-	if strings.HasPrefix(fileType, "image/") { // Just get image dimensions without processing
-		dimensions, err := utils.GetImageDimensions(fileBytes)
+	var extras []extraUpload
+	// outputType is the content type of processed output stored under a name that may
+	// not match it
+	var outputType string
+	// warnings lists what couldn't be done without failing the upload, e.g. a derived
+	// file that couldn't be generated
+	var warnings []string
+
+	// Files are stored as they are without probing when asked to, unless an option
+	// needs the file analyzed or processed anyway
+	skipMetadata := form.Get("skip_metadata") == "true" && !requestsProcessing(form) && bounds == (dimensionBounds{})
+
+	// Videos are written to disk so ffprobe/ffmpeg can work on them
+	isVideo := !skipMetadata && !strings.HasPrefix(fileType, "image/") &&
+		(strings.HasPrefix(fileType, "video/") || utils.IsVideoFile(fileName))
+	var tempPath string
+	var probe *utils.ProbeResult
+	if isVideo {
+		// A unique name keeps concurrent uploads of the same file name, and the
+		// _processed files derived from it, apart
+		videoFile, err := utils.CreateTemp("video-*" + filepath.Ext(fileName))
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.UploadResponse{
-				Message: "Failed to get image dimensions: " + err.Error(),
-			})
-			return
+			return http.StatusInternalServerError, models.UploadResponse{
+				Message: "Failed to create temp video file: " + err.Error(),
+			}
+		}
+		tempPath = videoFile.Name()
+		defer os.Remove(tempPath)
+		_, err = videoFile.Write(fileBytes)
+		if closeErr := videoFile.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return http.StatusInternalServerError, models.UploadResponse{
+				Message: "Failed to create temp video file: " + err.Error(),
+			}
+		}
+
+		if probe, err = utils.ProbeMedia(tempPath); err != nil {
+			logrus.Warnf("Failed to probe video streams: %v", err)
+			warnings = append(warnings, "Video streams could not be probed, options depending on them were skipped")
+		}
+
+		// Reject out of range videos before any transcoding
+		if probe != nil {
+			if stream := probe.VideoStream(); stream != nil {
+				if err := bounds.check(stream.Width, stream.Height); err != nil {
+					return rejectDimensions(fileName, stream.Width, stream.Height, err)
+				}
+			}
+		}
+	}
+
+	// Dimensions that couldn't be extracted are reported as unavailable
+	var metadataErr error
+
+	// Optionally render the first page of PDFs and multi-page TIFFs as a preview, from
+	// the upload as processing may convert TIFFs
+	var documentPage *utils.DocumentPage
+	var documentPreviewPath string
+	if documentType := utils.DocumentType(fileBytes); documentType != "" && form.Get("render_preview") == "true" {
+		if previewFile, err := utils.CreateTemp("page-*.jpg"); err != nil {
+			logrus.Warnf("Failed to create temporary file for the preview of %s: %v", fileName, err)
+			warnings = append(warnings, "First page preview could not be rendered")
+		} else {
+			previewFile.Close()
+			documentPreviewPath = previewFile.Name()
+			defer os.Remove(documentPreviewPath)
+			if page, err := utils.RenderFirstPage(fileBytes, documentType, documentPreviewPath, resizer.Quality); err != nil {
+				logrus.Warnf("Failed to render the first page of %s: %v", fileName, err)
+				warnings = append(warnings, "First page preview could not be rendered")
+			} else {
+				documentPage = &page
+			}
+		}
+	}
+
+	// SVGs can't be decoded, they are stored like other files
+	isImage := !skipMetadata && strings.HasPrefix(fileType, "image/") && fileType != "image/svg+xml"
+	var imageDimensions struct{ Width, Height int }
+	if isImage {
+		if imageDimensions, metadataErr = utils.GetImageDimensions(fileBytes); metadataErr != nil {
+			// Images that are only stored don't need their dimensions
+			if requestsProcessing(form) || bounds != (dimensionBounds{}) {
+				return http.StatusInternalServerError, models.UploadResponse{
+					Message: "Failed to get image dimensions: " + metadataErr.Error(),
+				}
+			}
+			logrus.Warnf("Failed to get dimensions of image %s, storing it without: %v", fileName, metadataErr)
+			warnings = append(warnings, "Image dimensions could not be read")
+		}
+	}
+
+	if isImage && metadataErr == nil { // Just get image dimensions without processing
+		dimensions := imageDimensions
+		if err := bounds.check(dimensions.Width, dimensions.Height); err != nil {
+			return rejectDimensions(fileName, dimensions.Width, dimensions.Height, err)
 		}
 
 		// Calculate original aspect ratio
 		ratio := float64(dimensions.Width) / float64(dimensions.Height)
 
 		// Get the closest standard aspect ratio without resizing
-		standardFormat := resizer.DetectFormat(dimensions.Width, dimensions.Height)
+		standardFormat := resizer.MatchFormat(dimensions.Width, dimensions.Height)
+
+		ratioStr := utils.FormatRatio(ratio)
+
+		fileInfo = &models.FileInfo{
+			FileType:            "image",
+			Width:               dimensions.Width,
+			Height:              dimensions.Height,
+			OriginalRatio:       ratioStr, // Use the float64 ratio value here
+			MatchedFormat:       standardFormat.FormattedRatio,
+			MatchedFormatName:   standardFormat.Name,
+			MatchedFormatWidth:  standardFormat.Width,
+			MatchedFormatHeight: standardFormat.Height,
+			Orientation:         utils.ImageOrientation(fileBytes, dimensions.Width, dimensions.Height),
+		}
+		if form.Get("rank_formats") == "true" {
+			fileInfo.RankedFormats = rankFormats(resizer, dimensions.Width, dimensions.Height)
+		}
+
+		// CMYK JPEGs from print tools come out with wrong colors or not at all, so
+		// everything after this works on an RGB copy
+		uploaded := fileBytes
+		if utils.IsCMYKJPEG(fileBytes) {
+			converted, err := utils.ConvertCMYKToRGB(fileBytes, resizer.Quality)
+			if err != nil {
+				return http.StatusUnprocessableEntity, models.UploadResponse{
+					Code:    "unsupported_color_space",
+					Message: "Failed to convert CMYK image to RGB: " + err.Error(),
+				}
+			}
+			modified = true
+			fileBytes = converted
+			fileInfo.ConvertedFromCMYK = true
+			message = "CMYK image converted to RGB and uploaded successfully"
+		}
+
+		// Validate the optional output density before doing any work
+		dpi := 0
+		if v := form.Get("dpi"); v != "" {
+			if dpi, err = strconv.Atoi(v); err != nil || dpi < 1 || dpi > utils.MaxDPI {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: fmt.Sprintf("Invalid dpi: %s (expected 1 to %d)", v, utils.MaxDPI),
+				}
+			}
+		}
+
+		// Animated GIFs are resized frame by frame, single frame GIFs like other images
+		var frames int
+		if fileType == "image/gif" {
+			frames = services.GIFFrameCount(fileBytes)
+		}
+		if frames > 1 {
+			fileInfo.Frames = frames
+		}
+		outputFormat := form.Get("output_format")
+		if outputFormat != "" {
+			if !services.ValidAnimatedOutputFormat(outputFormat) {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Unsupported output_format: " + outputFormat + " (expected gif or webp)",
+				}
+			}
+			if allowed := h.cfg.Media.AllowedOutputFormats; !slices.Contains(allowed, outputFormat) {
+				return http.StatusBadRequest, models.UploadResponse{
+					Code:    "output_format_not_allowed",
+					Message: fmt.Sprintf("output_format %s is disabled on this server, allowed: %s", outputFormat, strings.Join(allowed, ", ")),
+				}
+			}
+			if frames < 2 || form.Get("format") == "" {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "output_format is only supported when resizing animated GIFs with format",
+				}
+			}
+		}
 
-		num, den := utils.FloatToRatio(ratio, 100)
+		// Optional analyses share a single decode of the image
+		wantQualityScore := form.Get("quality_score") == "true"
+		wantHistogram := form.Get("histogram") == "true"
+		wantLQIP := form.Get("lqip") == "true"
+		histogramBins := utils.MaxHistogramBins
+		if v := form.Get("histogram_bins"); v != "" {
+			if histogramBins, err = strconv.Atoi(v); err != nil || histogramBins < 2 || histogramBins > utils.MaxHistogramBins {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: fmt.Sprintf("Invalid histogram_bins: %s (expected 2 to %d)", v, utils.MaxHistogramBins),
+				}
+			}
+		}
+		if wantQualityScore || wantHistogram || wantLQIP {
+			img, err := imaging.Decode(bytes.NewReader(fileBytes))
+			if err != nil {
+				return http.StatusInternalServerError, models.UploadResponse{
+					Message: "Failed to decode image: " + err.Error(),
+				}
+			}
 
-		ratioStr := fmt.Sprintf("%d:%d", num, den)
+			// Score sharpness so blurry images can be rejected downstream
+			if wantQualityScore {
+				score := utils.SharpnessScore(img)
+				isBlurry := score < h.cfg.Media.BlurThreshold
+				fileInfo.QualityScore = &score
+				fileInfo.IsBlurry = &isBlurry
+			}
+			if wantHistogram {
+				fileInfo.Histogram = utils.Histogram(img, histogramBins)
+			}
+			if wantLQIP {
+				if fileInfo.LQIP, err = utils.LQIP(img); err != nil {
+					logrus.Warnf("Failed to generate LQIP: %v", err)
+					warnings = append(warnings, "LQIP could not be generated")
+				}
+			}
+		}
+
+		// Optionally report the embedded color profile and normalize wide gamut images to sRGB
+		if form.Get("color_info") == "true" || form.Get("convert_srgb") == "true" {
+			colorInfo := utils.GetColorInfo(fileBytes)
+			if form.Get("convert_srgb") == "true" && utils.CanConvertToSRGB(colorInfo.ColorSpace) {
+				img, err := imaging.Decode(bytes.NewReader(fileBytes))
+				if err != nil {
+					return http.StatusInternalServerError, models.UploadResponse{
+						Message: "Failed to decode image: " + err.Error(),
+					}
+				}
+
+				encoding := imaging.JPEG
+				if fileType == "image/png" {
+					encoding = imaging.PNG
+				}
+				var buf bytes.Buffer
+				if err := imaging.Encode(&buf, utils.ConvertToSRGB(img, colorInfo.ColorSpace), encoding, imaging.JPEGQuality(resizer.Quality)); err != nil {
+					return http.StatusInternalServerError, models.UploadResponse{
+						Message: "Failed to convert image to sRGB: " + err.Error(),
+					}
+				}
+				modified = true
+				fileBytes = buf.Bytes()
+				colorInfo.ConvertedToSRGB = true
+				message = "Image converted to sRGB and uploaded successfully"
+			}
+			fileInfo.ColorInfo = colorInfo
+		}
 
+		// Read IPTC/XMP from the upload, re-encoding drops them
+		if form.Get("extract_iptc") == "true" {
+			fileInfo.IPTC = utils.ExtractIPTC(uploaded)
+		}
+		timer.lap(phaseMetadata)
+
+		// Optionally fit the image to one of the standard formats
+		if targetFormat := form.Get("format"); targetFormat != "" {
+			format, ok := services.FindFormat(targetFormat)
+			if !ok {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Unsupported format: " + targetFormat,
+				}
+			}
+
+			fit := form.Get("fit")
+			if fit == "" {
+				fit = services.FitCover
+			}
+			if !services.ValidFitMode(fit) {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Unsupported fit mode: " + fit + " (expected cover or contain)",
+				}
+			}
+
+			opts := services.ResizeOptions{Fit: fit}
+			if bg := form.Get("background"); bg != "" {
+				background, err := services.ParseHexColor(bg)
+				if err != nil {
+					return http.StatusBadRequest, models.UploadResponse{
+						Message: "Invalid background color: " + err.Error(),
+					}
+				}
+				opts.Background = background
+			}
+			if subsampling := form.Get("chroma_subsampling"); subsampling != "" {
+				if !services.ValidChromaSubsampling(subsampling) {
+					return http.StatusBadRequest, models.UploadResponse{
+						Message: "Unsupported chroma_subsampling: " + subsampling + " (expected 444, 422 or 420)",
+					}
+				}
+				opts.ChromaSubsampling = subsampling
+			}
+
+			if opts.Denoise, err = parseFilterStrength(form.Get("denoise"), services.DefaultDenoise, services.MaxDenoise); err != nil {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Invalid denoise: " + err.Error(),
+				}
+			}
+			if opts.Sharpen, err = parseFilterStrength(form.Get("sharpen"), services.DefaultSharpen, services.MaxSharpen); err != nil {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Invalid sharpen: " + err.Error(),
+				}
+			}
+
+			// Small sources are not enlarged unless no_upscale=false
+			opts.NoUpscale = form.Get("no_upscale") != "false"
+
+			var resized []byte
+			ext := ".jpg"
+			if frames > 1 {
+				if frames > h.cfg.Media.MaxGIFFrames {
+					return http.StatusUnprocessableEntity, models.UploadResponse{
+						Code:     "too_many_frames",
+						Message:  fmt.Sprintf("Animated GIF has %d frames, at most %d can be resized", frames, h.cfg.Media.MaxGIFFrames),
+						FileName: fileName,
+					}
+				}
+				if outputFormat == "" {
+					outputFormat = services.AnimatedGIF
+				}
+				resized, err = resizer.ResizeAnimatedGIF(fileBytes, format.FormattedRatio, opts, outputFormat)
+				ext = "." + outputFormat
+			} else {
+				resized, err = resizer.ResizeImage(fileBytes, format.FormattedRatio, opts)
+			}
+			if errors.Is(err, mediaexec.ErrTimeout) {
+				return processingTimeout(fileName, err)
+			}
+			if err != nil {
+				return http.StatusInternalServerError, models.UploadResponse{
+					Message: "Failed to resize image: " + err.Error(),
+				}
+			}
+
+			modified = true
+			fileBytes = resized
+			fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ext
+			fileInfo.OutputFormat = format.FormattedRatio
+			fileInfo.OutputWidth, fileInfo.OutputHeight, fileInfo.UpscaleAvoided = services.OutputSize(format, dimensions.Width, dimensions.Height, fit, opts.NoUpscale)
+			fileInfo.FitMode = fit
+			fileInfo.FiltersApplied = opts.Filters()
+			if fileInfo.UpscaleAvoided {
+				warnings = append(warnings, "Image is smaller than the format and was not upscaled")
+			}
+			message = fmt.Sprintf("Image resized to %s (%dx%d) using %s fit and uploaded successfully", format.FormattedRatio, fileInfo.OutputWidth, fileInfo.OutputHeight, fit)
+		}
+
+		// Optionally crop a square avatar, e.g. for profile pictures
+		if v := form.Get("avatar"); v != "" {
+			size, err := strconv.Atoi(v)
+			if err != nil || size < services.MinAvatarSize || size > services.MaxAvatarSize {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: fmt.Sprintf("Invalid avatar: %s (expected a size of %d to %d)", v, services.MinAvatarSize, services.MaxAvatarSize),
+				}
+			}
+			if form.Get("format") != "" {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "avatar and format can't be combined",
+				}
+			}
+			shape := form.Get("avatar_shape")
+			if shape == "" {
+				shape = services.AvatarSquare
+			}
+			if !services.ValidAvatarShape(shape) {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Unsupported avatar_shape: " + shape + " (expected square or circle)",
+				}
+			}
+			crop := form.Get("avatar_crop")
+			if crop == "" {
+				crop = services.AvatarCropCenter
+			}
+			if !services.ValidAvatarCrop(crop) {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Unsupported avatar_crop: " + crop + " (expected center or smart)",
+				}
+			}
+
+			avatar, ext, err := resizer.Avatar(fileBytes, size, shape, crop)
+			if err != nil {
+				return http.StatusInternalServerError, models.UploadResponse{
+					Message: "Failed to create avatar: " + err.Error(),
+				}
+			}
+
+			modified = true
+			fileBytes = avatar
+			fileName = strings.TrimSuffix(fileName, filepath.Ext(fileName)) + ext
+			fileInfo.OutputFormat = "1:1"
+			fileInfo.OutputWidth, fileInfo.OutputHeight = size, size
+			fileInfo.AvatarShape = shape
+			message = fmt.Sprintf("Image cropped to a %dx%d %s avatar and uploaded successfully", size, size, shape)
+		}
+
+		// Print workflows need the density in the file, patched in without re-encoding
+		if dpi > 0 {
+			withDPI, err := utils.SetDPI(fileBytes, dpi)
+			if errors.Is(err, utils.ErrDPIUnsupported) {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: err.Error(),
+				}
+			} else if err != nil {
+				return http.StatusInternalServerError, models.UploadResponse{
+					Message: "Failed to set dpi: " + err.Error(),
+				}
+			}
+			modified = true
+			fileBytes = withDPI
+			// Report what readers will see in the stored file
+			if stored, ok := utils.ReadDPI(fileBytes); ok {
+				fileInfo.DPI = stored
+			}
+		}
+	} else if isVideo && probe != nil && !probe.HasVideoStream() {
+		// The container only holds audio, so there is nothing to transcode as video
+		if h.cfg.Media.NoVideoStreamPolicy == "reject" {
+			return http.StatusUnprocessableEntity, models.UploadResponse{
+				Code:     "no_video_stream",
+				Message:  "File does not contain a video stream",
+				FileName: fileName,
+			}
+		}
 		fileInfo = &models.FileInfo{
-			FileType:      "image",
-			Width:         dimensions.Width,
-			Height:        dimensions.Height,
-			OriginalRatio: ratioStr, // Use the float64 ratio value here
-			MatchedFormat: standardFormat,
+			FileType: "audio",
+			Duration: probe.Duration(),
 		}
-	} else if strings.HasPrefix(fileType, "video/") || utils.IsVideoFile(header.Filename) {
-		// Save temp file for video metadata extraction and potential conversion
-		tempPath := filepath.Join(os.TempDir(), header.Filename)
-		if err := os.WriteFile(tempPath, fileBytes, 0644); err != nil {
-			c.JSON(http.StatusInternalServerError, models.UploadResponse{
-				Message: "Failed to create temp video file: " + err.Error(),
-			})
-			return
+		message = "File has no video stream and was stored as audio without processing"
+		timer.lap(phaseMetadata)
+	} else if isVideo {
+		// Validate the optional animated preview settings before doing any work
+		var previewOpts *utils.PreviewOptions
+		if previewFormat := form.Get("preview"); previewFormat != "" {
+			if !utils.ValidPreviewFormat(previewFormat) {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Unsupported preview format: " + previewFormat + " (expected webp or gif)",
+				}
+			}
+			previewOpts = &utils.PreviewOptions{Format: previewFormat, Length: 3}
+			if v := form.Get("preview_start"); v != "" {
+				if previewOpts.Start, err = strconv.ParseFloat(v, 64); err != nil {
+					return http.StatusBadRequest, models.UploadResponse{
+						Message: "Invalid preview_start: " + v,
+					}
+				}
+			}
+			if v := form.Get("preview_length"); v != "" {
+				if previewOpts.Length, err = strconv.ParseFloat(v, 64); err != nil {
+					return http.StatusBadRequest, models.UploadResponse{
+						Message: "Invalid preview_length: " + v,
+					}
+				}
+			}
+		}
+
+		audioFormat := form.Get("extract_audio")
+		if audioFormat != "" && !utils.ValidAudioFormat(audioFormat) {
+			return http.StatusBadRequest, models.UploadResponse{
+				Message: "Unsupported extract_audio format: " + audioFormat + " (expected mp3 or aac)",
+			}
+		}
+
+		// Optionally reframe the video to one of the standard formats
+		var processOpts utils.VideoProcessOptions
+		var videoFormat services.MediaFormat
+		var videoFit string
+		if targetFormat := form.Get("video_format"); targetFormat != "" {
+			format, ok := services.FindFormat(targetFormat)
+			if !ok {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Unsupported video_format: " + targetFormat,
+				}
+			}
+
+			videoFit = form.Get("fit")
+			if videoFit == "" {
+				videoFit = services.FitCover
+			}
+			if !services.ValidFitMode(videoFit) {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Unsupported fit mode: " + videoFit + " (expected cover or contain)",
+				}
+			}
+
+			background := color.NRGBA{A: 255} // black bars by default
+			if bg := form.Get("background"); bg != "" {
+				if background, err = services.ParseHexColor(bg); err != nil {
+					return http.StatusBadRequest, models.UploadResponse{
+						Message: "Invalid background color: " + err.Error(),
+					}
+				}
+			}
+
+			videoFormat = format
+			processOpts.Filter = services.VideoFitFilter(format, videoFit, background)
+		}
+
+		// Name the processed video as configured, or as the upload asks
+		processedNaming := cmp.Or(form.Get("processed_naming"), h.cfg.Storage.ProcessedNaming)
+		if !validProcessedNaming(processedNaming) {
+			return http.StatusBadRequest, models.UploadResponse{
+				Message: "Unsupported processed_naming: " + processedNaming + " (expected suffix, extension or original)",
+			}
+		}
+		processedKey := form.Get("processed_key")
+		if processedKey != "" {
+			if _, _, err := utils.SplitObjectKey(processedKey, ""); err != nil {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Invalid processed_key: " + err.Error(),
+				}
+			}
+		}
+
+		// Web playback wants faststart, archival uploads can skip the extra pass
+		processOpts.Faststart = h.cfg.Media.Faststart
+		if v := form.Get("faststart"); v != "" {
+			processOpts.Faststart = v == "true"
 		}
-		defer os.Remove(tempPath) // Get path for metadata extraction (will be either original or processed)
+
+		// Optionally keep the original when transcoding doesn't make it smaller
+		preferSmaller := h.cfg.Media.PreferSmaller
+		if v := form.Get("prefer_smaller"); v != "" {
+			preferSmaller = v == "true"
+		}
+
+		// Videos below the size threshold are stored without transcoding
+		transcodeMinBytes := h.cfg.Media.TranscodeMinBytes
+		if v := form.Get("transcode_min_bytes"); v != "" {
+			if transcodeMinBytes, err = strconv.ParseInt(v, 10, 64); err != nil || transcodeMinBytes < 0 {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Invalid transcode_min_bytes: " + v + " (expected a non-negative number of bytes)",
+				}
+			}
+		}
+
+		// Videos below the bitrate floor aren't compressed further
+		bitrateFloor := h.cfg.Media.TranscodeBitrateFloor
+		if v := form.Get("bitrate_floor"); v != "" {
+			if bitrateFloor, err = strconv.ParseInt(v, 10, 64); err != nil || bitrateFloor < 0 {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Invalid bitrate_floor: " + v + " (expected a non-negative bitrate in bits per second)",
+				}
+			}
+		}
+
+		// Copy the audio when MP4 can hold it, otherwise re-encode it to AAC
+		processOpts.AudioBitrate = h.cfg.Media.AudioBitrate
+		processOpts.TranscodeAudio = probe != nil && !utils.MP4AudioCompatible(probe)
+
+		// Capture what ffmpeg did for debug=true, the caller checked the admin key
+		var debugLog *utils.TailBuffer
+		if form.Get("debug") == "true" {
+			debugLog = utils.NewTailBuffer(utils.DebugLogLimit)
+			processOpts.Log = debugLog
+		}
+		// Report the fallback encode and the like alongside the result
+		processOpts.Warn = func(warning string) {
+			warnings = append(warnings, warning)
+		}
+
+		// Optionally mix surround audio down to stereo, which many phones can't play.
+		// Mono and stereo sources are left alone.
+		if form.Get("downmix_stereo") == "true" && probe != nil {
+			for _, audio := range probe.StreamsOfType("audio") {
+				if audio.Channels > 2 {
+					processOpts.DownmixStereo = true
+				}
+			}
+		}
+
+		// Compatible sources can be copied into MP4 instead of re-encoded
+		remuxOnly := form.Get("remux_only") == "true"
+
+		// Optionally drop all but the first video and audio stream
+		processOpts.NormalizeStreams = form.Get("normalize_streams") == "true"
+
+		// Optionally use a fixed GOP size so segments align when packaging
+		if v := form.Get("keyframe_interval"); v != "" {
+			keyframes, err := utils.ParseKeyframeInterval(v)
+			if err != nil {
+				return http.StatusBadRequest, models.UploadResponse{
+					Message: "Invalid keyframe_interval: " + err.Error(),
+				}
+			}
+			if probe != nil && probe.VideoStream() != nil {
+				keyframes = keyframes.Resolve(probe.VideoStream().FrameRate())
+			}
+			processOpts.Keyframes = keyframes
+		}
+
+		// Optionally encode with another allowlisted encoder, e.g. a hardware one
+		if codec := form.Get("video_codec"); codec != "" {
+			if status, code, err := h.checkVideoCodec(codec); err != nil {
+				return status, models.UploadResponse{Code: code, Message: err.Error()}
+			}
+			processOpts.VideoCodec = codec
+		}
+
+		// Small clips and videos that are already web-optimized are stored as-is when
+		// enabled, transcoding them would only cost CPU and often grow the file. Options
+		// that change the output always need a transcode.
+		var skipReason string
+		if processOpts.Filter == "" && !processOpts.NormalizeStreams && processOpts.Keyframes == (utils.KeyframeInterval{}) && processOpts.VideoCodec == "" {
+			if transcodeMinBytes > 0 && int64(len(fileBytes)) < transcodeMinBytes {
+				skipReason = skipReasonBelowMinBytes
+			} else if h.cfg.Media.SkipOptimizedTranscode && probe != nil {
+				if ok, reason := utils.CanSkipTranscode(tempPath, probe, h.cfg.Media.SkipTranscodeMaxBitRate); ok {
+					skipReason = skipReasonOptimized
+				} else {
+					logrus.Infof("Transcoding %s: %s", fileName, reason)
+				}
+			}
+		}
+		transcodeSkipped := skipReason != ""
+
+		// Compressing a source that is already below the floor mostly degrades it without
+		// making it smaller: copy it into MP4 when possible, otherwise encode it closer to
+		// the source quality
+		var belowFloor bool
+		if !transcodeSkipped && bitrateFloor > 0 && probe != nil {
+			if bitRate := probe.BitRate(); bitRate > 0 && bitRate < bitrateFloor {
+				logrus.Infof("Video %s is below the bitrate floor (%d < %d), not compressing it further", fileName, bitRate, bitrateFloor)
+				belowFloor = true
+				remuxOnly = true
+				processOpts.RelaxedQuality = true
+			}
+		}
+		timer.lap(phaseMetadata)
+
+		// Remember what processing changes for the response message
+		sourceIsMP4 := strings.EqualFold(filepath.Ext(fileName), ".mp4")
+
+		// Get path for metadata extraction (will be either original or processed)
 		metadataPath := tempPath
 		var wasProcessed bool // Process video: reduce bitrate while maintaining original resolution and convert to MP4
-		processedPath, processed, err := utils.ProcessVideoWithBitrateReduction(tempPath)
+		var processedPath string
+		var audioSettings *models.AudioSettings
+		var remuxed bool
+		if transcodeSkipped {
+			logrus.Infof("Skipping transcode of video %s: %s", fileName, skipReason)
+		} else {
+			// Reframing, keyframes, downmixing and another encoder need a transcode, as do
+			// incompatible codecs
+			if remuxOnly && probe != nil && processOpts.Filter == "" && processOpts.VideoCodec == "" &&
+				processOpts.Keyframes == (utils.KeyframeInterval{}) && !processOpts.DownmixStereo {
+				if ok, reason := utils.CanRemux(probe); !ok {
+					logrus.Infof("Transcoding %s instead of remuxing: %s", fileName, reason)
+				} else if processedPath, err = utils.RemuxToMP4(tempPath, processOpts); errors.Is(err, mediaexec.ErrTimeout) {
+					return processingTimeout(fileName, err)
+				} else if err != nil {
+					logrus.Warnf("Remuxing %s failed, transcoding instead: %v", fileName, err)
+					warnings = append(warnings, "Video could not be remuxed and was transcoded instead")
+				} else {
+					remuxed = true
+					audioSettings = &models.AudioSettings{Codec: "copy"}
+				}
+			}
+
+			processed := remuxed
+			if !remuxed {
+				processedPath, processed, audioSettings, err = utils.ProcessVideoWithBitrateReduction(tempPath, processOpts)
+			}
+			if errors.Is(err, mediaexec.ErrTimeout) {
+				status, response := processingTimeout(fileName, err)
+				if debugLog != nil {
+					response.DebugLog = debugLog.String()
+				}
+				return status, response
+			}
+			if err != nil {
+				// Log the error for debugging
+				fmt.Printf("Video processing error: %v\n", err)
+
+				// Check if it's a format we can handle without processing
+				if strings.HasSuffix(strings.ToLower(fileName), ".mp4") {
+					// If it's already MP4 but processing failed, we can try to use the original
+					fmt.Println("Skipping processing for MP4 file that couldn't be converted")
+					wasProcessed = false
+					warnings = append(warnings, "Video processing failed, the original MP4 was stored")
+				} else {
+					// For other formats that aren't MP4, we must convert them
+					response := models.UploadResponse{
+						Message:  "Failed to process non-MP4 video: " + err.Error(),
+						FileType: fileType,
+						FileName: fileName,
+					}
+					if debugLog != nil {
+						response.DebugLog = debugLog.String()
+					}
+					return http.StatusInternalServerError, response
+				}
+			} else {
+				wasProcessed = processed
+			}
+		}
+
+		// Only a plain re-encode can be swapped for the original, options that change the
+		// output and the cut of longer videos must be kept
+		var sizeComparison *models.SizeComparison
+		if wasProcessed && preferSmaller && probe != nil && probe.Duration() <= utils.MaxVideoDuration &&
+			processOpts.Filter == "" && !processOpts.NormalizeStreams && processOpts.Keyframes == (utils.KeyframeInterval{}) &&
+			processOpts.VideoCodec == "" && !processOpts.DownmixStereo {
+			if info, err := os.Stat(processedPath); err != nil {
+				logrus.Warnf("Failed to compare the size of processed video %s, keeping it: %v", fileName, err)
+				warnings = append(warnings, "Size of the processed video could not be compared, it was kept")
+			} else {
+				originalSize := int64(len(fileBytes))
+				sizeComparison = &models.SizeComparison{
+					Chosen:        sizeChoiceProcessed,
+					OriginalSize:  originalSize,
+					ProcessedSize: info.Size(),
+					SizeDelta:     info.Size() - originalSize,
+				}
+				if info.Size() >= originalSize {
+					logrus.Infof("Processed video %s is not smaller than the original (%d >= %d bytes), storing the original", fileName, info.Size(), originalSize)
+					sizeComparison.Chosen = sizeChoiceOriginal
+					os.Remove(processedPath)
+					wasProcessed = false
+				}
+			}
+		}
+
+		// If processing happened, make sure to clean up the processed file too
+		if wasProcessed {
+			defer os.Remove(processedPath)
+
+			// Read the processed file to update fileBytes
+			fileBytes, err = os.ReadFile(processedPath)
+			if err != nil {
+				return http.StatusInternalServerError, models.UploadResponse{
+					Message: "Failed to read processed video: " + err.Error(),
+				}
+			}
+
+			fileName = processedVideoName(fileName, processedNaming, processedKey)
+			modified = true
+			fileType = "video/mp4" // Update the file type since we processed it
+			// The name may keep the original extension, so storage can't guess the type
+			outputType = fileType
+			metadataPath = processedPath
+		}
+
+		timer.lap(phaseTranscode)
+
+		// Get metadata from the video (original or converted)
+		dimensions, err := utils.GetVideoMetadata(metadataPath)
 		if err != nil {
-			// Log the error for debugging
-			fmt.Printf("Video processing error: %v\n", err)
-
-			// Check if it's a format we can handle without processing
-			if strings.HasSuffix(strings.ToLower(header.Filename), ".mp4") {
-				// If it's already MP4 but processing failed, we can try to use the original
-				fmt.Println("Skipping processing for MP4 file that couldn't be converted")
-				wasProcessed = false
+			// If we can't get metadata, continue with basic info
+			logrus.Warnf("Failed to extract video metadata: %v", err)
+			warnings = append(warnings, "Video metadata could not be extracted, dimensions and duration are missing")
+			metadataErr = err
+			fileInfo = &models.FileInfo{
+				FileType: "video",
+			}
+		} else {
+			// Calculate original aspect ratio
+			ratio := float64(dimensions.Width) / float64(dimensions.Height)
+
+			standardFormat := resizer.MatchFormat(dimensions.Width, dimensions.Height)
+
+			ratioStr := utils.FormatRatio(ratio)
+
+			fileInfo = &models.FileInfo{
+				FileType:            "video",
+				Width:               dimensions.Width,
+				Height:              dimensions.Height,
+				OriginalRatio:       ratioStr,
+				MatchedFormat:       standardFormat.FormattedRatio,
+				MatchedFormatName:   standardFormat.Name,
+				MatchedFormatWidth:  standardFormat.Width,
+				MatchedFormatHeight: standardFormat.Height,
+				Duration:            dimensions.Duration,
+				Orientation:         dimensions.Orientation(),
+			}
+			if form.Get("rank_formats") == "true" {
+				fileInfo.RankedFormats = rankFormats(resizer, dimensions.Width, dimensions.Height)
+			}
+		}
+
+		if wasProcessed && processOpts.NormalizeStreams && probe != nil {
+			fileInfo.StreamNormalization = &models.StreamNormalization{
+				Present: len(probe.Streams),
+				Dropped: len(probe.Streams) - probe.NormalizedStreamCount(),
+			}
+		}
+
+		if belowFloor && wasProcessed {
+			fileInfo.BitrateFloor = &models.BitrateFloor{
+				SourceBitRate: probe.BitRate(),
+				Floor:         bitrateFloor,
+				Action:        bitrateFloorRelaxed,
+			}
+			if remuxed {
+				fileInfo.BitrateFloor.Action = bitrateFloorRemuxed
+			}
+		}
+
+		if transcodeSkipped {
+			fileInfo.TranscodeSkipped = true
+			fileInfo.TranscodeSkipReason = skipReason
+			if skipReason == skipReasonBelowMinBytes {
+				message = fmt.Sprintf("Video is smaller than %d bytes and was stored without transcoding", transcodeMinBytes)
 			} else {
-				// For other formats that aren't MP4, we must convert them
-				c.JSON(http.StatusInternalServerError, models.UploadResponse{
-					Message:  "Failed to process non-MP4 video: " + err.Error(),
-					FileType: fileType,
-					FileName: header.Filename,
-				})
-				return
+				message = "Video is already web-optimized (H.264 MP4 with faststart) and was stored without transcoding"
+			}
+		}
+
+		fileInfo.SizeComparison = sizeComparison
+		if sizeComparison != nil && sizeComparison.Chosen == sizeChoiceOriginal {
+			message = fmt.Sprintf("Processing didn't make the video smaller (%d bytes larger), so the original was stored", sizeComparison.SizeDelta)
+		}
+
+		// Report the reframing only when the processed output actually has it
+		if wasProcessed && processOpts.Filter != "" {
+			fileInfo.OutputFormat = videoFormat.FormattedRatio
+			fileInfo.OutputWidth = videoFormat.Width
+			fileInfo.OutputHeight = videoFormat.Height
+			fileInfo.FitMode = videoFit
+		}
+
+		if wasProcessed {
+			fileInfo.Faststart = &processOpts.Faststart
+			if !remuxed {
+				fileInfo.VideoCodec = cmp.Or(processOpts.VideoCodec, utils.DefaultVideoCodec)
+			}
+			// Report how the audio was encoded, videos without audio have nothing to report
+			if probe == nil {
+				fileInfo.AudioSettings = audioSettings
+			} else if tracks := probe.StreamsOfType("audio"); len(tracks) > 0 && audioSettings != nil {
+				audioSettings.SourceChannelLayout = tracks[0].Layout()
+				audioSettings.ChannelLayout = audioSettings.SourceChannelLayout
+				if processOpts.DownmixStereo {
+					audioSettings.ChannelLayout = "stereo"
+				}
+				fileInfo.AudioSettings = audioSettings
 			}
-		} else {
-			wasProcessed = processed
+			trimmed := probe != nil && probe.Duration() > utils.MaxVideoDuration
+			message = videoProcessedMessage(!sourceIsMP4, trimmed, remuxed, fileInfo.OutputFormat)
+			if belowFloor && !remuxed {
+				message += ". Its bitrate was already below the floor, so it was encoded at a higher quality"
+			}
+			fileInfo.VideoProcessing = "transcode"
+			if remuxed {
+				fileInfo.VideoProcessing = "remux"
+			}
+		}
+		if debugLog != nil {
+			fileInfo.DebugLog = debugLog.String()
 		}
 
-		// If processing happened, make sure to clean up the processed file too
-		if wasProcessed {
-			defer os.Remove(processedPath)
+		timer.lap(phaseMetadata)
 
-			// Read the processed file to update fileBytes
-			fileBytes, err = os.ReadFile(processedPath)
-			if err != nil {
-				c.JSON(http.StatusInternalServerError, models.UploadResponse{
-					Message: "Failed to read processed video: " + err.Error(),
+		baseName := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+
+		// Generate the animated preview from the (possibly processed) video
+		if previewOpts != nil {
+			previewPath := strings.TrimSuffix(metadataPath, filepath.Ext(metadataPath)) + "_preview." + previewOpts.Format
+			defer os.Remove(previewPath)
+			if err := utils.GenerateAnimatedPreview(metadataPath, previewPath, *previewOpts); err != nil {
+				logrus.Warnf("Failed to generate animated preview: %v", err)
+				warnings = append(warnings, "Animated preview could not be generated")
+			} else {
+				info := fileInfo
+				extras = append(extras, extraUpload{
+					kind: artifactPreview,
+					path: previewPath,
+					name: baseName + "_preview." + previewOpts.Format,
+					onDone: func(url, key string) {
+						info.PreviewURL = url
+						info.PreviewKey = key
+					},
 				})
-				return
 			}
-
-			// Update the filename to have .mp4 extension
-			header.Filename = strings.TrimSuffix(header.Filename, filepath.Ext(header.Filename)) + "_processed.mp4"
-			fileType = "video/mp4" // Update the file type since we processed it
-			metadataPath = processedPath
 		}
 
-		// Get metadata from the video (original or converted)
-		dimensions, err := utils.GetVideoMetadata(metadataPath)
-		if err != nil {
-			// If we can't get metadata, continue with basic info
-			fileInfo = &models.FileInfo{
-				FileType: "video",
+		// Extract a poster frame, either at a fixed time or the best scoring of several
+		if wantSmartPoster := form.Get("smart_poster") == "true"; wantSmartPoster || form.Get("poster") == "true" {
+			posterPath := strings.TrimSuffix(metadataPath, filepath.Ext(metadataPath)) + "_poster.jpg"
+			defer os.Remove(posterPath)
+
+			var posterTime float64
+			selected := false
+			if wantSmartPoster {
+				if posterTime, err = utils.SelectPosterFrame(metadataPath, posterPath, fileInfo.Duration); err != nil {
+					logrus.Warnf("Failed to select poster frame, falling back to a fixed time: %v", err)
+					warnings = append(warnings, "Smart poster selection failed, the poster was taken at a fixed time")
+				} else {
+					selected = true
+				}
 			}
-		} else {
-			// Calculate original aspect ratio
-			ratio := float64(dimensions.Width) / float64(dimensions.Height)
+			if !selected {
+				posterTime = utils.FixedPosterTime(fileInfo.Duration)
+				err = utils.ExtractFrame(metadataPath, posterPath, posterTime)
+			}
+			if err != nil {
+				logrus.Warnf("Failed to extract poster frame: %v", err)
+				warnings = append(warnings, "Poster frame could not be extracted")
+			} else {
+				info := fileInfo
+				extras = append(extras, extraUpload{
+					kind: artifactThumbnail,
+					path: posterPath,
+					name: baseName + "_poster.jpg",
+					onDone: func(url, key string) {
+						info.PosterURL = url
+						info.PosterKey = key
+						info.PosterTime = &posterTime
+					},
+				})
+			}
+		}
 
-			standardFormat := resizer.DetectFormat(dimensions.Width, dimensions.Height)
+		// List subtitle tracks from the original, processing drops them
+		if probe != nil {
+			fileInfo.Subtitles = utils.ListSubtitleTracks(probe)
+		}
 
-			num, den := utils.FloatToRatio(ratio, 100)
+		// Extract each text subtitle track to WebVTT when requested
+		if form.Get("extract_subtitles") == "true" {
+			for i := range fileInfo.Subtitles {
+				track := &fileInfo.Subtitles[i]
+				if !utils.CanExtractSubtitle(track.Codec) {
+					logrus.Warnf("Skipping subtitle track %d: %s cannot be converted to WebVTT", track.Index, track.Codec)
+					warnings = append(warnings, fmt.Sprintf("Subtitle track %d (%s) cannot be converted to WebVTT and was skipped", track.Index, track.Codec))
+					continue
+				}
 
-			ratioStr := fmt.Sprintf("%d:%d", num, den)
+				suffix := strconv.Itoa(track.Index)
+				if track.Language != "" {
+					suffix += "_" + track.Language
+				}
+				subtitlePath := strings.TrimSuffix(tempPath, filepath.Ext(tempPath)) + "_subtitle_" + suffix + ".vtt"
+				defer os.Remove(subtitlePath)
+				if err := utils.ExtractSubtitle(tempPath, track.Index, subtitlePath); err != nil {
+					logrus.Warnf("Failed to extract subtitle track %d: %v", track.Index, err)
+					warnings = append(warnings, fmt.Sprintf("Subtitle track %d could not be extracted", track.Index))
+					continue
+				}
+				extras = append(extras, extraUpload{
+					kind: artifactSubtitle,
+					path: subtitlePath,
+					name: baseName + "_subtitle_" + suffix + ".vtt",
+					onDone: func(url, key string) {
+						track.URL = url
+						track.Key = key
+					},
+				})
+			}
+		}
 
-			fileInfo = &models.FileInfo{
-				FileType:      "video",
-				Width:         dimensions.Width,
-				Height:        dimensions.Height,
-				OriginalRatio: ratioStr,
-				MatchedFormat: standardFormat,
-				Duration:      dimensions.Duration,
+		// Extract the audio track from the original as a separate asset
+		if audioFormat != "" {
+			audioPath := strings.TrimSuffix(tempPath, filepath.Ext(tempPath)) + "_audio" + utils.AudioExtension(audioFormat)
+			defer os.Remove(audioPath)
+			audio := extractAudio(tempPath, audioPath, audioFormat, probe)
+			if audio.Note == "" {
+				extras = append(extras, extraUpload{
+					kind: artifactAudio,
+					path: audioPath,
+					name: baseName + "_audio" + utils.AudioExtension(audioFormat),
+					onDone: func(url, key string) {
+						audio.URL = url
+						audio.Key = key
+					},
+				})
 			}
+			fileInfo.ExtractedAudio = audio
 		}
 	} else {
 		fileInfo = &models.FileInfo{
 			FileType: fileType,
 		}
+		// Images whose dimensions couldn't be read are stored without them
+		if isImage {
+			fileInfo.FileType = "image"
+			message = "Image uploaded successfully without metadata, its dimensions could not be read"
+		}
+		if skipMetadata {
+			message = "File uploaded successfully without metadata extraction"
+		}
+		timer.lap(phaseMetadata)
 	}
-	// Upload to S3
-	// Create a temporary file to store file bytes
-	tempFile, err := os.CreateTemp("", "upload-*")
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.UploadResponse{
-			Message: "Failed to create temporary file: " + err.Error(),
+	if fileInfo.FileType == "image" || fileInfo.FileType == "video" {
+		reportMetadata(fileInfo, metadataErr)
+	}
+	if documentPage != nil {
+		fileInfo.PageCount = documentPage.Pages
+		// Other documents have no dimensions, TIFFs decoded as images keep theirs
+		if fileInfo.Width == 0 {
+			fileInfo.Width, fileInfo.Height = documentPage.Width, documentPage.Height
+		}
+		info := fileInfo
+		extras = append(extras, extraUpload{
+			kind: artifactThumbnail,
+			path: documentPreviewPath,
+			name: strings.TrimSuffix(fileName, filepath.Ext(fileName)) + "_page1.jpg",
+			onDone: func(url, key string) {
+				info.PreviewURL = url
+				info.PreviewKey = key
+			},
 		})
-		return
+		if message == "" {
+			message = "Document uploaded successfully with a preview of its first page"
+		}
+	}
+	// Images end with resizing, videos with derived files
+	timer.lap(phaseTranscode)
+
+	// Names derived while processing (e.g. _processed.mp4) follow key_case too. Objects
+	// that are already stored keep their key unless processing replaced them.
+	keyCase, _ := h.keyCase(form.Get("key_case"))
+	if storedURL == "" || modified {
+		fileName = utils.ApplyKeyCase(fileName, keyCase)
 	}
-	defer os.Remove(tempFile.Name())
-	defer tempFile.Close()
 
-	// Write original file bytes to temp file
-	if _, err := tempFile.Write(fileBytes); err != nil {
-		c.JSON(http.StatusInternalServerError, models.UploadResponse{
-			Message: "Failed to write to temporary file: " + err.Error(),
-		})
-		return
+	// Optionally tell browsers whether to display or download the file, under its
+	// original name with the stored extension
+	var uploadOpts storage.UploadOptions
+	if disposition != "" {
+		downloadName := strings.TrimSuffix(originalFileName, filepath.Ext(originalFileName)) + filepath.Ext(fileName)
+		uploadOpts.ContentDisposition = utils.ContentDisposition(disposition, downloadName)
+	}
+	// Processed output has its own type, the declared or hinted one only describes the upload
+	if outputType != "" {
+		uploadOpts.ContentType = outputType
+	} else if (declaredType != "" || hinted) && !modified {
+		uploadOpts.ContentType = fileType
+	}
+	uploadOpts.ExpiresInDays = expiresInDays
+	// The hash taken while reading the upload doubles as the checksum of unchanged files
+	if !modified {
+		uploadOpts.SHA256 = contentHash
+	}
+	if form.Get("embed_metadata") == "true" {
+		uploadOpts.Metadata = objectMetadata(fileInfo)
+		meta, _ := h.parseMeta(form) // validated by the caller
+		maps.Copy(uploadOpts.Metadata, metaObjectMetadata(meta))
 	}
 
-	// Seek to beginning of file for reading
-	if _, err := tempFile.Seek(0, 0); err != nil {
-		c.JSON(http.StatusInternalServerError, models.UploadResponse{
-			Message: "Failed to seek temporary file: " + err.Error(),
-		})
-		return
+	// Objects that are already stored only need uploading when processing changed them
+	// or their headers, tags or metadata are set
+	result := &storage.UploadResult{URL: storedURL}
+	if storedURL == "" || modified || uploadOpts.ContentDisposition != "" || uploadOpts.ContentType != "" || uploadOpts.ExpiresInDays > 0 || len(uploadOpts.Metadata) > 0 {
+		// Upload to S3
+		// Create a temporary file to store file bytes
+		tempFile, err := utils.CreateTemp("upload-*")
+		if err != nil {
+			return http.StatusInternalServerError, models.UploadResponse{
+				Message: "Failed to create temporary file: " + err.Error(),
+			}
+		}
+		defer os.Remove(tempFile.Name())
+		defer tempFile.Close()
+
+		// Write original file bytes to temp file
+		if _, err := tempFile.Write(fileBytes); err != nil {
+			return http.StatusInternalServerError, models.UploadResponse{
+				Message: "Failed to write to temporary file: " + err.Error(),
+			}
+		}
+
+		// Seek to beginning of file for reading
+		if _, err := tempFile.Seek(0, 0); err != nil {
+			return http.StatusInternalServerError, models.UploadResponse{
+				Message: "Failed to seek temporary file: " + err.Error(),
+			}
+		}
+
+		result, err = h.storage.Upload(tempFile, keyPrefix+fileName, uploadOpts)
+		if err != nil {
+			return http.StatusInternalServerError, models.UploadResponse{
+				Message: "Failed to upload to S3: " + err.Error(),
+			}
+		}
 	}
 
-	fileURL, err := h.uploadToS3(tempFile, header.Filename, awsConfig)
-	if err != nil {
-		c.JSON(http.StatusInternalServerError, models.UploadResponse{
-			Message: "Failed to upload to S3: " + err.Error(),
-		})
-		return
-	} // Prepare response	message := "File uploaded successfully without processing"
-	// Track video processing for message
-	originalExt := c.Request.FormValue("originalExt")
-	if strings.Contains(header.Filename, "_processed") && strings.HasSuffix(header.Filename, ".mp4") {
-		message = "Video was processed: bitrate reduced while maintaining original resolution, cut to 59 seconds, and converted to MP4 format"
-	} else if strings.HasSuffix(header.Filename, ".mp4") &&
-		(originalExt != "" || strings.HasPrefix(fileInfo.FileType, "video/")) {
-		message = "Video converted to MP4 and uploaded successfully"
+	// Upload derived files (previews, subtitles) next to the main file
+	artifacts, extraWarnings := h.uploadExtras(extras, keyPrefix, keyCase, storage.UploadOptions{ExpiresInDays: expiresInDays})
+	warnings = append(warnings, extraWarnings...)
+	timer.lap(phaseUpload)
+
+	// The stored file comes first, as in file_url
+	primary := models.Artifact{
+		Type:   artifactOriginal,
+		URL:    result.URL,
+		Key:    keyPrefix + fileName,
+		Size:   int64(len(fileBytes)),
+		Format: artifactFormat(fileName),
+	}
+	if modified {
+		primary.Type = artifactProcessed
+	}
+	primary.Width, primary.Height = storedDimensions(fileInfo)
+	artifacts = append([]models.Artifact{primary}, artifacts...)
+
+	if message == "" {
+		message = "File uploaded successfully without processing"
 	}
 
 	response := models.UploadResponse{
-		FileName:      header.Filename,
-		FileURL:       fileURL,
-		FileType:      fileInfo.FileType,
-		FileSize:      int64(len(fileBytes)),
-		Width:         fileInfo.Width,
-		Height:        fileInfo.Height,
-		OriginalRatio: fileInfo.OriginalRatio,
-		MatchedFormat: fileInfo.MatchedFormat,
-		AspectRatio:   fileInfo.OriginalRatio,
-		Duration:      fileInfo.Duration,
-		Message:       message,
+		FileName:            fileName,
+		OriginalFileName:    originalFileName,
+		Key:                 keyPrefix + fileName,
+		FileURL:             result.URL,
+		Checksum:            result.Checksum,
+		ChecksumAlgorithm:   result.ChecksumAlgorithm,
+		SHA256:              hex.EncodeToString(contentHash),
+		VerifiedSize:        result.VerifiedSize,
+		Fallback:            result.Fallback,
+		FileType:            fileInfo.FileType,
+		FileSize:            int64(len(fileBytes)),
+		Width:               fileInfo.Width,
+		Height:              fileInfo.Height,
+		OriginalRatio:       fileInfo.OriginalRatio,
+		MatchedFormat:       fileInfo.MatchedFormat,
+		MatchedFormatName:   fileInfo.MatchedFormatName,
+		MatchedFormatWidth:  fileInfo.MatchedFormatWidth,
+		MatchedFormatHeight: fileInfo.MatchedFormatHeight,
+		RankedFormats:       fileInfo.RankedFormats,
+		Orientation:         fileInfo.Orientation,
+		AspectRatio:         fileInfo.OriginalRatio,
+		Duration:            fileInfo.Duration,
+		MetadataAvailable:   fileInfo.MetadataAvailable,
+		MetadataError:       fileInfo.MetadataError,
+		OutputFormat:        fileInfo.OutputFormat,
+		OutputWidth:         fileInfo.OutputWidth,
+		OutputHeight:        fileInfo.OutputHeight,
+		FitMode:             fileInfo.FitMode,
+		UpscaleAvoided:      fileInfo.UpscaleAvoided,
+		FiltersApplied:      fileInfo.FiltersApplied,
+		PreviewURL:          fileInfo.PreviewURL,
+		PreviewKey:          fileInfo.PreviewKey,
+		PosterURL:           fileInfo.PosterURL,
+		PosterKey:           fileInfo.PosterKey,
+		PosterTime:          fileInfo.PosterTime,
+		QualityScore:        fileInfo.QualityScore,
+		IsBlurry:            fileInfo.IsBlurry,
+		ColorInfo:           fileInfo.ColorInfo,
+		IPTC:                fileInfo.IPTC,
+		Histogram:           fileInfo.Histogram,
+		LQIP:                fileInfo.LQIP,
+		Frames:              fileInfo.Frames,
+		PageCount:           fileInfo.PageCount,
+		ConvertedFromCMYK:   fileInfo.ConvertedFromCMYK,
+		DPI:                 fileInfo.DPI,
+		AvatarShape:         fileInfo.AvatarShape,
+		TranscodeSkipped:    fileInfo.TranscodeSkipped,
+		TranscodeSkipReason: fileInfo.TranscodeSkipReason,
+		VideoProcessing:     fileInfo.VideoProcessing,
+		VideoCodec:          fileInfo.VideoCodec,
+		Faststart:           fileInfo.Faststart,
+		AudioSettings:       fileInfo.AudioSettings,
+		DebugLog:            fileInfo.DebugLog,
+		Timings:             timer.timings(),
+		StreamNormalization: fileInfo.StreamNormalization,
+		BitrateFloor:        fileInfo.BitrateFloor,
+		SizeComparison:      fileInfo.SizeComparison,
+		Subtitles:           fileInfo.Subtitles,
+		ExtractedAudio:      fileInfo.ExtractedAudio,
+		Artifacts:           artifacts,
+		Warnings:            warnings,
+		Message:             message,
+	}
+	if expiresInDays > 0 {
+		expiresAt := utils.ExpiryTime(time.Now(), expiresInDays)
+		response.ExpiresAt = &expiresAt
 	}
 
-	c.JSON(http.StatusOK, response)
+	return http.StatusOK, response
 }
 
-func (h *UploadHandler) uploadToS3(file *os.File, fileName string, config models.UploadRequest) (string, error) {
-	// Create a production-ready HTTP client with robust TLS configuration
-	var rootCAs *x509.CertPool
-	
-	// Try to load system root CAs, with fallback for Docker environments
-	if systemRoots, err := x509.SystemCertPool(); err != nil {
-		logrus.Warnf("Failed to load system cert pool, using default: %v", err)
-		rootCAs = nil // Use Go's built-in root CAs as fallback
-	} else {
-		rootCAs = systemRoots
+// reportMetadata records whether the dimensions of an image or video could be
+// extracted, so clients can tell missing ones from 0x0
+func reportMetadata(fileInfo *models.FileInfo, err error) {
+	available := err == nil
+	fileInfo.MetadataAvailable = &available
+	if err != nil {
+		fileInfo.MetadataError = err.Error()
+	}
+}
+
+// processingTimeout is the response for uploads whose ffmpeg run was killed at
+// FFMPEG_TIMEOUT. Inputs that keep ffmpeg busy that long won't do better on a retry.
+func processingTimeout(fileName string, err error) (int, models.UploadResponse) {
+	logrus.Errorf("Processing %s timed out: %v", fileName, err)
+	return http.StatusUnprocessableEntity, models.UploadResponse{
+		Code:     "processing_timeout",
+		Message:  "Processing took too long and was aborted: " + err.Error(),
+		FileName: fileName,
 	}
+}
 
-	// Additional certificate handling for Docker/production environments
-	if certFile := os.Getenv("SSL_CERT_FILE"); certFile != "" {
-		if certData, err := os.ReadFile(certFile); err == nil {
-			if rootCAs == nil {
-				rootCAs = x509.NewCertPool()
-			}
-			rootCAs.AppendCertsFromPEM(certData)
-			logrus.Infof("Loaded additional certificates from %s", certFile)
+// checkVideoCodec returns the status, code and error for a video_codec that isn't
+// allowlisted or that the installed ffmpeg can't encode with
+func (h *UploadHandler) checkVideoCodec(codec string) (int, string, error) {
+	if allowed := h.cfg.Media.VideoCodecs; !slices.Contains(allowed, codec) {
+		return http.StatusBadRequest, "video_codec_not_allowed", fmt.Errorf("video_codec %s is not enabled on this server, allowed: %s", codec, strings.Join(allowed, ", "))
+	}
+	if err := utils.CheckVideoEncoder(codec); errors.Is(err, utils.ErrEncoderUnavailable) {
+		return http.StatusUnprocessableEntity, "video_codec_unavailable", fmt.Errorf("Unavailable video_codec: %w", err)
+	} else if err != nil {
+		logrus.Warnf("Failed to check video_codec %s: %v", codec, err)
+	}
+	return 0, "", nil
+}
+
+// processingOptions are the upload options that need the file probed, decoded or
+// processed
+var processingOptions = []string{
+	"format", "avatar", "dpi", "convert_srgb", "color_info", "quality_score", "histogram", "lqip",
+	"extract_iptc", "rank_formats", "video_format", "video_codec", "remux_only", "normalize_streams",
+	"keyframe_interval", "downmix_stereo", "preview", "poster", "smart_poster", "extract_audio",
+	"extract_subtitles", "embed_metadata", "render_preview", "debug",
+}
+
+// requestsProcessing reports whether form sets any of the processingOptions
+func requestsProcessing(form url.Values) bool {
+	for _, name := range processingOptions {
+		if v := form.Get(name); v != "" && v != "false" {
+			return true
+		}
+	}
+	return false
+}
+
+// objectMetadata returns the properties of an upload stored as user metadata of its
+// object with embed_metadata, so storage can be searched by them. Dimensions are the
+// ones of the stored file, i.e. after resizing.
+func objectMetadata(fileInfo *models.FileInfo) map[string]string {
+	meta := map[string]string{}
+	set := func(key, value string) {
+		if value != "" && value != "0" {
+			meta[key] = value
+		}
+	}
+	width, height := storedDimensions(fileInfo)
+	set("width", strconv.Itoa(width))
+	set("height", strconv.Itoa(height))
+	set("aspect-ratio", fileInfo.OriginalRatio)
+	set("matched-format", fileInfo.MatchedFormat)
+	if fileInfo.Duration > 0 {
+		set("duration", strconv.FormatFloat(fileInfo.Duration, 'f', 3, 64))
+	}
+	set("frames", strconv.Itoa(fileInfo.Frames))
+	set("dpi", strconv.Itoa(fileInfo.DPI))
+	return meta
+}
+
+// rankFormats lists the standard formats closest to width x height first, for clients
+// offering more than the single matched format
+func rankFormats(resizer *services.Resizer, width, height int) []models.FormatMatch {
+	ranked := resizer.RankFormats(width, height)
+	matches := make([]models.FormatMatch, len(ranked))
+	for i, f := range ranked {
+		matches[i] = models.FormatMatch{
+			Format:     f.FormattedRatio,
+			Name:       f.Name,
+			Width:      f.Width,
+			Height:     f.Height,
+			Difference: f.Difference,
+		}
+	}
+	return matches
+}
+
+// extractAudio transcodes the audio track of the video at videoPath to audioPath and
+// describes the result. Videos without audio, or whose audio can't be extracted, only
+// get a note instead of failing the upload.
+func extractAudio(videoPath, audioPath, format string, probe *utils.ProbeResult) *models.ExtractedAudio {
+	if probe != nil && len(probe.StreamsOfType("audio")) == 0 {
+		return &models.ExtractedAudio{Format: format, Note: "Video has no audio track"}
+	}
+	if err := utils.ExtractAudio(videoPath, audioPath, format); err != nil {
+		logrus.Warnf("Failed to extract audio: %v", err)
+		return &models.ExtractedAudio{Format: format, Note: "Audio could not be extracted"}
+	}
+
+	audio := &models.ExtractedAudio{}
+	if audioProbe, err := utils.ProbeMedia(audioPath); err != nil {
+		logrus.Warnf("Failed to probe extracted audio: %v", err)
+	} else if info := utils.AudioInfo(audioProbe); info != nil {
+		audio = info
+	}
+	audio.Format = format
+	return audio
+}
+
+// parseFilterStrength parses the strength of an optional image filter. Empty and
+// "false" disable it, "true" uses def.
+func parseFilterStrength(v string, def, max float64) (float64, error) {
+	switch v {
+	case "", "false":
+		return 0, nil
+	case "true":
+		return def, nil
+	}
+	strength, err := strconv.ParseFloat(v, 64)
+	if err != nil || strength < 0 || strength > max {
+		return 0, fmt.Errorf("%s (expected true or a strength from 0 to %g)", v, max)
+	}
+	return strength, nil
+}
+
+// videoProcessedMessage describes what transcoding or remuxing did to a video
+func videoProcessedMessage(converted, trimmed, remuxed bool, reframedTo string) string {
+	var changes []string
+	switch {
+	case reframedTo != "":
+		changes = append(changes, "reframed to "+reframedTo)
+	case remuxed:
+		changes = append(changes, "copied into MP4 without re-encoding")
+	default:
+		changes = append(changes, "bitrate reduced while maintaining original resolution")
+	}
+	if trimmed {
+		changes = append(changes, fmt.Sprintf("cut to %d seconds", utils.MaxVideoDuration))
+	}
+	if !remuxed {
+		if converted {
+			changes = append(changes, "converted to MP4 format")
 		} else {
-			logrus.Warnf("Failed to load certificate file %s: %v", certFile, err)
+			changes = append(changes, "re-encoded as H.264")
 		}
 	}
 
-	// Check for common certificate bundle locations in Docker containers
-	certPaths := []string{
-		"/etc/ssl/certs/ca-certificates.crt", // Debian/Ubuntu
-		"/etc/pki/tls/certs/ca-bundle.crt",   // RHEL/CentOS
-		"/etc/ssl/ca-bundle.pem",             // OpenSUSE
+	last := len(changes) - 1
+	if last == 0 {
+		return "Video was processed: " + changes[0]
 	}
-	
-	for _, certPath := range certPaths {
-		if _, err := os.Stat(certPath); err == nil {
-			if certData, err := os.ReadFile(certPath); err == nil {
-				if rootCAs == nil {
-					rootCAs = x509.NewCertPool()
-				}
-				rootCAs.AppendCertsFromPEM(certData)
-				logrus.Infof("Loaded certificates from %s", certPath)
-				break
+	return "Video was processed: " + strings.Join(changes[:last], ", ") + " and " + changes[last]
+}
+
+// Reasons reported in transcode_skip_reason
+const (
+	skipReasonBelowMinBytes = "below_min_bytes"
+	skipReasonOptimized     = "already_optimized"
+)
+
+// Files reported as chosen in size_comparison with prefer_smaller
+const (
+	sizeChoiceOriginal  = "original"
+	sizeChoiceProcessed = "processed"
+)
+
+// Names of processed videos, see PROCESSED_NAMING
+const (
+	processedNamingSuffix    = "suffix"    // clip_processed.mp4
+	processedNamingExtension = "extension" // clip.mp4
+	processedNamingOriginal  = "original"  // clip.mov
+)
+
+func validProcessedNaming(naming string) bool {
+	return naming == processedNamingSuffix || naming == processedNamingExtension || naming == processedNamingOriginal
+}
+
+// processedVideoName returns the name a processed (MP4) video is stored under: key when
+// the upload set processed_key, otherwise fileName named as naming says
+func processedVideoName(fileName, naming, key string) string {
+	if key != "" {
+		return key
+	}
+	base := strings.TrimSuffix(fileName, filepath.Ext(fileName))
+	switch naming {
+	case processedNamingExtension:
+		return base + ".mp4"
+	case processedNamingOriginal:
+		return fileName
+	default:
+		return base + "_processed.mp4"
+	}
+}
+
+// Actions reported in bitrate_floor for videos below it
+const (
+	bitrateFloorRemuxed = "remuxed"
+	bitrateFloorRelaxed = "relaxed"
+)
+
+// Types of the artifacts listed in upload responses
+const (
+	artifactOriginal  = "original"
+	artifactProcessed = "processed"
+	artifactThumbnail = "thumbnail"
+	artifactPreview   = "preview"
+	artifactAudio     = "audio"
+	artifactSubtitle  = "subtitle"
+)
+
+// extraUpload is a file derived from the upload (preview, subtitles, ...) that is
+// stored next to it, listed as an artifact of kind. onDone receives the URL and object
+// key once the upload succeeded.
+type extraUpload struct {
+	kind   string
+	path   string
+	name   string
+	onDone func(url, key string)
+}
+
+// uploadExtras uploads derived files and returns them as artifacts. They are
+// auxiliary, so failures are logged and returned as warnings instead of failing the
+// request.
+func (h *UploadHandler) uploadExtras(extras []extraUpload, keyPrefix, keyCase string, opts storage.UploadOptions) ([]models.Artifact, []string) {
+	var artifacts []models.Artifact
+	var warnings []string
+	for _, extra := range extras {
+		f, err := os.Open(extra.path)
+		if err != nil {
+			logrus.Warnf("Failed to open %s: %v", extra.path, err)
+			warnings = append(warnings, extra.name+" could not be stored")
+			continue
+		}
+		key := keyPrefix + utils.ApplyKeyCase(extra.name, keyCase)
+		result, err := h.storage.Upload(f, key, opts)
+		if err != nil {
+			f.Close()
+			logrus.Warnf("Failed to upload %s: %v", extra.name, err)
+			warnings = append(warnings, extra.name+" could not be stored")
+			continue
+		}
+		extra.onDone(result.URL, key)
+
+		artifact := models.Artifact{Type: extra.kind, URL: result.URL, Key: key, Format: artifactFormat(extra.name)}
+		if info, err := f.Stat(); err == nil {
+			artifact.Size = info.Size()
+		}
+		// Posters and GIF previews are images the standard decoders can size
+		if _, err := f.Seek(0, io.SeekStart); err == nil {
+			if config, _, err := image.DecodeConfig(f); err == nil {
+				artifact.Width, artifact.Height = config.Width, config.Height
 			}
 		}
+		f.Close()
+		artifacts = append(artifacts, artifact)
 	}
+	return artifacts, warnings
+}
 
-	httpClient := &http.Client{
-		Transport: &http.Transport{
-			TLSClientConfig: &tls.Config{
-				InsecureSkipVerify: false,
-				RootCAs:            rootCAs,
-				ServerName:         "", // Let Go handle server name verification
-				MinVersion:         tls.VersionTLS12,
-				MaxVersion:         tls.VersionTLS13,
-				// Additional settings for production environments
-				CipherSuites: []uint16{
-					tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
-					tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
-					tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
-				},
-			},
-			// Optimized transport settings for production
-			DisableKeepAlives:     false,
-			IdleConnTimeout:       30 * time.Second,
-			TLSHandshakeTimeout:   10 * time.Second,
-			ExpectContinueTimeout: 1 * time.Second,
-			MaxIdleConns:          100,
-			MaxIdleConnsPerHost:   10,
-		},
-	}
-
-	// Create AWS session with custom HTTP client
-	sess, err := session.NewSession(&aws.Config{
-		Region: aws.String(config.AWSRegion),
-		Credentials: credentials.NewStaticCredentials(
-			config.AWSAccessKeyID,
-			config.AWSSecretAccessKey,
-			"",
-		),
-		HTTPClient: httpClient,
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to create AWS session: %v", err)
-	}
-
-	// Create an uploader with optimized settings for better performance
-	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
-		// Increase part size to 10MB for better performance with larger files
-		u.PartSize = 10 * 1024 * 1024 // 10MB
-		// Increase concurrency for faster uploads
-		u.Concurrency = 5
-	})
-
-	logrus.Infof("Starting S3 upload for file: %s", fileName)
-
-	// Upload the file to S3 with optimized settings
-	result, err := uploader.Upload(&s3manager.UploadInput{
-		Bucket: aws.String(config.S3BucketName),
-		Key:    aws.String(fileName),
-		Body:   file,
-		ACL:    aws.String("public-read"), // Set ACL to public-read if needed
-	})
-	if err != nil {
-		return "", fmt.Errorf("failed to upload file: %v", err)
-	}
+// artifactFormat returns the format of an artifact from its file extension
+func artifactFormat(name string) string {
+	return strings.ToLower(strings.TrimPrefix(filepath.Ext(name), "."))
+}
 
-	logrus.Infof("Successfully uploaded file to S3: %s", result.Location)
-	return result.Location, nil
+// storedDimensions returns the dimensions of the stored file, i.e. after resizing
+func storedDimensions(fileInfo *models.FileInfo) (int, int) {
+	if fileInfo.OutputWidth > 0 {
+		return fileInfo.OutputWidth, fileInfo.OutputHeight
+	}
+	return fileInfo.Width, fileInfo.Height
 }
 
 // HandleSimpleUpload processes images normally but only extracts aspect ratio for videos
@@ -375,49 +1902,74 @@ func (h *UploadHandler) HandleSimpleUpload(c *gin.Context) {
 	contentType := c.GetHeader("Content-Type")
 	logrus.Infof("Received request with Content-Type: %s", contentType)
 
-	// Handle special case when content type might have issues with boundary
-	if !strings.Contains(contentType, "boundary=") {
-		logrus.Warnf("Content-Type doesn't contain boundary parameter, trying to handle anyway")
+	// Reject bodies that can't be multipart forms before reading them
+	if status, response, ok := contentTypeError(contentType); !ok {
+		logrus.Warnf("Rejecting upload with Content-Type %q: %s", contentType, response.Message)
+		c.JSON(status, response)
+		return
 	}
 
 	// Try to parse the multipart form
-	if err := c.Request.ParseMultipartForm(10 << 20); err != nil {
+	defer removeSpillFiles(c.Request)
+	if err := h.parseMultipartForm(c); err != nil {
 		logrus.Errorf("Failed to parse multipart form: %v", err)
-		c.JSON(http.StatusBadRequest, models.UploadResponse{
-			Message: "Failed to parse multipart form: " + err.Error(),
-		})
+		c.JSON(formParseError(err))
 		return
 	}
 
-	resizer := services.NewResizer(90)
-	awsConfig := models.UploadRequest{
-		AWSAccessKeyID:     os.Getenv("AWS_ACCESS_KEY_ID"),
-		AWSSecretAccessKey: os.Getenv("AWS_SECRET_ACCESS_KEY"),
-		AWSRegion:          os.Getenv("AWS_REGION"),
-		S3BucketName:       os.Getenv("AWS_S3_BUCKET"),
+	// Every temp file of the request is removed when it ends, or as soon as the
+	// client disconnects while a video is still being trimmed or uploaded
+	files := utils.NewTempFiles(c.Request.Context())
+	defer files.RemoveAll()
+
+	bounds, err := parseDimensionBounds(c)
+	if err != nil {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Message: "Invalid dimension bounds: " + err.Error(),
+		})
+		return
 	}
 
-	// Validate AWS credentials
-	if awsConfig.AWSAccessKeyID == "" || awsConfig.AWSSecretAccessKey == "" ||
-		awsConfig.AWSRegion == "" || awsConfig.S3BucketName == "" {
+	resizer := services.DefaultResizer()
+	keyPrefix, err := h.keyPrefix(c)
+	if err != nil {
 		c.JSON(http.StatusBadRequest, models.UploadResponse{
-			Message: "AWS credentials and configuration are required",
+			Message: "Invalid object key options: " + err.Error(),
 		})
 		return
 	}
 
+	meta, err := h.parseMeta(c.Request.Form)
+	if err != nil {
+		c.JSON(invalidMeta(err))
+		return
+	}
+
 	// Get the file from form data
-	file, header, err := c.Request.FormFile("file")
+	file, header, err := formFile(c, h.strictMultipart(c))
+	if errors.Is(err, errExtraParts) {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:    "unexpected_parts",
+			Message: "Rejected strict multipart form: " + err.Error(),
+		})
+		return
+	}
 	if err != nil {
 		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:    "missing_file",
 			Message: "Failed to get file from form data: " + err.Error(),
 		})
 		return
 	}
 	defer file.Close()
 
-	// Read file into memory
-	fileBytes, err := io.ReadAll(file)
+	// Normalize the name used for the object key, keeping the original for the response
+	originalFileName := header.Filename
+	keyCase, _ := h.keyCase(c.Request.FormValue("key_case")) // validated with the key prefix
+	header.Filename = utils.ApplyKeyCase(utils.NormalizeFilename(header.Filename, h.cfg.Storage.FilenameStrategy), keyCase)
+
+	// Read file into memory, hashing it on the way for the key and checksum
+	fileBytes, contentHash, err := utils.ReadAllSHA256(file)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.UploadResponse{
 			Message: "Failed to read file: " + err.Error(),
@@ -425,18 +1977,98 @@ func (h *UploadHandler) HandleSimpleUpload(c *gin.Context) {
 		return
 	}
 
-	// Get file type without processing
+	// A client that disconnected mid-upload leaves a partial file ffprobe may misread
+	if err := checkComplete(header, len(fileBytes)); err != nil {
+		logrus.Warnf("Rejecting truncated upload of %s: %v", header.Filename, err)
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:     "truncated_upload",
+			Message:  "Upload is incomplete: " + err.Error(),
+			FileName: header.Filename,
+		})
+		return
+	}
+
+	// Empty files would be stored as junk 0-byte objects
+	if len(fileBytes) == 0 {
+		c.JSON(http.StatusBadRequest, models.UploadResponse{
+			Code:     "empty_file",
+			Message:  "File is empty",
+			FileName: header.Filename,
+		})
+		return
+	}
+
+	if !h.moderate(c, fileBytes, header.Filename) {
+		return
+	}
+
+	// Name the object with the configured key strategy
+	if keyPrefix, header.Filename, err = h.generateKey(keyPrefix, header.Filename, fileBytes, contentHash, keyCase); err != nil {
+		c.JSON(http.StatusInternalServerError, models.UploadResponse{
+			Message: "Failed to generate object key: " + err.Error(),
+		})
+		return
+	}
+
+	// Get file type without processing, a declared content type is checked like on /upload
 	fileType := http.DetectContentType(fileBytes)
+	uploadOpts := storage.UploadOptions{SHA256: contentHash}
+	if declared := c.Request.FormValue("content_type"); declared != "" {
+		if fileType, err = utils.CheckContentType(declared, fileBytes); err != nil {
+			c.JSON(http.StatusBadRequest, models.UploadResponse{
+				Code:     "content_type_mismatch",
+				Message:  err.Error(),
+				FileName: header.Filename,
+			})
+			return
+		}
+		uploadOpts.ContentType = fileType
+	} else if ext := c.Request.FormValue("original_extension"); ext != "" {
+		var hinted bool
+		if fileType, hinted, err = utils.HintedContentType(fileType, fileBytes, ext); err != nil {
+			c.JSON(http.StatusBadRequest, models.UploadResponse{
+				Message: err.Error(),
+			})
+			return
+		}
+		if hinted {
+			uploadOpts.ContentType = fileType
+		}
+	}
+	if v := c.Request.FormValue("expires_in"); v != "" {
+		if uploadOpts.ExpiresInDays, err = utils.ParseExpiresIn(v); err != nil {
+			c.JSON(http.StatusBadRequest, models.UploadResponse{
+				Message: err.Error(),
+			})
+			return
+		}
+	}
 	var fileInfo *models.FileInfo
 	var message string
+	var warnings []string
+
+	isImage := strings.HasPrefix(fileType, "image/") && fileType != "image/svg+xml"
+	var imageDimensions struct{ Width, Height int }
+	var imageErr error
+	if isImage {
+		if imageDimensions, imageErr = utils.GetImageDimensions(fileBytes); imageErr != nil {
+			// Without bounds to check the image can be stored without its dimensions
+			if bounds != (dimensionBounds{}) {
+				c.JSON(http.StatusInternalServerError, models.UploadResponse{
+					Message: "Failed to get image dimensions: " + imageErr.Error(),
+				})
+				return
+			}
+			logrus.Warnf("Failed to get dimensions of image %s, storing it without: %v", header.Filename, imageErr)
+			warnings = append(warnings, "Image dimensions could not be read")
+		}
+	}
 
-	if strings.HasPrefix(fileType, "image/") {
+	if isImage && imageErr == nil {
 		// Process images the same way as the original endpoint
-		dimensions, err := utils.GetImageDimensions(fileBytes)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, models.UploadResponse{
-				Message: "Failed to get image dimensions: " + err.Error(),
-			})
+		dimensions := imageDimensions
+		if err := bounds.check(dimensions.Width, dimensions.Height); err != nil {
+			c.JSON(rejectDimensions(header.Filename, dimensions.Width, dimensions.Height, err))
 			return
 		}
 
@@ -444,89 +2076,127 @@ func (h *UploadHandler) HandleSimpleUpload(c *gin.Context) {
 		ratio := float64(dimensions.Width) / float64(dimensions.Height)
 
 		// Get the closest standard aspect ratio without resizing
-		standardFormat := resizer.DetectFormat(dimensions.Width, dimensions.Height)
+		standardFormat := resizer.MatchFormat(dimensions.Width, dimensions.Height)
 
-		num, den := utils.FloatToRatio(ratio, 100)
-		ratioStr := fmt.Sprintf("%d:%d", num, den)
+		ratioStr := utils.FormatRatio(ratio)
 
 		fileInfo = &models.FileInfo{
-			FileType:      "image",
-			Width:         dimensions.Width,
-			Height:        dimensions.Height,
-			OriginalRatio: ratioStr,
-			MatchedFormat: standardFormat,
+			FileType:            "image",
+			Width:               dimensions.Width,
+			Height:              dimensions.Height,
+			OriginalRatio:       ratioStr,
+			MatchedFormat:       standardFormat.FormattedRatio,
+			MatchedFormatName:   standardFormat.Name,
+			MatchedFormatWidth:  standardFormat.Width,
+			MatchedFormatHeight: standardFormat.Height,
+			Orientation:         utils.ImageOrientation(fileBytes, dimensions.Width, dimensions.Height),
+		}
+		reportMetadata(fileInfo, nil)
+		if c.Request.FormValue("rank_formats") == "true" {
+			fileInfo.RankedFormats = rankFormats(resizer, dimensions.Width, dimensions.Height)
 		}
 		message = "Image uploaded successfully with metadata extracted"
 
 	} else if strings.HasPrefix(fileType, "video/") || utils.IsVideoFile(header.Filename) {
 		// For videos, extract aspect ratio and trim to first 30 seconds
-		tempPath := filepath.Join(os.TempDir(), header.Filename)
-		if err := os.WriteFile(tempPath, fileBytes, 0644); err != nil {
+		videoFile, err := files.Create("video-*" + filepath.Ext(header.Filename))
+		if err == nil {
+			_, err = videoFile.Write(fileBytes)
+			if closeErr := videoFile.Close(); err == nil {
+				err = closeErr
+			}
+		}
+		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.UploadResponse{
 				Message: "Failed to create temp video file: " + err.Error(),
 			})
 			return
 		}
-		defer os.Remove(tempPath)
+		tempPath := videoFile.Name()
 
 		// Get metadata from the original video
 		dimensions, err := utils.GetVideoMetadata(tempPath)
-		if err != nil {
+		if errors.Is(err, utils.ErrNoVideoStream) {
+			// The container only holds audio, it is trimmed and stored as such
+			if h.cfg.Media.NoVideoStreamPolicy == "reject" {
+				c.JSON(http.StatusUnprocessableEntity, models.UploadResponse{
+					Code:     "no_video_stream",
+					Message:  "File does not contain a video stream",
+					FileName: header.Filename,
+				})
+				return
+			}
+			fileInfo = &models.FileInfo{
+				FileType: "audio",
+			}
+			if probe, err := utils.ProbeMedia(tempPath); err == nil {
+				fileInfo.Duration = probe.Duration()
+			}
+		} else if err != nil {
 			// If we can't get metadata, continue with basic info
 			fileInfo = &models.FileInfo{
 				FileType: "video",
 			}
+			reportMetadata(fileInfo, err)
 			logrus.Warnf("Failed to extract video metadata: %v", err)
+			warnings = append(warnings, "Video metadata could not be extracted, dimensions and duration are missing")
 		} else {
+			// Reject out of range videos before trimming
+			if err := bounds.check(dimensions.Width, dimensions.Height); err != nil {
+				c.JSON(rejectDimensions(header.Filename, dimensions.Width, dimensions.Height, err))
+				return
+			}
+
 			// Calculate original aspect ratio
 			ratio := float64(dimensions.Width) / float64(dimensions.Height)
-			standardFormat := resizer.DetectFormat(dimensions.Width, dimensions.Height)
+			standardFormat := resizer.MatchFormat(dimensions.Width, dimensions.Height)
 
-			num, den := utils.FloatToRatio(ratio, 100)
-			ratioStr := fmt.Sprintf("%d:%d", num, den)
+			ratioStr := utils.FormatRatio(ratio)
 
 			fileInfo = &models.FileInfo{
-				FileType:      "video",
-				Width:         dimensions.Width,
-				Height:        dimensions.Height,
-				OriginalRatio: ratioStr,
-				MatchedFormat: standardFormat,
-				Duration:      dimensions.Duration,
+				FileType:            "video",
+				Width:               dimensions.Width,
+				Height:              dimensions.Height,
+				OriginalRatio:       ratioStr,
+				MatchedFormat:       standardFormat.FormattedRatio,
+				MatchedFormatName:   standardFormat.Name,
+				MatchedFormatWidth:  standardFormat.Width,
+				MatchedFormatHeight: standardFormat.Height,
+				Duration:            dimensions.Duration,
+				Orientation:         dimensions.Orientation(),
+			}
+			reportMetadata(fileInfo, nil)
+			if c.Request.FormValue("rank_formats") == "true" {
+				fileInfo.RankedFormats = rankFormats(resizer, dimensions.Width, dimensions.Height)
 			}
 		}
 
-		// Trim video to first 30 seconds using ffmpeg
-		trimmedPath := filepath.Join(os.TempDir(), "trimmed_"+header.Filename)
-		defer os.Remove(trimmedPath)
-
-		if err := utils.TrimVideoTo30Seconds(tempPath, trimmedPath); err != nil {
-			logrus.Errorf("Failed to trim video: %v", err)
-			c.JSON(http.StatusInternalServerError, models.UploadResponse{
-				Message: "Failed to trim video: " + err.Error(),
-			})
-			return
-		}
-
-		// For videos, upload the trimmed file directly to S3 (streaming)
-		trimmedFile, err := os.Open(trimmedPath)
+		// Trim video to first 30 seconds using ffmpeg, into a file only this request knows
+		trimmedFile, err := files.Create("trimmed-*" + filepath.Ext(header.Filename))
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.UploadResponse{
-				Message: "Failed to open trimmed video: " + err.Error(),
+				Message: "Failed to create trimmed video file: " + err.Error(),
 			})
 			return
 		}
 		defer trimmedFile.Close()
 
-		// Get file size for response
-		trimmedFileInfo, err := trimmedFile.Stat()
+		// ffmpeg is stopped when the client goes away
+		trimmedSize, err := utils.TrimVideoTo30Seconds(c.Request.Context(), tempPath, trimmedFile)
+		if errors.Is(err, mediaexec.ErrTimeout) {
+			c.JSON(processingTimeout(header.Filename, err))
+			return
+		}
 		if err != nil {
+			logrus.Errorf("Failed to trim video: %v", err)
 			c.JSON(http.StatusInternalServerError, models.UploadResponse{
-				Message: "Failed to get trimmed video info: " + err.Error(),
+				Message: "Failed to trim video: " + err.Error(),
 			})
 			return
 		}
 
-		fileURL, err := h.uploadToS3(trimmedFile, header.Filename, awsConfig)
+		// Upload straight from the handle ffmpeg wrote to
+		result, err := h.storage.Upload(trimmedFile, keyPrefix+header.Filename, storage.UploadOptions{ExpiresInDays: uploadOpts.ExpiresInDays})
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, models.UploadResponse{
 				Message: "Failed to upload trimmed video to S3: " + err.Error(),
@@ -535,22 +2205,52 @@ func (h *UploadHandler) HandleSimpleUpload(c *gin.Context) {
 		}
 
 		response := models.UploadResponse{
-			FileName:      header.Filename,
-			FileURL:       fileURL,
-			FileType:      fileInfo.FileType,
-			FileSize:      trimmedFileInfo.Size(),
-			Width:         fileInfo.Width,
-			Height:        fileInfo.Height,
-			OriginalRatio: fileInfo.OriginalRatio,
-			MatchedFormat: fileInfo.MatchedFormat,
-			AspectRatio:   fileInfo.OriginalRatio,
-			Duration:      fileInfo.Duration,
-			Message:       "Video trimmed to 30 seconds and uploaded successfully with aspect ratio extracted",
+			FileName:            header.Filename,
+			OriginalFileName:    originalFileName,
+			Key:                 keyPrefix + header.Filename,
+			FileURL:             result.URL,
+			Checksum:            result.Checksum,
+			ChecksumAlgorithm:   result.ChecksumAlgorithm,
+			SHA256:              hex.EncodeToString(contentHash),
+			VerifiedSize:        result.VerifiedSize,
+			Fallback:            result.Fallback,
+			FileType:            fileInfo.FileType,
+			FileSize:            trimmedSize,
+			Width:               fileInfo.Width,
+			Height:              fileInfo.Height,
+			OriginalRatio:       fileInfo.OriginalRatio,
+			MatchedFormat:       fileInfo.MatchedFormat,
+			MatchedFormatName:   fileInfo.MatchedFormatName,
+			MatchedFormatWidth:  fileInfo.MatchedFormatWidth,
+			MatchedFormatHeight: fileInfo.MatchedFormatHeight,
+			RankedFormats:       fileInfo.RankedFormats,
+			Orientation:         fileInfo.Orientation,
+			AspectRatio:         fileInfo.OriginalRatio,
+			Duration:            fileInfo.Duration,
+			MetadataAvailable:   fileInfo.MetadataAvailable,
+			MetadataError:       fileInfo.MetadataError,
+			Warnings:            warnings,
+			Message:             "Video trimmed to 30 seconds and uploaded successfully with aspect ratio extracted",
+		}
+		if fileInfo.FileType == "audio" {
+			response.Message = "File has no video stream; audio trimmed to 30 seconds and uploaded successfully"
+		}
+		if uploadOpts.ExpiresInDays > 0 {
+			expiresAt := utils.ExpiryTime(time.Now(), uploadOpts.ExpiresInDays)
+			response.ExpiresAt = &expiresAt
 		}
 
+		response.Meta = meta
 		c.JSON(http.StatusOK, response)
 		return
 
+	} else if isImage {
+		// Stored without the dimensions that couldn't be read
+		fileInfo = &models.FileInfo{
+			FileType: "image",
+		}
+		reportMetadata(fileInfo, imageErr)
+		message = "Image uploaded successfully without metadata, its dimensions could not be read"
 	} else {
 		fileInfo = &models.FileInfo{
 			FileType: fileType,
@@ -559,14 +2259,13 @@ func (h *UploadHandler) HandleSimpleUpload(c *gin.Context) {
 	}
 
 	// Upload original file to S3 (for images and other files)
-	tempFile, err := os.CreateTemp("", "upload-*")
+	tempFile, err := files.Create("upload-*")
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.UploadResponse{
 			Message: "Failed to create temporary file: " + err.Error(),
 		})
 		return
 	}
-	defer os.Remove(tempFile.Name())
 	defer tempFile.Close()
 
 	// Write original file bytes to temp file
@@ -585,7 +2284,7 @@ func (h *UploadHandler) HandleSimpleUpload(c *gin.Context) {
 		return
 	}
 
-	fileURL, err := h.uploadToS3(tempFile, header.Filename, awsConfig)
+	result, err := h.storage.Upload(tempFile, keyPrefix+header.Filename, uploadOpts)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, models.UploadResponse{
 			Message: "Failed to upload to S3: " + err.Error(),
@@ -594,19 +2293,39 @@ func (h *UploadHandler) HandleSimpleUpload(c *gin.Context) {
 	}
 
 	response := models.UploadResponse{
-		FileName:      header.Filename,
-		FileURL:       fileURL,
-		FileType:      fileInfo.FileType,
-		FileSize:      int64(len(fileBytes)),
-		Width:         fileInfo.Width,
-		Height:        fileInfo.Height,
-		OriginalRatio: fileInfo.OriginalRatio,
-		MatchedFormat: fileInfo.MatchedFormat,
-		AspectRatio:   fileInfo.OriginalRatio,
-		Duration:      fileInfo.Duration,
-		Message:       message,
+		FileName:            header.Filename,
+		OriginalFileName:    originalFileName,
+		Key:                 keyPrefix + header.Filename,
+		FileURL:             result.URL,
+		Checksum:            result.Checksum,
+		ChecksumAlgorithm:   result.ChecksumAlgorithm,
+		SHA256:              hex.EncodeToString(contentHash),
+		VerifiedSize:        result.VerifiedSize,
+		Fallback:            result.Fallback,
+		FileType:            fileInfo.FileType,
+		FileSize:            int64(len(fileBytes)),
+		Width:               fileInfo.Width,
+		Height:              fileInfo.Height,
+		OriginalRatio:       fileInfo.OriginalRatio,
+		MatchedFormat:       fileInfo.MatchedFormat,
+		MatchedFormatName:   fileInfo.MatchedFormatName,
+		MatchedFormatWidth:  fileInfo.MatchedFormatWidth,
+		MatchedFormatHeight: fileInfo.MatchedFormatHeight,
+		RankedFormats:       fileInfo.RankedFormats,
+		Orientation:         fileInfo.Orientation,
+		AspectRatio:         fileInfo.OriginalRatio,
+		Duration:            fileInfo.Duration,
+		MetadataAvailable:   fileInfo.MetadataAvailable,
+		MetadataError:       fileInfo.MetadataError,
+		Warnings:            warnings,
+		Message:             message,
+	}
+	if uploadOpts.ExpiresInDays > 0 {
+		expiresAt := utils.ExpiryTime(time.Now(), uploadOpts.ExpiresInDays)
+		response.ExpiresAt = &expiresAt
 	}
 
+	response.Meta = meta
 	c.JSON(http.StatusOK, response)
 }
 
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"net/http"
+	"net/textproto"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/asset_upload_service/storage"
+	"github.com/asset_upload_service/utils"
+	"github.com/gin-gonic/gin"
+)
+
+func TestHandleUploadRejectsTruncatedBody(t *testing.T) {
+	h, store := newTestHandler(t)
+	body, contentType := fileUpload(t, "clip.mp4", make([]byte, 4096))
+	// The client went away before the closing boundary and the end of the file
+	body = body[:len(body)-100]
+
+	status, response := serve(t, h.HandleUpload, uploadRequest("/upload", body, contentType))
+	if status != http.StatusBadRequest || response.Code != "truncated_upload" {
+		t.Fatalf("got %d %q (%s), want 400 truncated_upload", status, response.Code, response.Message)
+	}
+	if keys := store.keys(); len(keys) > 0 {
+		t.Errorf("truncated upload was stored as %v", keys)
+	}
+}
+
+func TestHandleUploadRejectsShortPart(t *testing.T) {
+	h, store := newTestHandler(t)
+	// The part declares more bytes than it holds, e.g. a proxy cut the stream short
+	body, contentType := multipartForm(t, formPart{
+		field:    "file",
+		fileName: "clip.mp4",
+		data:     make([]byte, 10),
+		header:   textproto.MIMEHeader{"Content-Length": {"1000"}},
+	})
+
+	status, response := serve(t, h.HandleUpload, uploadRequest("/upload", body, contentType))
+	if status != http.StatusBadRequest || response.Code != "truncated_upload" {
+		t.Fatalf("got %d %q (%s), want 400 truncated_upload", status, response.Code, response.Message)
+	}
+	if keys := store.keys(); len(keys) > 0 {
+		t.Errorf("truncated upload was stored as %v", keys)
+	}
+}
+
+func TestUploadRejectsMalformedMultipart(t *testing.T) {
+	h, _ := newTestHandler(t)
+	body, _ := fileUpload(t, "photo.jpg", []byte("data"))
+
+	tests := []struct {
+		name        string
+		contentType string
+		status      int
+		code        string
+	}{
+		{"missing boundary", "multipart/form-data", http.StatusBadRequest, "malformed_multipart"},
+		{"invalid boundary", "multipart/form-data; boundary=\"a{b}\"", http.StatusBadRequest, "malformed_multipart"},
+		{"boundary too long", "multipart/form-data; boundary=" + strings.Repeat("x", 71), http.StatusBadRequest, "malformed_multipart"},
+		{"boundary not in body", "multipart/form-data; boundary=other", http.StatusBadRequest, "malformed_multipart"},
+		{"missing content type", "", http.StatusBadRequest, "malformed_multipart"},
+		{"json body", "application/json", http.StatusUnsupportedMediaType, "unsupported_media_type"},
+	}
+	for _, tt := range tests {
+		for path, handler := range map[string]gin.HandlerFunc{"/upload": h.HandleUpload, "/upload/simple": h.HandleSimpleUpload} {
+			status, response := serve(t, handler, uploadRequest(path, body, tt.contentType))
+			if status != tt.status || response.Code != tt.code {
+				t.Errorf("%s on %s: got %d %q (%s), want %d %s", tt.name, path, status, response.Code, response.Message, tt.status, tt.code)
+			}
+		}
+	}
+}
+
+func TestUploadJSONBodyExplainsMultipart(t *testing.T) {
+	h, _ := newTestHandler(t)
+	req := uploadRequest("/upload", []byte(`{"file": "aGVsbG8="}`), "application/json")
+
+	status, response := serve(t, h.HandleUpload, req)
+	if status != http.StatusUnsupportedMediaType || !strings.Contains(response.Message, "multipart/form-data") {
+		t.Errorf("got %d %q, want 415 pointing to multipart/form-data", status, response.Message)
+	}
+}
+
+func TestUploadRejectsEmptyFile(t *testing.T) {
+	h, store := newTestHandler(t)
+	for path, handler := range map[string]gin.HandlerFunc{"/upload": h.HandleUpload, "/upload/simple": h.HandleSimpleUpload} {
+		body, contentType := fileUpload(t, "empty.jpg", nil)
+		status, response := serve(t, handler, uploadRequest(path, body, contentType))
+		if status != http.StatusBadRequest || response.Code != "empty_file" {
+			t.Errorf("%s: got %d %q (%s), want 400 empty_file", path, status, response.Code, response.Message)
+		}
+	}
+	if keys := store.keys(); len(keys) > 0 {
+		t.Errorf("empty file was stored as %v", keys)
+	}
+}
+
+func TestUploadMultibyteFilename(t *testing.T) {
+	tests := []struct {
+		strategy, key string
+	}{
+		{"keep", "Привет мир 🎉.txt"},
+		{"ascii", "Privet mir _.txt"},
+		{"slug", "privet-mir.txt"},
+	}
+	for _, tt := range tests {
+		h, store := newTestHandler(t)
+		h.cfg.Storage.FilenameStrategy = tt.strategy
+		body, contentType := fileUpload(t, "Привет мир 🎉.txt", []byte("hello"))
+
+		status, response := serve(t, h.HandleUpload, uploadRequest("/upload", body, contentType))
+		if status != http.StatusOK {
+			t.Fatalf("%s: got %d (%s), want 200", tt.strategy, status, response.Message)
+		}
+		if response.Key != tt.key || response.FileURL != testBucketURL+tt.key {
+			t.Errorf("%s: stored as %q at %q, want %q", tt.strategy, response.Key, response.FileURL, tt.key)
+		}
+		if _, ok := store.objects[tt.key]; !ok {
+			t.Errorf("%s: no object %q, stored %v", tt.strategy, tt.key, store.keys())
+		}
+		// The response keeps the name the client sent
+		if response.OriginalFileName != "Привет мир 🎉.txt" {
+			t.Errorf("%s: original_file_name = %q", tt.strategy, response.OriginalFileName)
+		}
+	}
+}
+
+func TestUploadAudioOnlyMP4(t *testing.T) {
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "audio.mp4")
+	cmd, done := mediaexec.Command(context.Background(), "ffmpeg", "-v", "error",
+		"-f", "lavfi", "-i", "anullsrc=r=44100:cl=stereo", "-t", "1", "-c:a", "aac", "-y", path)
+	if out, err := cmd.CombinedOutput(); done(err) != nil {
+		t.Fatalf("failed to create audio-only MP4: %v: %s", err, out)
+	}
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		policy   string
+		status   int
+		code     string
+		fileType string
+	}{
+		{"audio", http.StatusOK, "", "audio"},
+		{"reject", http.StatusUnprocessableEntity, "no_video_stream", ""},
+	}
+	for _, tt := range tests {
+		h, store := newTestHandler(t)
+		h.cfg.Media.NoVideoStreamPolicy = tt.policy
+		body, contentType := fileUpload(t, "audio.mp4", data)
+
+		status, response := serve(t, h.HandleUpload, uploadRequest("/upload", body, contentType))
+		if status != tt.status || response.Code != tt.code || response.FileType != tt.fileType {
+			t.Errorf("%s: got %d %q file_type %q (%s), want %d %q file_type %q", tt.policy, status, response.Code, response.FileType, response.Message, tt.status, tt.code, tt.fileType)
+		}
+		// Stored as uploaded, nothing was transcoded
+		if tt.status == http.StatusOK && !bytes.Equal(store.objects[response.Key], data) {
+			t.Errorf("%s: stored object differs from the upload", tt.policy)
+		}
+	}
+}
+
+func TestUploadConvertsCMYKJPEG(t *testing.T) {
+	data, err := os.ReadFile("../utils/testdata/cmyk.jpg")
+	if err != nil {
+		t.Fatal(err)
+	}
+	h, store := newTestHandler(t)
+	body, contentType := fileUpload(t, "print.jpg", data)
+
+	status, response := serve(t, h.HandleUpload, uploadRequest("/upload", body, contentType))
+	if status != http.StatusOK {
+		t.Fatalf("got %d %q (%s), want 200", status, response.Code, response.Message)
+	}
+	if !response.ConvertedFromCMYK {
+		t.Error("converted_from_cmyk is not set")
+	}
+	stored, ok := store.objects[response.Key]
+	if !ok {
+		t.Fatalf("no object %q, stored %v", response.Key, store.keys())
+	}
+	if utils.IsCMYKJPEG(stored) {
+		t.Error("stored image is still CMYK")
+	}
+}
+
+// spillStorage records the multipart temp files that exist while an upload is stored
+type spillStorage struct {
+	*memoryStorage
+	dir   string
+	spill []string
+}
+
+func (s *spillStorage) Upload(file *os.File, key string, opts storage.UploadOptions) (*storage.UploadResult, error) {
+	s.spill, _ = filepath.Glob(filepath.Join(s.dir, "multipart-*"))
+	return s.memoryStorage.Upload(file, key, opts)
+}
+
+func TestUploadRemovesSpillFiles(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		handler func(*UploadHandler) gin.HandlerFunc
+		field   formPart
+		status  int
+	}{
+		{"stored", "/upload", func(h *UploadHandler) gin.HandlerFunc { return h.HandleUpload }, formPart{}, http.StatusOK},
+		{"simple", "/upload/simple", func(h *UploadHandler) gin.HandlerFunc { return h.HandleSimpleUpload }, formPart{}, http.StatusOK},
+		{"rejected", "/upload", func(h *UploadHandler) gin.HandlerFunc { return h.HandleUpload },
+			formPart{field: "min_width", data: []byte("-1")}, http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		// Parts beyond MultipartMemoryMB are spilled to os.TempDir()
+		dir := t.TempDir()
+		t.Setenv("TMPDIR", dir)
+		h, store := newTestHandler(t)
+		h.cfg.MultipartMemoryMB = 1
+		spy := &spillStorage{memoryStorage: store, dir: dir}
+		h.storage = spy
+
+		parts := []formPart{{field: "file", fileName: "notes.txt", data: bytes.Repeat([]byte("spill "), 2<<20/6)}}
+		if tt.field.field != "" {
+			parts = append(parts, tt.field)
+		}
+		body, contentType := multipartForm(t, parts...)
+
+		status, response := serve(t, tt.handler(h), uploadRequest(tt.path, body, contentType))
+		if status != tt.status {
+			t.Fatalf("%s: got %d %q (%s), want %d", tt.name, status, response.Code, response.Message, tt.status)
+		}
+		if tt.status == http.StatusOK && len(spy.spill) == 0 {
+			t.Errorf("%s: the upload was not spilled to %s", tt.name, dir)
+		}
+		if left, _ := filepath.Glob(filepath.Join(dir, "multipart-*")); len(left) > 0 {
+			t.Errorf("%s: spill files left after the request: %v", tt.name, left)
+		}
+	}
+}
+
+// cancelStorage ends the request while its file is being uploaded and waits for
+// the temp file to be removed
+type cancelStorage struct {
+	*memoryStorage
+	cancel  context.CancelFunc
+	removed bool
+}
+
+func (s *cancelStorage) Upload(file *os.File, key string, opts storage.UploadOptions) (*storage.UploadResult, error) {
+	s.cancel()
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		if _, err := os.Stat(file.Name()); errors.Is(err, os.ErrNotExist) {
+			s.removed = true
+			break
+		}
+	}
+	return s.memoryStorage.Upload(file, key, opts)
+}
+
+func TestSimpleUploadCanceledRemovesTempFiles(t *testing.T) {
+	h, store := newTestHandler(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	spy := &cancelStorage{memoryStorage: store, cancel: cancel}
+	h.storage = spy
+	body, contentType := fileUpload(t, "notes.txt", []byte("hello"))
+
+	serve(t, h.HandleSimpleUpload, uploadRequest("/upload/simple", body, contentType).WithContext(ctx))
+	if !spy.removed {
+		t.Error("temp file was not removed when the request was canceled")
+	}
+	if left, _ := os.ReadDir(utils.TempDir()); len(left) > 0 {
+		t.Errorf("temp files left after the request: %v", left)
+	}
+}
+
+func TestSimpleUploadCanceledStopsFFmpeg(t *testing.T) {
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+	// An ffmpeg that trims forever, recording its pid
+	bin := t.TempDir()
+	pidFile := filepath.Join(bin, "pid")
+	script := "#!" + sh + "\necho $$ > " + pidFile + "\nsleep 30\n"
+	if err := os.WriteFile(filepath.Join(bin, "ffmpeg"), []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	h, store := newTestHandler(t)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	// The client goes away once ffmpeg is running
+	go func() {
+		for ctx.Err() == nil {
+			if _, err := os.Stat(pidFile); err == nil {
+				cancel()
+				return
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}()
+	body, contentType := fileUpload(t, "clip.mp4", make([]byte, 1024))
+
+	start := time.Now()
+	status, response := serve(t, h.HandleSimpleUpload, uploadRequest("/upload/simple", body, contentType).WithContext(ctx))
+	if elapsed := time.Since(start); elapsed > 10*time.Second {
+		t.Errorf("request took %s after the client went away", elapsed)
+	}
+	if status == http.StatusOK {
+		t.Errorf("canceled upload succeeded: %s", response.Message)
+	}
+	if keys := store.keys(); len(keys) > 0 {
+		t.Errorf("canceled upload was stored as %v", keys)
+	}
+
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		t.Fatalf("ffmpeg was not started: %v", err)
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if process, err := os.FindProcess(pid); err == nil && process.Signal(syscall.Signal(0)) == nil {
+		process.Kill()
+		t.Errorf("ffmpeg (pid %d) is still running", pid)
+	}
+	if left, _ := os.ReadDir(utils.TempDir()); len(left) > 0 {
+		t.Errorf("temp files left after the request: %v", left)
+	}
+}
@@ -0,0 +1,65 @@
+package jobs
+
+import (
+	"expvar"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Published on /debug/vars to see whether the pool keeps up
+var (
+	queueDepth  = expvar.NewInt("job_queue_depth")
+	busyWorkers = expvar.NewInt("job_workers_busy")
+	workerCount = expvar.NewInt("job_workers")
+)
+
+// Pool runs background tasks on a fixed number of workers, so async uploads can't
+// start an unbounded number of ffmpeg processes. Tasks wait in a bounded queue.
+type Pool struct {
+	queue chan func()
+}
+
+// NewPool starts workers that take tasks from a queue holding up to queueSize tasks
+func NewPool(workers, queueSize int) *Pool {
+	p := &Pool{queue: make(chan func(), queueSize)}
+	for i := 0; i < workers; i++ {
+		go p.work()
+	}
+	workerCount.Add(int64(workers))
+	return p
+}
+
+// Submit queues task, or returns false without blocking when the queue is full
+func (p *Pool) Submit(task func()) bool {
+	select {
+	case p.queue <- task:
+		queueDepth.Add(1)
+		return true
+	default:
+		return false
+	}
+}
+
+// Full reports whether the queue has no room for another task
+func (p *Pool) Full() bool {
+	return len(p.queue) == cap(p.queue)
+}
+
+func (p *Pool) work() {
+	for task := range p.queue {
+		queueDepth.Add(-1)
+		busyWorkers.Add(1)
+		p.run(task)
+		busyWorkers.Add(-1)
+	}
+}
+
+// run executes a task, a panicking task must not take its worker down
+func (p *Pool) run(task func()) {
+	defer func() {
+		if r := recover(); r != nil {
+			logrus.Errorf("Background task panicked: %v", r)
+		}
+	}()
+	task()
+}
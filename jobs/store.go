@@ -0,0 +1,109 @@
+package jobs
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/asset_upload_service/models"
+)
+
+// Store keeps the state of asynchronous uploads in memory. Finished jobs are dropped
+// once they haven't changed for the TTL; pending and running jobs are kept.
+type Store struct {
+	mu   sync.Mutex
+	jobs map[string]*models.Job
+	ttl  time.Duration
+}
+
+// NewStore returns an empty store and starts expiring finished jobs after ttl
+func NewStore(ttl time.Duration) *Store {
+	s := &Store{jobs: make(map[string]*models.Job), ttl: ttl}
+	go s.expire()
+	return s
+}
+
+// Create registers a pending job for the original stored under key, with the custom
+// metadata of the upload
+func (s *Store) Create(key, fileURL string, meta map[string]string) models.Job {
+	now := time.Now().UTC()
+	job := &models.Job{
+		ID:        newID(),
+		Status:    models.JobPending,
+		Key:       key,
+		FileURL:   fileURL,
+		Meta:      meta,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[job.ID] = job
+	return *job
+}
+
+// Get returns a copy of the job with the given ID
+func (s *Store) Get(id string) (models.Job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job, ok := s.jobs[id]
+	if !ok {
+		return models.Job{}, false
+	}
+	return *job, true
+}
+
+// Start marks a job as processing
+func (s *Store) Start(id string) {
+	s.update(id, func(job *models.Job) {
+		job.Status = models.JobProcessing
+	})
+}
+
+// Finish records the response of a job; error statuses mark it as failed
+func (s *Store) Finish(id string, statusCode int, result models.UploadResponse) {
+	s.update(id, func(job *models.Job) {
+		job.Status = models.JobCompleted
+		if statusCode >= http.StatusBadRequest {
+			job.Status = models.JobFailed
+		}
+		job.StatusCode = statusCode
+		job.Result = &result
+	})
+}
+
+func (s *Store) update(id string, fn func(job *models.Job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if job, ok := s.jobs[id]; ok {
+		fn(job)
+		job.UpdatedAt = time.Now().UTC()
+	}
+}
+
+// expire periodically drops finished jobs older than the TTL
+func (s *Store) expire() {
+	ticker := time.NewTicker(min(s.ttl, time.Minute))
+	defer ticker.Stop()
+	for range ticker.C {
+		cutoff := time.Now().Add(-s.ttl)
+		s.mu.Lock()
+		for id, job := range s.jobs {
+			finished := job.Status == models.JobCompleted || job.Status == models.JobFailed
+			if finished && job.UpdatedAt.Before(cutoff) {
+				delete(s.jobs, id)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// newID returns a random, unguessable job ID
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
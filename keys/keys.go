@@ -0,0 +1,99 @@
+// Package keys names stored objects. Strategies are registered under a name and
+// selected with KEY_STRATEGY; new ones only need to call Register.
+package keys
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// UploadMeta is what a key can be built from
+type UploadMeta struct {
+	// FileName is the sanitized upload name, or the key built by the previous
+	// strategy when strategies are chained
+	FileName string
+	Data     []byte
+	Time     time.Time
+	// SHA256 is the hash of Data when the caller computed it while reading the upload
+	SHA256 []byte
+}
+
+// KeyGenerator builds the object key of an upload, relative to the key prefix. Keys
+// may contain slashes to add directories.
+type KeyGenerator interface {
+	GenerateKey(meta UploadMeta) string
+}
+
+// KeyGeneratorFunc adapts a function to KeyGenerator
+type KeyGeneratorFunc func(meta UploadMeta) string
+
+// GenerateKey calls f(meta)
+func (f KeyGeneratorFunc) GenerateKey(meta UploadMeta) string {
+	return f(meta)
+}
+
+var (
+	mu         sync.RWMutex
+	generators = map[string]KeyGenerator{}
+)
+
+// Register makes a strategy available under name. It panics when the name is taken,
+// like registering a database driver twice.
+func Register(name string, g KeyGenerator) {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := generators[name]; ok {
+		panic("keys: strategy registered twice: " + name)
+	}
+	generators[name] = g
+}
+
+// Names returns the registered strategy names in alphabetical order
+func Names() []string {
+	mu.RLock()
+	defer mu.RUnlock()
+	return sortedNames()
+}
+
+// sortedNames lists the registered strategies, the caller holds mu
+func sortedNames() []string {
+	names := make([]string, 0, len(generators))
+	for name := range generators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// New returns the strategies named in names chained in order: each one gets the key
+// built so far as its FileName. For example hash then date stores uploads as
+// 2024/06/15/<sha256>.jpg.
+func New(names []string) (KeyGenerator, error) {
+	if len(names) == 0 {
+		return nil, fmt.Errorf("at least one key strategy is required")
+	}
+
+	mu.RLock()
+	defer mu.RUnlock()
+	chain := make(chain, len(names))
+	for i, name := range names {
+		g, ok := generators[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown key strategy %q (available: %s)", name, strings.Join(sortedNames(), ", "))
+		}
+		chain[i] = g
+	}
+	return chain, nil
+}
+
+type chain []KeyGenerator
+
+func (c chain) GenerateKey(meta UploadMeta) string {
+	for _, g := range c {
+		meta.FileName = g.GenerateKey(meta)
+	}
+	return meta.FileName
+}
@@ -0,0 +1,46 @@
+package keys
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"path"
+)
+
+// Built-in strategies
+const (
+	Original = "original" // the sanitized upload name
+	UUID     = "uuid"     // a random UUID, keeping the extension
+	Hash     = "hash"     // the SHA-256 of the contents, so identical files share a key
+	Date     = "date"     // the name under the upload date, 2024/06/15/
+)
+
+func init() {
+	Register(Original, KeyGeneratorFunc(func(meta UploadMeta) string {
+		return meta.FileName
+	}))
+	Register(UUID, KeyGeneratorFunc(func(meta UploadMeta) string {
+		return newUUID() + path.Ext(meta.FileName)
+	}))
+	Register(Hash, KeyGeneratorFunc(func(meta UploadMeta) string {
+		sum := meta.SHA256
+		if len(sum) != sha256.Size {
+			full := sha256.Sum256(meta.Data)
+			sum = full[:]
+		}
+		return hex.EncodeToString(sum) + path.Ext(meta.FileName)
+	}))
+	Register(Date, KeyGeneratorFunc(func(meta UploadMeta) string {
+		return meta.Time.UTC().Format("2006/01/02/") + meta.FileName
+	}))
+}
+
+// newUUID returns a random (version 4) UUID
+func newUUID() string {
+	var b [16]byte
+	rand.Read(b[:])
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -1,10 +1,21 @@
 package main
 
 import (
+	"fmt"
 	"log"
+	"net/http"
 	"os"
 
+	"github.com/asset_upload_service/config"
 	"github.com/asset_upload_service/handlers"
+	"github.com/asset_upload_service/jobs"
+	"github.com/asset_upload_service/keys"
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/asset_upload_service/middleware"
+	"github.com/asset_upload_service/moderation"
+	"github.com/asset_upload_service/services"
+	"github.com/asset_upload_service/storage"
+	"github.com/asset_upload_service/utils"
 	"github.com/gin-gonic/gin"
 	"github.com/joho/godotenv"
 	"github.com/sirupsen/logrus"
@@ -19,47 +30,115 @@ func main() {
 		}
 	}
 
+	cfg, err := config.Load()
+	if err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
+	level, _ := logrus.ParseLevel(cfg.Logging.Level)
+	logrus.SetLevel(level)
+	utils.SetHeaderReadSize(cfg.Media.ContentSniffBytes)
+	utils.SetRatioOptions(cfg.Media.RatioMaxDenominator, cfg.Media.RatioTolerance)
+	utils.SetSquareTolerance(cfg.Media.SquareTolerance)
+	services.SetFormatMatchTolerance(cfg.Media.FormatMatchTolerance)
+	services.SetDefaultQualities(cfg.Media.JPEGQuality, cfg.Media.WebPQuality)
+	mediaexec.SetTimeout(cfg.Media.FFmpegTimeout)
+	// Files the service and ffmpeg create are only readable by this user by default, so
+	// other users on a shared host can't read uploads. Validate checked both modes.
+	if cfg.Umask != "" {
+		umask, _ := config.ParseMode(cfg.Umask)
+		utils.SetUmask(int(umask))
+	}
+	tempFileMode, _ := config.ParseMode(cfg.TempFileMode)
+	if err := utils.SetTempDir(cfg.TempDir, tempFileMode); err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
+
 	router := gin.Default()
 
-	// Configure router with larger body size limit for multipart forms
-	// router.MaxMultipartMemory = 10 << 20 // 10 MiB
-	// Configure CORS
-	router.Use(func(c *gin.Context) {
-		c.Writer.Header().Set("Access-Control-Allow-Origin", "*")
-		c.Writer.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS, PUT, DELETE")
-		c.Writer.Header().Set("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, Accept")
-		c.Writer.Header().Set("Access-Control-Expose-Headers", "Content-Length, Content-Type")
-		c.Writer.Header().Set("Access-Control-Allow-Credentials", "true")
-
-		// Log request headers for debugging
-		logrus.Infof("Request method: %s, path: %s", c.Request.Method, c.Request.URL.Path)
-		for name, values := range c.Request.Header {
-			logrus.Infof("Header %s: %s", name, values)
-		}
+	// Configure CORS, preflights from origins outside the allowlist are rejected
+	router.Use(middleware.CORS(cfg.CORS.AllowedOrigins, handlers.LimitHeaders))
 
-		if c.Request.Method == "OPTIONS" {
-			c.AbortWithStatus(204)
-			return
-		}
+	// Log requests; only allowlisted headers are logged, never credentials
+	router.Use(middleware.RequestLogger(cfg.Logging.Headers))
 
-		c.Next()
-	}) // Set up routes
+	// Shed load once too many requests are in flight, health checks always pass
+	router.Use(middleware.ConcurrencyLimit(cfg.MaxConcurrentRequests, "/health", "/debug/vars"))
 
-	uploadHandler := handlers.NewUploadHandler()
+	// Compress JSON responses when enabled
+	if cfg.EnableGzip {
+		router.Use(middleware.Gzip())
+	}
+
+	// Health check and metrics endpoints
+	router.GET("/health", func(c *gin.Context) {
+		c.JSON(http.StatusOK, gin.H{"status": "ok"})
+	})
+	router.GET("/debug/vars", handlers.HandleMetrics)
+
+	store, err := storage.New(cfg)
+	if err != nil {
+		logrus.Fatalf("Failed to set up storage: %v", err)
+	}
+	keyGen, err := keys.New(cfg.Storage.KeyStrategy)
+	if err != nil {
+		logrus.Fatalf("Invalid configuration: %v", err)
+	}
+	pool := jobs.NewPool(cfg.Jobs.Workers, cfg.Jobs.QueueSize)
+	uploadHandler := handlers.NewUploadHandler(cfg, store, jobs.NewStore(cfg.Jobs.TTL), pool, moderation.New(cfg.Moderation), keyGen)
+
+	// Reject uploads early when the temp dir can't hold them
+	diskSpace := middleware.DiskSpace(int64(cfg.MinFreeDiskMB)<<20, cfg.DiskSpaceMultiplier)
 
 	// Standard multipart form upload endpoint
-	router.POST("/upload", uploadHandler.HandleUpload)
+	router.POST("/upload", diskSpace, uploadHandler.HandleUpload)
 
 	// Simple upload endpoint - processes images normally, extracts aspect ratio for videos
-	router.POST("/upload/simple", uploadHandler.HandleSimpleUpload)
+	router.POST("/upload/simple", diskSpace, uploadHandler.HandleSimpleUpload)
+
+	// Limits of the upload endpoints, advertised in headers for clients to check first
+	router.HEAD("/upload", uploadHandler.HandleLimits(utils.MaxVideoDuration, true))
+	router.HEAD("/upload/simple", uploadHandler.HandleLimits(utils.SimpleVideoDuration, false))
+
+	// Status and result of uploads sent with async=true
+	router.GET("/jobs/:id", uploadHandler.GetJobHandler)
 
 	// Endpoint to retrieve video aspect ratio from AWS S3
 	router.GET("/video/aspect-ratio", uploadHandler.GetVideoAspectRatioHandler)
 
+	// Endpoint to retrieve aspect ratios for a batch of video URLs
+	router.POST("/video/aspect-ratio/batch", uploadHandler.GetVideoAspectRatioBatchHandler)
+
+	// Direct-to-storage uploads hand out write access, so they require an API key
+	if len(cfg.Auth.APIKeys) > 0 {
+		authenticated := router.Group("/", middleware.APIKeyAuth(cfg.Auth.APIKeys))
+		authenticated.POST("/presign-upload", uploadHandler.HandlePresignUpload)
+		authenticated.POST("/presign-download", uploadHandler.HandlePresignDownload)
+		authenticated.POST("/finalize", diskSpace, uploadHandler.HandleFinalize)
+		authenticated.POST("/reprocess", diskSpace, uploadHandler.HandleReprocess)
+	} else {
+		logrus.Warn("No API_KEYS configured, POST /presign-upload, POST /presign-download, POST /finalize and POST /reprocess are disabled")
+	}
+
 	// Start server
-	port := ":8080"
-	logrus.Infof("Server starting on port %s", port)
-	if err := router.Run(port); err != nil {
+	// HTTP/2 is negotiated over TLS automatically, h2c covers plaintext connections
+	router.UseH2C = cfg.Server.EnableH2C
+
+	server := &http.Server{
+		Addr:              fmt.Sprintf(":%d", cfg.Port),
+		Handler:           router.Handler(),
+		ReadHeaderTimeout: cfg.Server.ReadHeaderTimeout,
+		ReadTimeout:       cfg.Server.ReadTimeout,
+		WriteTimeout:      cfg.Server.WriteTimeout,
+		IdleTimeout:       cfg.Server.IdleTimeout,
+	}
+
+	logrus.Infof("Server starting on port %s", server.Addr)
+	if cfg.Server.TLSCertFile != "" {
+		err = server.ListenAndServeTLS(cfg.Server.TLSCertFile, cfg.Server.TLSKeyFile)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		logrus.Fatalf("Failed to start server: %v", err)
 	}
 }
@@ -0,0 +1,9 @@
+//go:build !linux && !darwin
+
+package mediaexec
+
+import "os/exec"
+
+// killProcessGroup keeps exec's default of killing only the process itself, process
+// groups aren't available on this platform
+func killProcessGroup(cmd *exec.Cmd) {}
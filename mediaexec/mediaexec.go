@@ -0,0 +1,53 @@
+// Package mediaexec runs ffmpeg and ffprobe under a hard deadline, so a process stuck
+// on a pathological input can't hold on to a request (and its concurrency slot) forever.
+package mediaexec
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"time"
+)
+
+// ErrTimeout is returned when a process was killed for running past the deadline
+var ErrTimeout = errors.New("media processing timed out")
+
+// timeout bounds every ffmpeg and ffprobe run, 0 means no limit
+var timeout time.Duration
+
+// waitDelay bounds how long Wait blocks on output pipes after a kill, in case a
+// process left behind still holds them open
+const waitDelay = 5 * time.Second
+
+// SetTimeout sets the deadline of every process started afterwards. It is meant to be
+// called once at startup from the loaded configuration.
+func SetTimeout(d time.Duration) {
+	timeout = d
+}
+
+// Command is exec.CommandContext under the configured deadline. When ctx ends or the
+// deadline passes the process is killed together with the processes it spawned. Pass
+// the error of running cmd through done, which releases the deadline and turns a kill
+// for running too long into ErrTimeout.
+func Command(ctx context.Context, name string, args ...string) (cmd *exec.Cmd, done func(error) error) {
+	parent := ctx
+	cancel := context.CancelFunc(func() {})
+	if timeout > 0 {
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+	}
+
+	cmd = exec.CommandContext(ctx, name, args...)
+	killProcessGroup(cmd)
+	cmd.WaitDelay = waitDelay
+
+	return cmd, func(err error) error {
+		defer cancel()
+		// The caller's own deadline isn't ours to report
+		if err != nil && ctx.Err() != nil && parent.Err() == nil {
+			return fmt.Errorf("%w: %s ran longer than %s", ErrTimeout, filepath.Base(name), timeout)
+		}
+		return err
+	}
+}
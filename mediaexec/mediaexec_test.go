@@ -0,0 +1,40 @@
+package mediaexec
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestCommandKilledWhenContextEnds(t *testing.T) {
+	if runtime.GOOS != "linux" && runtime.GOOS != "darwin" {
+		t.Skip("process groups are not killed on " + runtime.GOOS)
+	}
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	// The child holds the output pipe like a helper ffmpeg forked, Wait only
+	// returns before waitDelay when it is killed too
+	cmd, done := Command(ctx, sh, "-c", "sleep 30 & wait")
+	time.AfterFunc(100*time.Millisecond, cancel)
+
+	start := time.Now()
+	_, err = cmd.Output()
+	err = done(err)
+	if elapsed := time.Since(start); elapsed >= waitDelay {
+		t.Errorf("process ran for %s after the context ended", elapsed)
+	}
+	if err == nil {
+		t.Fatal("canceled command succeeded")
+	}
+	// Ended by the caller, not the deadline
+	if errors.Is(err, ErrTimeout) {
+		t.Errorf("err = %v, want no ErrTimeout", err)
+	}
+}
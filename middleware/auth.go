@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyAuth only lets requests through that carry one of keys, either as
+// "Authorization: Bearer <key>" or in the X-API-Key header
+func APIKeyAuth(keys []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if HasAPIKey(c, keys) {
+			c.Next()
+			return
+		}
+
+		logrus.Warnf("Rejecting unauthenticated %s %s", c.Request.Method, c.Request.URL.Path)
+		c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{
+			"error": "Missing or invalid API key",
+		})
+	}
+}
+
+// HasAPIKey reports whether the request carries one of keys
+func HasAPIKey(c *gin.Context, keys []string) bool {
+	provided := c.GetHeader("X-API-Key")
+	if auth := c.GetHeader("Authorization"); provided == "" && strings.HasPrefix(auth, "Bearer ") {
+		provided = strings.TrimPrefix(auth, "Bearer ")
+	}
+	if provided == "" {
+		return false
+	}
+	for _, key := range keys {
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(key)) == 1 {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,54 @@
+package middleware
+
+import (
+	"expvar"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// inFlightRequests is published on /debug/vars as "inflight_requests"
+var inFlightRequests = expvar.NewInt("inflight_requests")
+
+// ConcurrencyLimit caps the number of requests processed at the same time. Requests
+// over the limit are rejected with 503 and a Retry-After header instead of queueing,
+// since every upload is held in memory. Paths in exempt are never limited.
+// A max of 0 or less disables the limit while still tracking in-flight requests.
+func ConcurrencyLimit(max int, exempt ...string) gin.HandlerFunc {
+	exemptPaths := make(map[string]bool, len(exempt))
+	for _, p := range exempt {
+		exemptPaths[p] = true
+	}
+
+	var sem chan struct{}
+	if max > 0 {
+		sem = make(chan struct{}, max)
+	}
+
+	return func(c *gin.Context) {
+		if exemptPaths[c.Request.URL.Path] {
+			c.Next()
+			return
+		}
+
+		if sem != nil {
+			select {
+			case sem <- struct{}{}:
+				defer func() { <-sem }()
+			default:
+				logrus.Warnf("Rejecting %s %s: %d requests already in flight", c.Request.Method, c.Request.URL.Path, max)
+				c.Header("Retry-After", "1")
+				c.AbortWithStatusJSON(http.StatusServiceUnavailable, gin.H{
+					"message": "Server is busy, please retry later",
+				})
+				return
+			}
+		}
+
+		inFlightRequests.Add(1)
+		defer inFlightRequests.Add(-1)
+
+		c.Next()
+	}
+}
@@ -0,0 +1,60 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// CORS sets the cross-origin headers for requests from allowedOrigins and answers
+// preflight requests. A "*" entry allows every origin, which is meant for local
+// development. Preflights from any other origin are rejected with 403 instead of
+// the permissive 204. exposedHeaders are made readable to browser clients on top of
+// Content-Length and Content-Type.
+func CORS(allowedOrigins, exposedHeaders []string) gin.HandlerFunc {
+	allowAll := false
+	origins := make(map[string]bool, len(allowedOrigins))
+	for _, o := range allowedOrigins {
+		o = strings.TrimRight(strings.TrimSpace(o), "/")
+		if o == "*" {
+			allowAll = true
+		}
+		origins[strings.ToLower(o)] = true
+	}
+	expose := strings.Join(append([]string{"Content-Length", "Content-Type"}, exposedHeaders...), ", ")
+
+	return func(c *gin.Context) {
+		origin := c.GetHeader("Origin")
+		allowed := allowAll || origins[strings.ToLower(origin)]
+
+		if allowed {
+			if allowAll {
+				c.Header("Access-Control-Allow-Origin", "*")
+			} else {
+				c.Header("Access-Control-Allow-Origin", origin)
+			}
+			c.Header("Access-Control-Allow-Methods", "POST, GET, HEAD, OPTIONS, PUT, DELETE")
+			c.Header("Access-Control-Allow-Headers", "Content-Type, Content-Length, Accept-Encoding, Authorization, X-API-Key, Accept")
+			c.Header("Access-Control-Expose-Headers", expose)
+			c.Header("Access-Control-Allow-Credentials", "true")
+		}
+		if !allowAll {
+			// The response depends on the request origin, keep caches from mixing them up
+			c.Writer.Header().Add("Vary", "Origin")
+		}
+
+		if c.Request.Method == http.MethodOptions {
+			if !allowed {
+				logrus.Warnf("Rejecting CORS preflight for %s from origin %q", c.Request.URL.Path, origin)
+				c.AbortWithStatus(http.StatusForbidden)
+				return
+			}
+			c.AbortWithStatus(http.StatusNoContent)
+			return
+		}
+
+		c.Next()
+	}
+}
@@ -0,0 +1,43 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/asset_upload_service/utils"
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// DiskSpace rejects requests with 507 Insufficient Storage when the temp dir lacks the
+// room to process them: Content-Length times multiplier (uploads are copied and
+// transcoded) plus minFree bytes that are always kept available. The check is skipped
+// when the free space can't be determined.
+func DiskSpace(minFree int64, multiplier float64) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		needed := minFree
+		if c.Request.ContentLength > 0 {
+			needed += int64(float64(c.Request.ContentLength) * multiplier)
+		}
+		if needed <= 0 {
+			c.Next()
+			return
+		}
+
+		free, err := utils.FreeDiskSpace(utils.TempDir())
+		if err != nil {
+			logrus.Warnf("Skipping free disk space check: %v", err)
+			c.Next()
+			return
+		}
+		if free < uint64(needed) {
+			logrus.Warnf("Rejecting %s %s: %d bytes free in %s, %d needed", c.Request.Method, c.Request.URL.Path, free, utils.TempDir(), needed)
+			c.AbortWithStatusJSON(http.StatusInsufficientStorage, gin.H{
+				"code":    "insufficient_storage",
+				"message": "Not enough disk space to process the upload, please retry later",
+			})
+			return
+		}
+
+		c.Next()
+	}
+}
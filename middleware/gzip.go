@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"bytes"
+	"compress/gzip"
+	"strconv"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// gzipMinSize is the smallest body worth compressing; below it the gzip
+// framing overhead outweighs the savings
+const gzipMinSize = 1024
+
+// bufferedWriter holds the response body so it can be compressed once the
+// handler is done and the final size and content type are known
+type bufferedWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *bufferedWriter) Write(data []byte) (int, error) {
+	return w.body.Write(data)
+}
+
+func (w *bufferedWriter) WriteString(s string) (int, error) {
+	return w.body.WriteString(s)
+}
+
+// Gzip compresses JSON responses for clients that accept gzip encoding.
+// Bodies smaller than gzipMinSize and non-JSON responses are sent as-is.
+func Gzip() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !strings.Contains(c.GetHeader("Accept-Encoding"), "gzip") || c.Request.Method == "HEAD" {
+			c.Next()
+			return
+		}
+
+		original := c.Writer
+		bw := &bufferedWriter{ResponseWriter: original}
+		c.Writer = bw
+		defer func() { c.Writer = original }()
+
+		c.Next()
+
+		body := bw.body.Bytes()
+		header := original.Header()
+		header.Add("Vary", "Accept-Encoding")
+
+		if len(body) < gzipMinSize || !strings.HasPrefix(header.Get("Content-Type"), "application/json") {
+			if _, err := original.Write(body); err != nil {
+				logrus.Warnf("Failed to write response: %v", err)
+			}
+			return
+		}
+
+		var compressed bytes.Buffer
+		gz := gzip.NewWriter(&compressed)
+		if _, err := gz.Write(body); err != nil || gz.Close() != nil {
+			logrus.Warnf("Failed to gzip response, sending uncompressed: %v", err)
+			if _, err := original.Write(body); err != nil {
+				logrus.Warnf("Failed to write response: %v", err)
+			}
+			return
+		}
+
+		header.Set("Content-Encoding", "gzip")
+		header.Set("Content-Length", strconv.Itoa(compressed.Len()))
+		if _, err := original.Write(compressed.Bytes()); err != nil {
+			logrus.Warnf("Failed to write response: %v", err)
+		}
+	}
+}
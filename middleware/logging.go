@@ -0,0 +1,44 @@
+package middleware
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// sensitiveHeaders carry credentials and are never logged, even when allowlisted
+var sensitiveHeaders = map[string]bool{
+	"Authorization":       true,
+	"Proxy-Authorization": true,
+	"Cookie":              true,
+	"Set-Cookie":          true,
+	"X-Api-Key":           true,
+}
+
+// RequestLogger logs the method and path of every request, and the allowlisted
+// headers at debug level
+func RequestLogger(headers []string) gin.HandlerFunc {
+	var logged []string
+	for _, name := range headers {
+		name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+		if name == "" || sensitiveHeaders[name] {
+			continue
+		}
+		logged = append(logged, name)
+	}
+
+	return func(c *gin.Context) {
+		logrus.Infof("Request method: %s, path: %s", c.Request.Method, c.Request.URL.Path)
+		if logrus.IsLevelEnabled(logrus.DebugLevel) {
+			for _, name := range logged {
+				if values := c.Request.Header.Values(name); len(values) > 0 {
+					logrus.Debugf("Header %s: %s", name, values)
+				}
+			}
+		}
+
+		c.Next()
+	}
+}
@@ -1,11 +1,6 @@
 package models
 
-type UploadRequest struct {
-	AWSAccessKeyID     string `form:"aws_access_key_id" binding:"required"`
-	AWSSecretAccessKey string `form:"aws_secret_access_key" binding:"required"`
-	AWSRegion          string `form:"aws_region" binding:"required"`
-	S3BucketName       string `form:"s3_bucket_name" binding:"required"`
-}
+import "time"
 
 type MediaFormat struct {
 	Name        string  `json:"name"`
@@ -21,31 +16,308 @@ type VideoAspectRatio struct {
 	FormattedRatio string  `json:"formatted_ratio"`
 	StandardFormat string  `json:"standard_format"`
 	Duration       float64 `json:"duration,omitempty"`
+	// Orientation of the video as played (square, portrait or landscape)
+	Orientation string `json:"orientation,omitempty"`
+	// All standard formats, closest first; only returned with rank_formats=true
+	RankedFormats []FormatMatch `json:"ranked_formats,omitempty"`
+}
+
+// FormatMatch is a standard format and how far its aspect ratio is from the file's
+type FormatMatch struct {
+	Format     string  `json:"format"`
+	Name       string  `json:"name"`
+	Width      int     `json:"width"`
+	Height     int     `json:"height"`
+	Difference float64 `json:"difference"`
+}
+
+type VideoAspectRatioResult struct {
+	URL         string            `json:"url"`
+	AspectRatio *VideoAspectRatio `json:"aspect_ratio,omitempty"`
+	Code        string            `json:"code,omitempty"`
+	Error       string            `json:"error,omitempty"`
+	// DurationMS is how long the lookup took, only reported with debug=true
+	DurationMS int64 `json:"duration_ms,omitempty"`
+}
+
+// ErrorResponse is returned by endpoints other than uploads when a request fails.
+// Code and Message match the fields of failed UploadResponses.
+type ErrorResponse struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type SubtitleTrack struct {
+	Index    int    `json:"index"`
+	Language string `json:"language,omitempty"`
+	Codec    string `json:"codec"`
+	URL      string `json:"url,omitempty"`
+	Key      string `json:"key,omitempty"`
+}
+
+// AudioSettings describes how the audio of a processed video was encoded
+type AudioSettings struct {
+	// Codec is "copy" when the source audio was kept as is
+	Codec   string `json:"codec"`
+	BitRate string `json:"bit_rate,omitempty"`
+	// Channel layouts of the source's first audio track and of the output
+	SourceChannelLayout string `json:"source_channel_layout,omitempty"`
+	ChannelLayout       string `json:"channel_layout,omitempty"`
+}
+
+type ExtractedAudio struct {
+	URL        string  `json:"url,omitempty"`
+	Key        string  `json:"key,omitempty"`
+	Format     string  `json:"format"`
+	Codec      string  `json:"codec,omitempty"`
+	Duration   float64 `json:"duration,omitempty"`
+	BitRate    int64   `json:"bit_rate,omitempty"`
+	SampleRate int     `json:"sample_rate,omitempty"`
+	Channels   int     `json:"channels,omitempty"`
+	// Note explains why no audio was extracted
+	Note string `json:"note,omitempty"`
+}
+
+type ColorInfo struct {
+	ColorSpace      string `json:"color_space"`
+	ICCProfile      bool   `json:"icc_profile"`
+	ProfileName     string `json:"profile_name,omitempty"`
+	ConvertedToSRGB bool   `json:"converted_to_srgb,omitempty"`
+}
+
+// IPTCInfo holds the descriptive IPTC/XMP fields of an image
+type IPTCInfo struct {
+	Title     string   `json:"title,omitempty"`
+	Headline  string   `json:"headline,omitempty"`
+	Caption   string   `json:"caption,omitempty"`
+	Keywords  []string `json:"keywords,omitempty"`
+	Copyright string   `json:"copyright,omitempty"`
+	Creator   string   `json:"creator,omitempty"`
+}
+
+type Histogram struct {
+	Bins      int   `json:"bins"`
+	Red       []int `json:"red"`
+	Green     []int `json:"green"`
+	Blue      []int `json:"blue"`
+	Luminance []int `json:"luminance"`
+}
+
+// Timings breaks down where the time of a debug=true upload went, in milliseconds
+type Timings struct {
+	ReadMS      int64 `json:"read_ms"`
+	MetadataMS  int64 `json:"metadata_ms"`
+	TranscodeMS int64 `json:"transcode_ms"`
+	UploadMS    int64 `json:"upload_ms"`
+	// TotalMS also covers steps outside the phases, e.g. moderation
+	TotalMS int64 `json:"total_ms"`
+}
+
+// Artifact is a file an upload stored: the upload itself, or a file derived from it
+type Artifact struct {
+	// Type is original, processed, thumbnail, preview, audio or subtitle
+	Type   string `json:"type"`
+	URL    string `json:"url"`
+	Key    string `json:"key"`
+	Size   int64  `json:"size"`
+	Format string `json:"format,omitempty"`
+	Width  int    `json:"width,omitempty"`
+	Height int    `json:"height,omitempty"`
+}
+
+// SizeComparison reports which of the original and processed video was stored with
+// prefer_smaller. SizeDelta is the processed size minus the original size.
+type SizeComparison struct {
+	Chosen        string `json:"chosen"`
+	OriginalSize  int64  `json:"original_size"`
+	ProcessedSize int64  `json:"processed_size"`
+	SizeDelta     int64  `json:"size_delta"`
+}
+
+// BitrateFloor reports how a video below the bitrate floor was spared compression
+type BitrateFloor struct {
+	SourceBitRate int64  `json:"source_bit_rate"`
+	Floor         int64  `json:"floor"`
+	Action        string `json:"action"`
+}
+
+type StreamNormalization struct {
+	Present int `json:"present"`
+	Dropped int `json:"dropped"`
 }
 
 type FileInfo struct {
-	FileType      string  `json:"file_type"`
-	Width         int     `json:"width,omitempty"`
-	Height        int     `json:"height,omitempty"`
-	OriginalRatio string  `json:"original_ratio,omitempty"`
-	AspectRatio   string  `json:"aspect_ratio,omitempty"`
-	MatchedFormat string  `json:"matched_format,omitempty"`
-	Duration      float64 `json:"duration,omitempty"`
+	FileType            string               `json:"file_type"`
+	Width               int                  `json:"width,omitempty"`
+	Height              int                  `json:"height,omitempty"`
+	OriginalRatio       string               `json:"original_ratio,omitempty"`
+	AspectRatio         string               `json:"aspect_ratio,omitempty"`
+	MatchedFormat       string               `json:"matched_format,omitempty"`
+	MatchedFormatName   string               `json:"matched_format_name,omitempty"`
+	MatchedFormatWidth  int                  `json:"matched_format_width,omitempty"`
+	MatchedFormatHeight int                  `json:"matched_format_height,omitempty"`
+	RankedFormats       []FormatMatch        `json:"ranked_formats,omitempty"`
+	Orientation         string               `json:"orientation,omitempty"`
+	Duration            float64              `json:"duration,omitempty"`
+	OutputFormat        string               `json:"output_format,omitempty"`
+	OutputWidth         int                  `json:"output_width,omitempty"`
+	OutputHeight        int                  `json:"output_height,omitempty"`
+	FitMode             string               `json:"fit_mode,omitempty"`
+	UpscaleAvoided      bool                 `json:"upscale_avoided,omitempty"`
+	FiltersApplied      []string             `json:"filters_applied,omitempty"`
+	PreviewURL          string               `json:"preview_url,omitempty"`
+	PreviewKey          string               `json:"preview_key,omitempty"`
+	PosterURL           string               `json:"poster_url,omitempty"`
+	PosterKey           string               `json:"poster_key,omitempty"`
+	PosterTime          *float64             `json:"poster_time,omitempty"`
+	QualityScore        *float64             `json:"quality_score,omitempty"`
+	IsBlurry            *bool                `json:"is_blurry,omitempty"`
+	ColorInfo           *ColorInfo           `json:"color_info,omitempty"`
+	IPTC                *IPTCInfo            `json:"iptc,omitempty"`
+	Histogram           *Histogram           `json:"histogram,omitempty"`
+	LQIP                string               `json:"lqip,omitempty"`
+	Frames              int                  `json:"frames,omitempty"`
+	PageCount           int                  `json:"page_count,omitempty"`
+	ConvertedFromCMYK   bool                 `json:"converted_from_cmyk,omitempty"`
+	DPI                 int                  `json:"dpi,omitempty"`
+	AvatarShape         string               `json:"avatar_shape,omitempty"`
+	MetadataAvailable   *bool                `json:"metadata_available,omitempty"`
+	MetadataError       string               `json:"metadata_error,omitempty"`
+	TranscodeSkipped    bool                 `json:"transcode_skipped,omitempty"`
+	TranscodeSkipReason string               `json:"transcode_skip_reason,omitempty"`
+	VideoProcessing     string               `json:"video_processing,omitempty"`
+	VideoCodec          string               `json:"video_codec,omitempty"`
+	Faststart           *bool                `json:"faststart,omitempty"`
+	AudioSettings       *AudioSettings       `json:"audio_settings,omitempty"`
+	DebugLog            string               `json:"debug_log,omitempty"`
+	StreamNormalization *StreamNormalization `json:"stream_normalization,omitempty"`
+	BitrateFloor        *BitrateFloor        `json:"bitrate_floor,omitempty"`
+	SizeComparison      *SizeComparison      `json:"size_comparison,omitempty"`
+	Subtitles           []SubtitleTrack      `json:"subtitles,omitempty"`
+	ExtractedAudio      *ExtractedAudio      `json:"extracted_audio,omitempty"`
 	// VideoCodec    string  `json:"video_codec,omitempty"`
 	// AudioCodec    string  `json:"audio_codec,omitempty"`
 	// FrameRate     float64 `json:"frame_rate,omitempty"`
 }
 
 type UploadResponse struct {
-	FileName      string  `json:"file_name"`
-	FileURL       string  `json:"file_url"`
-	FileType      string  `json:"file_type"`
-	FileSize      int64   `json:"file_size"`
-	Width         int     `json:"width,omitempty"`
-	Height        int     `json:"height,omitempty"`
-	OriginalRatio string  `json:"original_ratio,omitempty"`
-	AspectRatio   string  `json:"aspect_ratio,omitempty"`
-	MatchedFormat string  `json:"matched_format,omitempty"`
-	Duration      float64 `json:"duration,omitempty"`
-	Message       string  `json:"message"`
+	FileName            string               `json:"file_name"`
+	OriginalFileName    string               `json:"original_file_name,omitempty"`
+	Key                 string               `json:"key,omitempty"`
+	FileURL             string               `json:"file_url"`
+	Checksum            string               `json:"checksum,omitempty"`
+	ChecksumAlgorithm   string               `json:"checksum_algorithm,omitempty"`
+	SHA256              string               `json:"sha256,omitempty"`
+	VerifiedSize        int64                `json:"verified_size,omitempty"`
+	ExpiresAt           *time.Time           `json:"expires_at,omitempty"`
+	Fallback            bool                 `json:"fallback,omitempty"`
+	FileType            string               `json:"file_type"`
+	FileSize            int64                `json:"file_size"`
+	Width               int                  `json:"width,omitempty"`
+	Height              int                  `json:"height,omitempty"`
+	OriginalRatio       string               `json:"original_ratio,omitempty"`
+	AspectRatio         string               `json:"aspect_ratio,omitempty"`
+	MatchedFormat       string               `json:"matched_format,omitempty"`
+	MatchedFormatName   string               `json:"matched_format_name,omitempty"`
+	MatchedFormatWidth  int                  `json:"matched_format_width,omitempty"`
+	MatchedFormatHeight int                  `json:"matched_format_height,omitempty"`
+	RankedFormats       []FormatMatch        `json:"ranked_formats,omitempty"`
+	Orientation         string               `json:"orientation,omitempty"`
+	Duration            float64              `json:"duration,omitempty"`
+	OutputFormat        string               `json:"output_format,omitempty"`
+	OutputWidth         int                  `json:"output_width,omitempty"`
+	OutputHeight        int                  `json:"output_height,omitempty"`
+	FitMode             string               `json:"fit_mode,omitempty"`
+	UpscaleAvoided      bool                 `json:"upscale_avoided,omitempty"`
+	FiltersApplied      []string             `json:"filters_applied,omitempty"`
+	PreviewURL          string               `json:"preview_url,omitempty"`
+	PreviewKey          string               `json:"preview_key,omitempty"`
+	PosterURL           string               `json:"poster_url,omitempty"`
+	PosterKey           string               `json:"poster_key,omitempty"`
+	PosterTime          *float64             `json:"poster_time,omitempty"`
+	QualityScore        *float64             `json:"quality_score,omitempty"`
+	IsBlurry            *bool                `json:"is_blurry,omitempty"`
+	ColorInfo           *ColorInfo           `json:"color_info,omitempty"`
+	IPTC                *IPTCInfo            `json:"iptc,omitempty"`
+	Histogram           *Histogram           `json:"histogram,omitempty"`
+	LQIP                string               `json:"lqip,omitempty"`
+	Frames              int                  `json:"frames,omitempty"`
+	PageCount           int                  `json:"page_count,omitempty"`
+	ConvertedFromCMYK   bool                 `json:"converted_from_cmyk,omitempty"`
+	DPI                 int                  `json:"dpi,omitempty"`
+	AvatarShape         string               `json:"avatar_shape,omitempty"`
+	MetadataAvailable   *bool                `json:"metadata_available,omitempty"`
+	MetadataError       string               `json:"metadata_error,omitempty"`
+	TranscodeSkipped    bool                 `json:"transcode_skipped,omitempty"`
+	TranscodeSkipReason string               `json:"transcode_skip_reason,omitempty"`
+	VideoProcessing     string               `json:"video_processing,omitempty"`
+	VideoCodec          string               `json:"video_codec,omitempty"`
+	Faststart           *bool                `json:"faststart,omitempty"`
+	AudioSettings       *AudioSettings       `json:"audio_settings,omitempty"`
+	DebugLog            string               `json:"debug_log,omitempty"`
+	Timings             *Timings             `json:"timings,omitempty"`
+	StreamNormalization *StreamNormalization `json:"stream_normalization,omitempty"`
+	BitrateFloor        *BitrateFloor        `json:"bitrate_floor,omitempty"`
+	SizeComparison      *SizeComparison      `json:"size_comparison,omitempty"`
+	Subtitles           []SubtitleTrack      `json:"subtitles,omitempty"`
+	ExtractedAudio      *ExtractedAudio      `json:"extracted_audio,omitempty"`
+	Artifacts           []Artifact           `json:"artifacts,omitempty"`
+	Meta                map[string]string    `json:"meta,omitempty"`
+	Warnings            []string             `json:"warnings,omitempty"`
+	Code                string               `json:"code,omitempty"`
+	Message             string               `json:"message"`
+}
+
+// PresignDownloadResponse is returned by POST /presign-download
+type PresignDownloadResponse struct {
+	Key         string `json:"key"`
+	DownloadURL string `json:"download_url"`
+	// Headers the download is served with instead of the stored ones
+	ContentDisposition string    `json:"content_disposition,omitempty"`
+	ContentType        string    `json:"content_type,omitempty"`
+	ExpiresAt          time.Time `json:"expires_at"`
+}
+
+// ReprocessResponse is returned by POST /reprocess
+type ReprocessResponse struct {
+	SourceKey     string         `json:"source_key"`
+	Artifact      Artifact       `json:"artifact"`
+	Overwritten   bool           `json:"overwritten"`
+	Duration      float64        `json:"duration,omitempty"`
+	VideoCodec    string         `json:"video_codec"`
+	Faststart     bool           `json:"faststart"`
+	AudioSettings *AudioSettings `json:"audio_settings,omitempty"`
+	Message       string         `json:"message"`
+}
+
+type PresignResponse struct {
+	Key       string            `json:"key"`
+	FileName  string            `json:"file_name"`
+	UploadURL string            `json:"upload_url"`
+	Method    string            `json:"method"`
+	Headers   map[string]string `json:"headers"`
+	FileURL   string            `json:"file_url"`
+	ExpiresAt time.Time         `json:"expires_at"`
+}
+
+// Job states of asynchronous uploads
+const (
+	JobPending    = "pending"
+	JobProcessing = "processing"
+	JobCompleted  = "completed"
+	JobFailed     = "failed"
+)
+
+type Job struct {
+	ID        string    `json:"id"`
+	Status    string    `json:"status"`
+	Key       string    `json:"key"`
+	FileURL   string    `json:"file_url"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	// Meta is the custom metadata sent with the upload
+	Meta map[string]string `json:"meta,omitempty"`
+	// StatusCode and Result are the response the synchronous upload would have returned
+	StatusCode int             `json:"status_code,omitempty"`
+	Result     *UploadResponse `json:"result,omitempty"`
 }
@@ -0,0 +1,56 @@
+package moderation
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+)
+
+// HTTPModerator asks an external service. The file is POSTed as the request body with
+// its MIME type as Content-Type, the service answers 200 with
+// {"allowed": bool, "reason": "..."}.
+type HTTPModerator struct {
+	url    string
+	client *http.Client
+}
+
+func NewHTTPModerator(url string, timeout time.Duration) *HTTPModerator {
+	return &HTTPModerator{url: url, client: &http.Client{Timeout: timeout}}
+}
+
+type verdict struct {
+	Allowed *bool  `json:"allowed"`
+	Reason  string `json:"reason"`
+}
+
+func (m *HTTPModerator) Moderate(ctx context.Context, data []byte, mimeType string) (bool, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, m.url, bytes.NewReader(data))
+	if err != nil {
+		return false, "", fmt.Errorf("failed to create moderation request: %w", err)
+	}
+	req.Header.Set("Content-Type", mimeType)
+
+	resp, err := m.client.Do(req)
+	if err != nil {
+		return false, "", fmt.Errorf("moderation request failed: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return false, "", fmt.Errorf("moderation service responded with status code %d", resp.StatusCode)
+	}
+
+	var v verdict
+	if err := json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(&v); err != nil {
+		return false, "", fmt.Errorf("failed to parse moderation response: %w", err)
+	}
+	// A response without a verdict must not let files through
+	if v.Allowed == nil {
+		return false, "", fmt.Errorf("moderation response has no allowed field")
+	}
+	return *v.Allowed, v.Reason, nil
+}
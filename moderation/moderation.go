@@ -0,0 +1,28 @@
+package moderation
+
+import (
+	"context"
+
+	"github.com/asset_upload_service/config"
+)
+
+// Moderator decides whether a file may be stored. It is asked before anything is
+// uploaded; reason explains a rejection and is passed on to the client.
+type Moderator interface {
+	Moderate(ctx context.Context, data []byte, mimeType string) (allowed bool, reason string, err error)
+}
+
+// NoOp allows every file, it is used when moderation is disabled
+type NoOp struct{}
+
+func (NoOp) Moderate(ctx context.Context, data []byte, mimeType string) (bool, string, error) {
+	return true, "", nil
+}
+
+// New returns the moderator configured by cfg, NoOp unless a moderation URL is set
+func New(cfg config.ModerationConfig) Moderator {
+	if cfg.URL == "" {
+		return NoOp{}
+	}
+	return NewHTTPModerator(cfg.URL, cfg.Timeout)
+}
@@ -0,0 +1,146 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"math"
+
+	"github.com/disintegration/imaging"
+)
+
+// Avatar sizes in pixels
+const (
+	MinAvatarSize = 16
+	MaxAvatarSize = 2048
+)
+
+// Avatar shapes
+const (
+	AvatarSquare = "square"
+	AvatarCircle = "circle"
+)
+
+// Avatar crops
+const (
+	AvatarCropCenter = "center" // crop the middle of the image
+	AvatarCropSmart  = "smart"  // crop where the image has the most detail
+)
+
+// ValidAvatarShape reports whether shape is a supported avatar shape
+func ValidAvatarShape(shape string) bool {
+	return shape == AvatarSquare || shape == AvatarCircle
+}
+
+// ValidAvatarCrop reports whether crop is a supported avatar crop
+func ValidAvatarCrop(crop string) bool {
+	return crop == AvatarCropCenter || crop == AvatarCropSmart
+}
+
+// smartCropSize is the size the image is scaled to when looking for its subject
+const smartCropSize = 256
+
+// Avatar crops the image to a square of size x size. Square avatars are encoded as JPEG,
+// circles are masked and encoded as PNG so the corners stay transparent. It returns
+// the encoded image and its extension.
+func (r *Resizer) Avatar(buffer []byte, size int, shape, crop string) ([]byte, string, error) {
+	srcImage, err := imaging.Decode(bytes.NewReader(buffer))
+	if err != nil {
+		return nil, "", err
+	}
+
+	var dstImage *image.NRGBA
+	if crop == AvatarCropSmart {
+		dstImage = imaging.Resize(imaging.Crop(srcImage, smartSquare(srcImage)), size, size, imaging.Lanczos)
+	} else {
+		dstImage = imaging.Fill(srcImage, size, size, imaging.Center, imaging.Lanczos)
+	}
+
+	var buf bytes.Buffer
+	if shape == AvatarCircle {
+		if err := imaging.Encode(&buf, circleMask(dstImage), imaging.PNG); err != nil {
+			return nil, "", err
+		}
+		return buf.Bytes(), ".png", nil
+	}
+	if err := imaging.Encode(&buf, dstImage, imaging.JPEG, imaging.JPEGQuality(r.Quality)); err != nil {
+		return nil, "", err
+	}
+	return buf.Bytes(), ".jpg", nil
+}
+
+// smartSquare returns the largest square of img that holds the most edges, which is
+// usually where the subject is. The square slides along the longer side only.
+func smartSquare(img image.Image) image.Rectangle {
+	bounds := img.Bounds()
+	w, h := bounds.Dx(), bounds.Dy()
+	side := min(w, h)
+	if w == h {
+		return bounds
+	}
+
+	// Sum the edge strength of every row or column of a small grayscale copy
+	small := imaging.Grayscale(imaging.Fit(img, smartCropSize, smartCropSize, imaging.Box))
+	sw, sh := small.Bounds().Dx(), small.Bounds().Dy()
+	lum := func(x, y int) float64 {
+		return float64(small.Pix[y*small.Stride+x*4])
+	}
+	horizontal := w > h
+	lines := sh
+	if horizontal {
+		lines = sw
+	}
+	energy := make([]float64, lines)
+	for y := 1; y < sh-1; y++ {
+		for x := 1; x < sw-1; x++ {
+			e := math.Abs(lum(x+1, y)-lum(x-1, y)) + math.Abs(lum(x, y+1)-lum(x, y-1))
+			if horizontal {
+				energy[x] += e
+			} else {
+				energy[y] += e
+			}
+		}
+	}
+
+	// Slide a window as long as the short side over the lines
+	window := min(sw, sh)
+	best, bestSum, sum := 0, 0.0, 0.0
+	for i, e := range energy {
+		sum += e
+		if i >= window {
+			sum -= energy[i-window]
+		}
+		if i >= window-1 && sum > bestSum {
+			best, bestSum = i-window+1, sum
+		}
+	}
+
+	offset := best * max(w, h) / lines
+	offset = min(offset, max(w, h)-side)
+	if horizontal {
+		return image.Rect(bounds.Min.X+offset, bounds.Min.Y, bounds.Min.X+offset+side, bounds.Max.Y)
+	}
+	return image.Rect(bounds.Min.X, bounds.Min.Y+offset, bounds.Max.X, bounds.Min.Y+offset+side)
+}
+
+// circleMask makes everything outside the circle inscribed in img transparent, with a
+// one pixel soft edge against jagged borders
+func circleMask(img *image.NRGBA) *image.NRGBA {
+	w, h := img.Bounds().Dx(), img.Bounds().Dy()
+	out := imaging.New(w, h, color.Transparent)
+	cx, cy := float64(w)/2, float64(h)/2
+	radius := math.Min(cx, cy)
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			dist := math.Hypot(float64(x)+0.5-cx, float64(y)+0.5-cy)
+			coverage := math.Max(0, math.Min(1, radius-dist+0.5))
+			if coverage == 0 {
+				continue
+			}
+			i := y*img.Stride + x*4
+			out.Pix[i], out.Pix[i+1], out.Pix[i+2] = img.Pix[i], img.Pix[i+1], img.Pix[i+2]
+			out.Pix[i+3] = uint8(float64(img.Pix[i+3])*coverage + 0.5)
+		}
+	}
+	return out
+}
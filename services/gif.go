@@ -0,0 +1,173 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/gif"
+	"os/exec"
+	"strconv"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/disintegration/imaging"
+)
+
+// Output formats of resized animated GIFs
+const (
+	AnimatedGIF  = "gif"
+	AnimatedWebP = "webp"
+)
+
+// ValidAnimatedOutputFormat reports whether format is a supported output format for
+// animated GIFs
+func ValidAnimatedOutputFormat(format string) bool {
+	return format == AnimatedGIF || format == AnimatedWebP
+}
+
+// GIFFrameCount returns the number of frames of a GIF by walking its blocks, without
+// decoding any image data. It returns 0 for data that isn't a GIF.
+func GIFFrameCount(data []byte) int {
+	if len(data) < 13 || (string(data[:6]) != "GIF87a" && string(data[:6]) != "GIF89a") {
+		return 0
+	}
+	pos := 13
+	// Global color table
+	if data[10]&0x80 != 0 {
+		pos += 3 << (data[10]&0x07 + 1)
+	}
+
+	// skipSubBlocks returns the position after a chain of data sub-blocks
+	skipSubBlocks := func(pos int) int {
+		for pos < len(data) && data[pos] != 0 {
+			pos += int(data[pos]) + 1
+		}
+		return pos + 1
+	}
+
+	frames := 0
+	for pos < len(data) {
+		switch data[pos] {
+		case 0x2C: // image descriptor
+			if pos+10 > len(data) {
+				return frames
+			}
+			flags := data[pos+9]
+			pos += 10
+			if flags&0x80 != 0 {
+				pos += 3 << (flags&0x07 + 1)
+			}
+			// LZW minimum code size, then the image data
+			pos = skipSubBlocks(pos + 1)
+			frames++
+		case 0x21: // extension
+			pos = skipSubBlocks(pos + 2)
+		default: // trailer or garbage
+			return frames
+		}
+	}
+	return frames
+}
+
+// ResizeAnimatedGIF fits every frame of an animated GIF to the target format like
+// ResizeImage, keeping frame delays and the loop count. Frames are composited first,
+// so partial frames and disposal methods come out right. The result is an animated GIF,
+// or an animated WebP (encoded by ffmpeg) when output is AnimatedWebP.
+func (r *Resizer) ResizeAnimatedGIF(buffer []byte, formatName string, opts ResizeOptions, output string) ([]byte, error) {
+	targetFormat, ok := FindFormat(formatName)
+	if !ok {
+		return nil, fmt.Errorf("invalid format name: %s", formatName)
+	}
+
+	src, err := gif.DecodeAll(bytes.NewReader(buffer))
+	if err != nil {
+		return nil, err
+	}
+	if len(src.Image) == 0 {
+		return nil, fmt.Errorf("GIF has no frames")
+	}
+
+	canvasBounds := image.Rect(0, 0, src.Config.Width, src.Config.Height)
+	if canvasBounds.Empty() {
+		canvasBounds = src.Image[0].Bounds()
+	}
+	width, height, _ := OutputSize(targetFormat, canvasBounds.Dx(), canvasBounds.Dy(), opts.Fit, opts.NoUpscale)
+
+	dst := &gif.GIF{
+		Delay:     src.Delay,
+		LoopCount: src.LoopCount,
+		Config:    image.Config{Width: width, Height: height},
+	}
+	canvas := image.NewNRGBA(canvasBounds)
+	for i, frame := range src.Image {
+		// Keep what DisposalPrevious restores to
+		var previous *image.NRGBA
+		if i < len(src.Disposal) && src.Disposal[i] == gif.DisposalPrevious {
+			previous = imaging.Clone(canvas)
+		}
+		draw.Draw(canvas, frame.Bounds(), frame, frame.Bounds().Min, draw.Over)
+
+		resized := fitImage(canvas, width, height, opts)
+		palette := frame.Palette
+		if len(palette) == 0 {
+			palette = src.Image[0].Palette
+		}
+		out := image.NewPaletted(image.Rect(0, 0, width, height), palette)
+		draw.FloydSteinberg.Draw(out, out.Bounds(), resized, image.Point{})
+		dst.Image = append(dst.Image, out)
+		// Every output frame is complete, clearing keeps transparent areas transparent
+		dst.Disposal = append(dst.Disposal, gif.DisposalBackground)
+
+		if i < len(src.Disposal) {
+			switch src.Disposal[i] {
+			case gif.DisposalBackground:
+				draw.Draw(canvas, frame.Bounds(), image.NewUniform(color.Transparent), image.Point{}, draw.Src)
+			case gif.DisposalPrevious:
+				canvas = previous
+			}
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := gif.EncodeAll(&buf, dst); err != nil {
+		return nil, err
+	}
+	if output == AnimatedWebP {
+		return encodeAnimatedWebP(buf.Bytes(), src.LoopCount, r.WebPQuality)
+	}
+	return buf.Bytes(), nil
+}
+
+// encodeAnimatedWebP converts an animated GIF to an animated WebP with ffmpeg's libwebp
+// encoder, keeping each frame's timing
+func encodeAnimatedWebP(gifData []byte, gifLoopCount, quality int) ([]byte, error) {
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg is not installed: %w", err)
+	}
+
+	// GIF counts repeats (-1 plays once), WebP counts plays; both use 0 for forever
+	loop := 0
+	if gifLoopCount != 0 {
+		loop = max(gifLoopCount, 0) + 1
+	}
+
+	cmd, done := mediaexec.Command(context.Background(), ffmpegPath,
+		"-f", "gif", "-i", "pipe:0",
+		"-fps_mode", "passthrough",
+		"-c:v", "libwebp", "-quality", strconv.Itoa(quality),
+		"-loop", strconv.Itoa(loop),
+		"-f", "webp", "pipe:1",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = bytes.NewReader(gifData)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := done(cmd.Run()); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed to encode WebP: %w, stderr: %s", err, stderr.String())
+	}
+	return stdout.Bytes(), nil
+}
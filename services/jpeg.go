@@ -0,0 +1,79 @@
+package services
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"image"
+	"image/png"
+	"math"
+	"os/exec"
+
+	"github.com/asset_upload_service/mediaexec"
+)
+
+// Chroma subsampling ratios supported for JPEG output
+const (
+	Subsampling444 = "444"
+	Subsampling422 = "422"
+	Subsampling420 = "420"
+)
+
+// ValidChromaSubsampling reports whether ratio is a supported subsampling ratio
+func ValidChromaSubsampling(ratio string) bool {
+	return ratio == Subsampling444 || ratio == Subsampling422 || ratio == Subsampling420
+}
+
+// encodeJPEGSubsampled encodes img as JPEG with an explicit chroma subsampling ratio.
+// Go's image/jpeg (used by imaging) always writes 4:2:0 and doesn't expose the
+// ratio, so 4:4:4 and 4:2:2 are encoded by ffmpeg's mjpeg encoder instead.
+func encodeJPEGSubsampled(img image.Image, quality int, ratio string) ([]byte, error) {
+	pixFmt := map[string]string{
+		Subsampling444: "yuvj444p",
+		Subsampling422: "yuvj422p",
+		Subsampling420: "yuvj420p",
+	}[ratio]
+	if pixFmt == "" {
+		return nil, fmt.Errorf("unsupported chroma subsampling: %s", ratio)
+	}
+
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return nil, fmt.Errorf("ffmpeg is not installed: %w", err)
+	}
+
+	// Hand the image over losslessly
+	var input bytes.Buffer
+	if err := png.Encode(&input, img); err != nil {
+		return nil, fmt.Errorf("failed to encode intermediate image: %w", err)
+	}
+
+	cmd, done := mediaexec.Command(context.Background(), ffmpegPath,
+		"-f", "image2pipe", "-c:v", "png", "-i", "pipe:0",
+		"-pix_fmt", pixFmt,
+		"-q:v", fmt.Sprintf("%d", jpegQualityToQScale(quality)),
+		"-frames:v", "1",
+		"-f", "mjpeg", "pipe:1",
+	)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdin = &input
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+
+	if err := done(cmd.Run()); err != nil {
+		return nil, fmt.Errorf("ffmpeg failed to encode JPEG: %w, stderr: %s", err, stderr.String())
+	}
+
+	return stdout.Bytes(), nil
+}
+
+// jpegQualityToQScale maps a 1-100 JPEG quality to ffmpeg's 2-31 qscale (lower is better)
+func jpegQualityToQScale(quality int) int {
+	if quality < 1 {
+		quality = 1
+	}
+	if quality > 100 {
+		quality = 100
+	}
+	return 2 + int(math.Round(float64(100-quality)*29/99))
+}
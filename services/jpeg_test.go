@@ -0,0 +1,72 @@
+package services
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os/exec"
+	"testing"
+)
+
+// testJPEG encodes a colorful gradient, whose chroma differs between neighbouring pixels
+func testJPEG(t *testing.T) []byte {
+	t.Helper()
+	img := image.NewRGBA(image.Rect(0, 0, 64, 64))
+	for y := 0; y < 64; y++ {
+		for x := 0; x < 64; x++ {
+			img.Set(x, y, color.RGBA{R: uint8(x * 4), G: uint8(y * 4), B: uint8((x + y) * 2), A: 255})
+		}
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, img, &jpeg.Options{Quality: 90}); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestResizeImageChromaSubsampling(t *testing.T) {
+	tests := []struct {
+		ratio string
+		want  image.YCbCrSubsampleRatio
+	}{
+		{"", image.YCbCrSubsampleRatio420},
+		{Subsampling420, image.YCbCrSubsampleRatio420},
+		{Subsampling422, image.YCbCrSubsampleRatio422},
+		{Subsampling444, image.YCbCrSubsampleRatio444},
+	}
+	source := testJPEG(t)
+	for _, tt := range tests {
+		t.Run("ratio "+tt.ratio, func(t *testing.T) {
+			if tt.ratio == Subsampling422 || tt.ratio == Subsampling444 {
+				if _, err := exec.LookPath("ffmpeg"); err != nil {
+					t.Skip("ffmpeg is not installed")
+				}
+			}
+			out, err := NewResizer(DefaultJPEGQuality).ResizeImage(source, "1:1", ResizeOptions{ChromaSubsampling: tt.ratio})
+			if err != nil {
+				t.Fatalf("ResizeImage failed: %v", err)
+			}
+			img, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("output is not a JPEG: %v", err)
+			}
+			ycbcr, ok := img.(*image.YCbCr)
+			if !ok {
+				t.Fatalf("output decodes as %T, want *image.YCbCr", img)
+			}
+			if ycbcr.SubsampleRatio != tt.want {
+				t.Errorf("subsampling = %v, want %v", ycbcr.SubsampleRatio, tt.want)
+			}
+			if b := img.Bounds(); b.Dx() != 1080 || b.Dy() != 1080 {
+				t.Errorf("output is %dx%d, want 1080x1080", b.Dx(), b.Dy())
+			}
+		})
+	}
+}
+
+func TestEncodeJPEGSubsampledRejectsUnknownRatio(t *testing.T) {
+	if _, err := encodeJPEGSubsampled(image.NewRGBA(image.Rect(0, 0, 8, 8)), 90, "411"); err == nil {
+		t.Error("encodeJPEGSubsampled accepted the unsupported ratio 411")
+	}
+}
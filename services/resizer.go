@@ -3,7 +3,12 @@ package services
 import (
 	"bytes"
 	"fmt"
+	"image"
+	"image/color"
 	"math"
+	"sort"
+	"strconv"
+	"strings"
 	"sync"
 
 	"github.com/disintegration/imaging"
@@ -31,15 +36,69 @@ func GetFormats() []MediaFormat {
 	return formats
 }
 
+// Default encoder qualities (1-100); WebP looks about as good as JPEG at lower values
+const (
+	DefaultJPEGQuality = 90
+	DefaultWebPQuality = 80
+)
+
 type Resizer struct {
-	Quality int
+	Quality     int // JPEG quality
+	WebPQuality int
 }
 
 func NewResizer(quality int) *Resizer {
-	return &Resizer{Quality: quality}
+	return &Resizer{Quality: quality, WebPQuality: DefaultWebPQuality}
+}
+
+// Encoder qualities of DefaultResizer, see SetDefaultQualities
+var (
+	defaultJPEGQuality = DefaultJPEGQuality
+	defaultWebPQuality = DefaultWebPQuality
+)
+
+// SetDefaultQualities sets the JPEG and WebP qualities of DefaultResizer. It is meant
+// to be called once at startup from the loaded configuration.
+func SetDefaultQualities(jpegQuality, webpQuality int) {
+	if jpegQuality > 0 {
+		defaultJPEGQuality = jpegQuality
+	}
+	if webpQuality > 0 {
+		defaultWebPQuality = webpQuality
+	}
 }
 
+// DefaultResizer returns a Resizer encoding at the configured default qualities
+func DefaultResizer() *Resizer {
+	return &Resizer{Quality: defaultJPEGQuality, WebPQuality: defaultWebPQuality}
+}
+
+// DetectFormat returns the formatted ratio of the format closest to width x height
 func (r *Resizer) DetectFormat(width, height int) string {
+	return r.MatchFormat(width, height).FormattedRatio
+}
+
+// NoMatchingFormat is the name and formatted ratio MatchFormat reports for sizes too far
+// from every supported format
+const NoMatchingFormat = "no_matching_format"
+
+// formatMatchTolerance is the largest relative difference between the aspect ratios of
+// a file and the format it matches, 0 matches any file, see SetFormatMatchTolerance
+var formatMatchTolerance = 0.0
+
+// SetFormatMatchTolerance sets the tolerance used by MatchFormat. It is meant to be
+// called once at startup from the loaded configuration.
+func SetFormatMatchTolerance(tolerance float64) {
+	if tolerance >= 0 {
+		formatMatchTolerance = tolerance
+	}
+}
+
+// MatchFormat returns the supported format whose aspect ratio is closest to width x height.
+// With a format match tolerance, sizes whose ratio differs from the closest one by more
+// than that, relative to the format's ratio (e.g. 5:1 banners), match no format: the
+// result only has Name and FormattedRatio set to NoMatchingFormat.
+func (r *Resizer) MatchFormat(width, height int) MediaFormat {
 	originalRatio := float64(width) / float64(height)
 
 	var closestFormat MediaFormat
@@ -53,18 +112,162 @@ func (r *Resizer) DetectFormat(width, height int) string {
 		}
 	}
 
-	return closestFormat.FormattedRatio
+	if formatMatchTolerance > 0 && minDiff/closestFormat.AspectRatio > formatMatchTolerance {
+		return MediaFormat{Name: NoMatchingFormat, FormattedRatio: NoMatchingFormat}
+	}
+	return closestFormat
+}
+
+// RankedFormat is a supported format with the distance of its aspect ratio to a file's
+type RankedFormat struct {
+	MediaFormat
+	Difference float64
+}
+
+// RankFormats returns all supported formats ordered by how close their aspect ratio is to
+// width x height, closest first. The first one is the format MatchFormat picks, unless
+// it is outside the format match tolerance.
+func (r *Resizer) RankFormats(width, height int) []RankedFormat {
+	originalRatio := float64(width) / float64(height)
+
+	ranked := make([]RankedFormat, len(formats))
+	for i, format := range formats {
+		ranked[i] = RankedFormat{MediaFormat: format, Difference: math.Abs(originalRatio - format.AspectRatio)}
+	}
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return ranked[i].Difference < ranked[j].Difference
+	})
+	return ranked
 }
 
-func (r *Resizer) ResizeImage(buffer []byte, formatName string) ([]byte, error) {
-	var targetFormat MediaFormat
+// FindFormat looks up a supported media format by its formatted ratio (e.g. "4:5")
+func FindFormat(formatName string) (MediaFormat, bool) {
 	for _, f := range formats {
 		if f.FormattedRatio == formatName {
-			targetFormat = f
-			break
+			return f, true
 		}
 	}
-	if targetFormat.FormattedRatio == "" {
+	return MediaFormat{}, false
+}
+
+// Fit modes used when the source doesn't match the target format's aspect ratio
+const (
+	FitCover   = "cover"   // resize and crop to fill the target
+	FitContain = "contain" // resize to fit inside the target and pad the rest
+)
+
+// ValidFitMode reports whether mode is a supported fit mode
+func ValidFitMode(mode string) bool {
+	return mode == FitCover || mode == FitContain
+}
+
+// ResizeOptions controls how an image is fitted to its target format
+type ResizeOptions struct {
+	Fit               string
+	Background        color.Color
+	ChromaSubsampling string  // "444", "422" or "420"; empty keeps the default encoder
+	NoUpscale         bool    // shrink the output instead of enlarging small sources
+	Denoise           float64 // gaussian blur sigma applied after resizing, 0 disables
+	Sharpen           float64 // unsharp mask sigma applied after resizing, 0 disables
+}
+
+// Default and maximum strengths (sigma) of the post-resize filters
+const (
+	DefaultSharpen = 1.0
+	MaxSharpen     = 5.0
+	DefaultDenoise = 0.5
+	MaxDenoise     = 3.0
+)
+
+// Filters returns the names of the post-resize filters opts applies, in order
+func (opts ResizeOptions) Filters() []string {
+	var filters []string
+	if opts.Denoise > 0 {
+		filters = append(filters, "denoise")
+	}
+	if opts.Sharpen > 0 {
+		filters = append(filters, "sharpen")
+	}
+	return filters
+}
+
+// OutputSize returns the dimensions a srcWidth x srcHeight image is resized to for
+// target. With noUpscale the target is scaled down proportionally when filling it
+// would enlarge the source, capped reports whether that happened.
+func OutputSize(target MediaFormat, srcWidth, srcHeight int, fit string, noUpscale bool) (width, height int, capped bool) {
+	if !noUpscale || srcWidth <= 0 || srcHeight <= 0 {
+		return target.Width, target.Height, false
+	}
+
+	scaleX := float64(srcWidth) / float64(target.Width)
+	scaleY := float64(srcHeight) / float64(target.Height)
+	// cover needs the source to span the target in both directions, contain in one
+	scale := math.Min(scaleX, scaleY)
+	if fit == FitContain {
+		scale = math.Max(scaleX, scaleY)
+	}
+	if scale >= 1 {
+		return target.Width, target.Height, false
+	}
+
+	width = max(1, int(math.Round(float64(target.Width)*scale)))
+	height = max(1, int(math.Round(float64(target.Height)*scale)))
+	return width, height, true
+}
+
+// ParseHexColor parses colors in the "#rrggbb" or "rrggbb" form
+func ParseHexColor(s string) (color.NRGBA, error) {
+	s = strings.TrimPrefix(s, "#")
+	if len(s) != 6 {
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	v, err := strconv.ParseUint(s, 16, 32)
+	if err != nil {
+		return color.NRGBA{}, fmt.Errorf("invalid color: %s", s)
+	}
+	return color.NRGBA{R: uint8(v >> 16), G: uint8(v >> 8), B: uint8(v), A: 255}, nil
+}
+
+// VideoFitFilter returns the ffmpeg filter chain that fits a video into the target format
+func VideoFitFilter(target MediaFormat, fit string, background color.NRGBA) string {
+	if fit == FitContain {
+		return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=decrease,pad=%d:%d:(ow-iw)/2:(oh-ih)/2:color=0x%02x%02x%02x",
+			target.Width, target.Height, target.Width, target.Height, background.R, background.G, background.B)
+	}
+	return fmt.Sprintf("scale=%d:%d:force_original_aspect_ratio=increase,crop=%d:%d",
+		target.Width, target.Height, target.Width, target.Height)
+}
+
+// fitImage fits img to width x height with the fit mode and filters of opts
+func fitImage(img image.Image, width, height int, opts ResizeOptions) image.Image {
+	var dstImage image.Image
+	if opts.Fit == FitContain {
+		// Letterbox: fit inside the target and pad to the exact size
+		bg := opts.Background
+		if bg == nil {
+			bg = color.White
+		}
+		fitted := imaging.Fit(img, width, height, imaging.Lanczos)
+		canvas := imaging.New(width, height, bg)
+		dstImage = imaging.PasteCenter(canvas, fitted)
+	} else {
+		// Resize and crop
+		dstImage = imaging.Fill(img, width, height, imaging.Center, imaging.Lanczos)
+	}
+
+	// Denoise before sharpening so the noise isn't amplified
+	if opts.Denoise > 0 {
+		dstImage = imaging.Blur(dstImage, opts.Denoise)
+	}
+	if opts.Sharpen > 0 {
+		dstImage = imaging.Sharpen(dstImage, opts.Sharpen)
+	}
+	return dstImage
+}
+
+func (r *Resizer) ResizeImage(buffer []byte, formatName string, opts ResizeOptions) ([]byte, error) {
+	targetFormat, ok := FindFormat(formatName)
+	if !ok {
 		return nil, fmt.Errorf("invalid format name: %s", formatName)
 	}
 
@@ -74,8 +277,14 @@ func (r *Resizer) ResizeImage(buffer []byte, formatName string) ([]byte, error)
 		return nil, err
 	}
 
-	// Resize and crop
-	dstImage := imaging.Resize(srcImage, targetFormat.Width, targetFormat.Height, imaging.Lanczos)
+	width, height, _ := OutputSize(targetFormat, srcImage.Bounds().Dx(), srcImage.Bounds().Dy(), opts.Fit, opts.NoUpscale)
+
+	dstImage := fitImage(srcImage, width, height, opts)
+
+	// 4:2:0 is what the default encoder produces, other ratios need ffmpeg
+	if opts.ChromaSubsampling != "" && opts.ChromaSubsampling != Subsampling420 {
+		return encodeJPEGSubsampled(dstImage, r.Quality, opts.ChromaSubsampling)
+	}
 
 	// Encode to JPEG with quality
 	var buf bytes.Buffer
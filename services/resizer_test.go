@@ -0,0 +1,18 @@
+package services
+
+import "testing"
+
+func TestDefaultResizer(t *testing.T) {
+	defer SetDefaultQualities(DefaultJPEGQuality, DefaultWebPQuality)
+
+	r := DefaultResizer()
+	if r.Quality != DefaultJPEGQuality || r.WebPQuality != DefaultWebPQuality {
+		t.Errorf("DefaultResizer() qualities = %d/%d, want %d/%d", r.Quality, r.WebPQuality, DefaultJPEGQuality, DefaultWebPQuality)
+	}
+
+	SetDefaultQualities(75, 60)
+	r = DefaultResizer()
+	if r.Quality != 75 || r.WebPQuality != 60 {
+		t.Errorf("DefaultResizer() qualities = %d/%d after SetDefaultQualities(75, 60)", r.Quality, r.WebPQuality)
+	}
+}
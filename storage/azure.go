@@ -0,0 +1,158 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/blob"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/bloberror"
+	"github.com/Azure/azure-sdk-for-go/sdk/storage/azblob/container"
+	"github.com/asset_upload_service/config"
+	"github.com/sirupsen/logrus"
+)
+
+// AzureStorage stores objects as block blobs in an Azure Blob Storage container
+type AzureStorage struct {
+	client    *azblob.Client
+	container string
+	verify    bool
+}
+
+// NewAzureStorage returns an Azure Blob Storage backend authenticated with the
+// account's shared key. With PublicAccess set the container is switched to anonymous
+// read access for blobs, the equivalent of the public-read ACL used on S3. With verify
+// set the properties of every uploaded blob are fetched to confirm it exists.
+func NewAzureStorage(cfg config.AzureConfig, verify bool) (*AzureStorage, error) {
+	cred, err := azblob.NewSharedKeyCredential(cfg.AccountName, cfg.AccountKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid Azure storage credentials: %w", err)
+	}
+
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = fmt.Sprintf("https://%s.blob.core.windows.net/", cfg.AccountName)
+	}
+	client, err := azblob.NewClientWithSharedKeyCredential(endpoint, cred, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create Azure blob client: %w", err)
+	}
+
+	if cfg.PublicAccess {
+		access := container.PublicAccessTypeBlob
+		containerClient := client.ServiceClient().NewContainerClient(cfg.Container)
+		if _, err := containerClient.SetAccessPolicy(context.Background(), &container.SetAccessPolicyOptions{Access: &access}); err != nil {
+			// Accounts can forbid anonymous access, uploads still work in that case
+			logrus.Warnf("Failed to enable public blob access on container %s: %v", cfg.Container, err)
+		}
+	}
+
+	return &AzureStorage{client: client, container: cfg.Container, verify: verify}, nil
+}
+
+// Upload stores file as a block blob named key. Every block is sent with a CRC64 the
+// service verifies.
+func (s *AzureStorage) Upload(file *os.File, key string, opts UploadOptions) (*UploadResult, error) {
+	var err error
+	contentType := opts.ContentType
+	if contentType == "" {
+		if contentType, err = detectContentType(file, key); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := &blob.HTTPHeaders{BlobContentType: &contentType}
+	if opts.ContentDisposition != "" {
+		headers.BlobContentDisposition = &opts.ContentDisposition
+	}
+
+	// Metadata names must be C# identifiers on Azure, so dashes become underscores
+	meta := opts.metadata()
+	var metadata map[string]*string
+	for k, v := range meta {
+		if metadata == nil {
+			metadata = make(map[string]*string)
+		}
+		metadata[strings.ReplaceAll(k, "-", "_")] = &v
+	}
+
+	logrus.Infof("Starting Azure upload for blob: %s", key)
+	_, err = s.client.UploadFile(context.Background(), s.container, key, file, &azblob.UploadFileOptions{
+		BlockSize:               10 * 1024 * 1024, // 10MB, same as the S3 part size
+		Concurrency:             5,
+		HTTPHeaders:             headers,
+		Metadata:                metadata,
+		Tags:                    opts.tags(),
+		TransactionalValidation: blob.TransferValidationTypeComputeCRC64(),
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload blob: %w", err)
+	}
+
+	blobClient := s.client.ServiceClient().NewContainerClient(s.container).NewBlockBlobClient(key)
+	blobURL := blobClient.URL()
+	logrus.Infof("Successfully uploaded blob to Azure: %s", blobURL)
+
+	var verifiedSize int64
+	if s.verify {
+		props, err := blobClient.GetProperties(context.Background(), nil)
+		if err != nil {
+			logrus.Errorf("Upload verification of %s failed: %v", key, err)
+			return nil, fmt.Errorf("upload verification failed: %w", err)
+		}
+		if props.ContentLength != nil {
+			verifiedSize = *props.ContentLength
+		}
+		if err := verifySize(file, key, verifiedSize); err != nil {
+			logrus.Error(err)
+			return nil, err
+		}
+		if err := verifyMetadata(key, meta, props.Metadata); err != nil {
+			logrus.Error(err)
+			return nil, err
+		}
+	}
+	return &UploadResult{URL: blobURL, VerifiedSize: verifiedSize}, nil
+}
+
+// Download writes the blob named key to file
+func (s *AzureStorage) Download(key string, file *os.File) (string, error) {
+	logrus.Infof("Starting Azure download for blob: %s", key)
+	_, err := s.client.DownloadFile(context.Background(), s.container, key, file, &azblob.DownloadFileOptions{
+		BlockSize:   10 * 1024 * 1024,
+		Concurrency: 5,
+	})
+	if bloberror.HasCode(err, bloberror.BlobNotFound) {
+		return "", ErrNotFound
+	}
+	if err != nil {
+		return "", fmt.Errorf("failed to download blob: %w", err)
+	}
+	return s.ObjectURL(key)
+}
+
+// ObjectURL returns the URL of the blob named key
+func (s *AzureStorage) ObjectURL(key string) (string, error) {
+	return s.client.ServiceClient().NewContainerClient(s.container).NewBlockBlobClient(key).URL(), nil
+}
+
+// detectContentType guesses the content type from the key's extension and falls back to
+// sniffing the start of the file. The file is rewound afterwards.
+func detectContentType(file *os.File, key string) (string, error) {
+	if contentType := mime.TypeByExtension(filepath.Ext(key)); contentType != "" {
+		return contentType, nil
+	}
+
+	head := make([]byte, 512)
+	n, err := file.ReadAt(head, 0)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("failed to read file: %w", err)
+	}
+	return http.DetectContentType(head[:n]), nil
+}
@@ -0,0 +1,525 @@
+package storage
+
+import (
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/asset_upload_service/config"
+	"github.com/asset_upload_service/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/credentials"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+	"github.com/sirupsen/logrus"
+)
+
+// S3Storage stores objects in an S3 bucket
+type S3Storage struct {
+	cfg               config.AWSConfig
+	checksumAlgorithm string
+	verify            bool
+
+	// The session, and with it the connection pool, is shared by all requests
+	mu   sync.Mutex
+	sess *session.Session
+}
+
+// NewS3Storage returns an S3 backend. checksumAlgorithm selects the checksum S3
+// verifies uploads against, "none" disables it. With verify set every upload is
+// followed by a HeadObject request confirming the object exists.
+func NewS3Storage(cfg config.AWSConfig, checksumAlgorithm string, verify bool) *S3Storage {
+	return &S3Storage{cfg: cfg, checksumAlgorithm: checksumAlgorithm, verify: verify}
+}
+
+// session returns the AWS session, created with a production-ready HTTP client on
+// first use. Its transport bounds the connections to S3 across all requests.
+func (s *S3Storage) session() (*session.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.sess != nil {
+		return s.sess, nil
+	}
+
+	// Create a production-ready HTTP client with robust TLS configuration
+	var rootCAs *x509.CertPool
+
+	// Try to load system root CAs, with fallback for Docker environments
+	if systemRoots, err := x509.SystemCertPool(); err != nil {
+		logrus.Warnf("Failed to load system cert pool, using default: %v", err)
+		rootCAs = nil // Use Go's built-in root CAs as fallback
+	} else {
+		rootCAs = systemRoots
+	}
+
+	// Additional certificate handling for Docker/production environments
+	if certFile := s.cfg.CACertFile; certFile != "" {
+		if certData, err := os.ReadFile(certFile); err == nil {
+			if rootCAs == nil {
+				rootCAs = x509.NewCertPool()
+			}
+			rootCAs.AppendCertsFromPEM(certData)
+			logrus.Infof("Loaded additional certificates from %s", certFile)
+		} else {
+			logrus.Warnf("Failed to load certificate file %s: %v", certFile, err)
+		}
+	}
+
+	// Check for common certificate bundle locations in Docker containers
+	certPaths := []string{
+		"/etc/ssl/certs/ca-certificates.crt", // Debian/Ubuntu
+		"/etc/pki/tls/certs/ca-bundle.crt",   // RHEL/CentOS
+		"/etc/ssl/ca-bundle.pem",             // OpenSUSE
+	}
+
+	for _, certPath := range certPaths {
+		if _, err := os.Stat(certPath); err == nil {
+			if certData, err := os.ReadFile(certPath); err == nil {
+				if rootCAs == nil {
+					rootCAs = x509.NewCertPool()
+				}
+				rootCAs.AppendCertsFromPEM(certData)
+				logrus.Infof("Loaded certificates from %s", certPath)
+				break
+			}
+		}
+	}
+
+	httpClient := &http.Client{
+		Transport: &http.Transport{
+			TLSClientConfig: &tls.Config{
+				InsecureSkipVerify: false,
+				RootCAs:            rootCAs,
+				ServerName:         "", // Let Go handle server name verification
+				MinVersion:         tls.VersionTLS12,
+				MaxVersion:         tls.VersionTLS13,
+				// Additional settings for production environments
+				CipherSuites: []uint16{
+					tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+					tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+					tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+				},
+			},
+			// Optimized transport settings for production. Requests wait for a
+			// connection once MaxConns are in use.
+			DisableKeepAlives:     false,
+			IdleConnTimeout:       s.cfg.IdleConnTimeout,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   s.cfg.MaxIdleConns,
+			MaxConnsPerHost:       s.cfg.MaxConns,
+		},
+	}
+
+	// Create AWS session with custom HTTP client
+	sess, err := session.NewSession(&aws.Config{
+		Region: aws.String(s.cfg.Region),
+		Credentials: credentials.NewStaticCredentials(
+			s.cfg.AccessKeyID,
+			s.cfg.SecretAccessKey,
+			"",
+		),
+		HTTPClient: httpClient,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to create AWS session: %v", err)
+	}
+	s.sess = sess
+	return sess, nil
+}
+
+// Upload stores file in the bucket under key, mirroring it to the backup bucket when
+// one is configured
+func (s *S3Storage) Upload(file *os.File, key string, opts UploadOptions) (*UploadResult, error) {
+	sess, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+
+	// Create an uploader with optimized settings for better performance
+	uploader := s3manager.NewUploader(sess, func(u *s3manager.Uploader) {
+		// Increase part size to 10MB for better performance with larger files
+		u.PartSize = 10 * 1024 * 1024 // 10MB
+		// Increase concurrency for faster uploads
+		u.Concurrency = 5
+	})
+
+	logrus.Infof("Starting S3 upload for file: %s", key)
+
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+		Body:   file,
+		ACL:    aws.String("public-read"), // Set ACL to public-read if needed
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	applyTags(input, opts)
+	if meta := opts.metadata(); meta != nil {
+		input.Metadata = aws.StringMap(meta)
+	}
+
+	// Let S3 verify the transfer so corrupted uploads are rejected. Single part uploads
+	// send the checksum up front, so it is computed before uploading unless the caller
+	// already hashed the file; multipart uploads get per-part checksums from the SDK and
+	// the whole file is hashed while it streams. S3 can't verify MD5 on multipart
+	// uploads, so none is reported for them.
+	var checksum string
+	var streamed hash.Hash
+	alg := s.checksumAlgorithm
+	if alg != "" && alg != utils.ChecksumNone {
+		info, err := file.Stat()
+		if err != nil {
+			return nil, fmt.Errorf("failed to stat file: %v", err)
+		}
+		singlePart := info.Size() <= uploader.PartSize
+		switch {
+		case alg == utils.ChecksumSHA256 && len(opts.SHA256) == sha256.Size:
+			checksum = base64.StdEncoding.EncodeToString(opts.SHA256)
+		case singlePart:
+			if checksum, err = utils.ComputeChecksum(file, alg); err != nil {
+				return nil, fmt.Errorf("failed to compute %s checksum: %v", alg, err)
+			}
+		case alg == utils.ChecksumMD5:
+			// Parts are checked with CRC32, the MD5 of the whole object would be unverified
+		default:
+			if streamed, err = utils.NewChecksumHash(alg); err != nil {
+				return nil, err
+			}
+			// The uploader buffers parts of plain readers instead of reading the file
+			// concurrently
+			input.Body = io.TeeReader(file, streamed)
+		}
+		applyChecksum(input, alg, checksum, singlePart)
+	}
+
+	// Upload the file to S3 with optimized settings, moving on to the fallback bucket
+	// when the primary one can't be reached
+	result, err := uploader.Upload(input)
+	bucket, client, fallback := s.cfg.Bucket, s3.New(sess), false
+	if err != nil && s.cfg.FallbackBucket != "" && unavailable(err) {
+		logrus.Warnf("Upload of %s to %s failed, trying fallback bucket %s: %v", key, s.cfg.Bucket, s.cfg.FallbackBucket, err)
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to rewind file for fallback upload: %w", err)
+		}
+		input.Body = file
+		if streamed != nil {
+			streamed.Reset()
+			input.Body = io.TeeReader(file, streamed)
+		}
+		result, client, err = s.uploadToFallback(sess, uploader, input)
+		bucket, fallback = s.cfg.FallbackBucket, true
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to upload file: %v", err)
+	}
+	if streamed != nil {
+		checksum = base64.StdEncoding.EncodeToString(streamed.Sum(nil))
+	}
+
+	logrus.Infof("Successfully uploaded file to S3: %s", result.Location)
+
+	// Success doesn't guarantee the object is readable, e.g. with restrictive bucket policies
+	var verifiedSize int64
+	if s.verify {
+		head, err := client.HeadObject(&s3.HeadObjectInput{
+			Bucket: aws.String(bucket),
+			Key:    aws.String(key),
+		})
+		if err != nil {
+			logrus.Errorf("Upload verification of %s failed: %v", key, err)
+			return nil, fmt.Errorf("upload verification failed: %v", err)
+		}
+		verifiedSize = aws.Int64Value(head.ContentLength)
+		if err := verifySize(file, key, verifiedSize); err != nil {
+			logrus.Error(err)
+			return nil, err
+		}
+		if err := verifyMetadata(key, aws.StringValueMap(input.Metadata), head.Metadata); err != nil {
+			logrus.Error(err)
+			return nil, err
+		}
+	}
+
+	// Mirror the object to the backup bucket; failures only get logged. Copies are
+	// made from the primary bucket, which doesn't hold fallback uploads.
+	if s.cfg.BackupBucket != "" && !fallback {
+		if err := s.mirrorToBackup(sess, uploader, file, key, opts); err != nil {
+			logrus.Errorf("Failed to mirror %s to backup bucket %s: %v", key, s.cfg.BackupBucket, err)
+		}
+	}
+
+	res := &UploadResult{URL: result.Location, Checksum: checksum, VerifiedSize: verifiedSize, Fallback: fallback}
+	if checksum != "" {
+		res.ChecksumAlgorithm = s.checksumAlgorithm
+	}
+	return res, nil
+}
+
+// Download writes the object stored under key to file
+func (s *S3Storage) Download(key string, file *os.File) (string, error) {
+	sess, err := s.session()
+	if err != nil {
+		return "", err
+	}
+
+	logrus.Infof("Starting S3 download for file: %s", key)
+	downloader := s3manager.NewDownloader(sess, func(d *s3manager.Downloader) {
+		d.PartSize = 10 * 1024 * 1024 // 10MB, same as uploads
+		d.Concurrency = 5
+	})
+	req := &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	}
+	if _, err := downloader.Download(file, req); err != nil {
+		var aerr awserr.Error
+		if errors.As(err, &aerr) && (aerr.Code() == s3.ErrCodeNoSuchKey || aerr.Code() == "NotFound") {
+			return "", ErrNotFound
+		}
+		return "", fmt.Errorf("failed to download file: %v", err)
+	}
+
+	return s.ObjectURL(key)
+}
+
+// ObjectURL returns the URL of the object under key, built the same way the SDK
+// addresses the bucket
+func (s *S3Storage) ObjectURL(key string) (string, error) {
+	sess, err := s.session()
+	if err != nil {
+		return "", err
+	}
+	getReq, _ := s3.New(sess).GetObjectRequest(&s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	})
+	if err := getReq.Build(); err != nil {
+		return "", fmt.Errorf("failed to build object URL: %v", err)
+	}
+	objectURL := *getReq.HTTPRequest.URL
+	objectURL.RawQuery = ""
+	return objectURL.String(), nil
+}
+
+// unavailable reports whether an upload failed because S3 couldn't be reached, was
+// throttling or answered with a server error, even after the SDK's retries
+func unavailable(err error) bool {
+	for err != nil {
+		if request.IsErrorRetryable(err) || request.IsErrorThrottle(err) {
+			return true
+		}
+		if reqErr, ok := err.(awserr.RequestFailure); ok && reqErr.StatusCode() >= http.StatusInternalServerError {
+			return true
+		}
+		// Multipart failures wrap the error of the failed part
+		aerr, ok := err.(awserr.Error)
+		if !ok {
+			return false
+		}
+		err = aerr.OrigErr()
+	}
+	return false
+}
+
+// uploadToFallback repeats a failed upload in the fallback bucket and returns the
+// client for its region. The input's body must be rewound.
+func (s *S3Storage) uploadToFallback(sess *session.Session, uploader *s3manager.Uploader, input *s3manager.UploadInput) (*s3manager.UploadOutput, *s3.S3, error) {
+	region := s.cfg.FallbackRegion
+	if region == "" {
+		region = s.cfg.Region
+	}
+
+	client := s3.New(sess, &aws.Config{Region: aws.String(region)})
+	fallbackUploader := s3manager.NewUploaderWithClient(client, func(u *s3manager.Uploader) {
+		u.PartSize = uploader.PartSize
+		u.Concurrency = uploader.Concurrency
+	})
+	fallbackInput := *input
+	fallbackInput.Bucket = aws.String(s.cfg.FallbackBucket)
+	result, err := fallbackUploader.Upload(&fallbackInput)
+	if err != nil {
+		return nil, nil, fmt.Errorf("fallback bucket %s (%s): %w", s.cfg.FallbackBucket, region, err)
+	}
+	logrus.Infof("Stored %s in fallback bucket %s (%s)", aws.StringValue(input.Key), s.cfg.FallbackBucket, region)
+	return result, client, nil
+}
+
+// applyTags adds the object tags of opts to an upload
+func applyTags(input *s3manager.UploadInput, opts UploadOptions) {
+	tags := opts.tags()
+	if tags == nil {
+		return
+	}
+	values := url.Values{}
+	for k, v := range tags {
+		values.Set(k, v)
+	}
+	input.Tagging = aws.String(values.Encode())
+}
+
+// applyChecksum sets the checksum fields of an upload. Single part uploads carry the
+// checksum of the whole object. For multipart uploads the SDK checksums every part with
+// the algorithm instead, since S3 only accepts per-part values there.
+func applyChecksum(input *s3manager.UploadInput, alg, checksum string, singlePart bool) {
+	if alg == utils.ChecksumMD5 {
+		// MD5 is verified through Content-MD5, which multipart uploads don't support, so
+		// their parts are checked with CRC32 instead
+		if singlePart {
+			input.ContentMD5 = aws.String(checksum)
+			return
+		}
+		alg = utils.ChecksumCRC32
+	}
+
+	input.ChecksumAlgorithm = aws.String(strings.ToUpper(alg))
+	if !singlePart {
+		return
+	}
+	switch alg {
+	case utils.ChecksumCRC32:
+		input.ChecksumCRC32 = aws.String(checksum)
+	case utils.ChecksumCRC32C:
+		input.ChecksumCRC32C = aws.String(checksum)
+	case utils.ChecksumSHA1:
+		input.ChecksumSHA1 = aws.String(checksum)
+	case utils.ChecksumSHA256:
+		input.ChecksumSHA256 = aws.String(checksum)
+	}
+}
+
+// mirrorToBackup copies an uploaded object to the backup bucket under the same key. It uses a server-side
+// CopyObject so the bytes don't have to be sent again, and falls back to re-uploading
+// the file when the copy isn't possible (e.g. objects over 5GB).
+func (s *S3Storage) mirrorToBackup(sess *session.Session, uploader *s3manager.Uploader, file *os.File, key string, opts UploadOptions) error {
+	region := s.cfg.BackupRegion
+	if region == "" {
+		region = s.cfg.Region
+	}
+
+	// CopyObject must be sent to the destination bucket's region
+	backupClient := s3.New(sess, &aws.Config{Region: aws.String(region)})
+	_, err := backupClient.CopyObject(&s3.CopyObjectInput{
+		Bucket:     aws.String(s.cfg.BackupBucket),
+		Key:        aws.String(key),
+		CopySource: aws.String(url.PathEscape(s.cfg.Bucket + "/" + key)),
+		ACL:        aws.String("public-read"),
+	})
+	if err == nil {
+		logrus.Infof("Mirrored %s to backup bucket %s (%s)", key, s.cfg.BackupBucket, region)
+		return nil
+	}
+	logrus.Warnf("Server-side copy to backup bucket failed, re-uploading: %v", err)
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind file for backup upload: %w", err)
+	}
+	backupUploader := s3manager.NewUploaderWithClient(backupClient, func(u *s3manager.Uploader) {
+		u.PartSize = uploader.PartSize
+		u.Concurrency = uploader.Concurrency
+	})
+	input := &s3manager.UploadInput{
+		Bucket: aws.String(s.cfg.BackupBucket),
+		Key:    aws.String(key),
+		Body:   file,
+		ACL:    aws.String("public-read"),
+	}
+	if opts.ContentDisposition != "" {
+		input.ContentDisposition = aws.String(opts.ContentDisposition)
+	}
+	if opts.ContentType != "" {
+		input.ContentType = aws.String(opts.ContentType)
+	}
+	applyTags(input, opts)
+	if meta := opts.metadata(); meta != nil {
+		input.Metadata = aws.StringMap(meta)
+	}
+	if _, err := backupUploader.Upload(input); err != nil {
+		return fmt.Errorf("failed to upload backup copy: %w", err)
+	}
+
+	logrus.Infof("Uploaded backup copy of %s to %s (%s)", key, s.cfg.BackupBucket, region)
+	return nil
+}
+
+// PresignUpload returns a presigned PUT URL clients can upload the object to directly.
+// The content type and ACL are part of the signature, so the client must send the
+// returned headers with the upload.
+func (s *S3Storage) PresignUpload(key, contentType string, expires time.Duration) (*PresignedUpload, error) {
+	sess, err := s.session()
+	if err != nil {
+		return nil, err
+	}
+
+	req, _ := s3.New(sess).PutObjectRequest(&s3.PutObjectInput{
+		Bucket:      aws.String(s.cfg.Bucket),
+		Key:         aws.String(key),
+		ContentType: aws.String(contentType),
+		ACL:         aws.String("public-read"),
+	})
+	uploadURL, err := req.Presign(expires)
+	if err != nil {
+		return nil, fmt.Errorf("failed to presign upload: %v", err)
+	}
+
+	// The object URL is the upload URL without the signature
+	objectURL := *req.HTTPRequest.URL
+	objectURL.RawQuery = ""
+
+	return &PresignedUpload{
+		UploadURL: uploadURL,
+		Method:    http.MethodPut,
+		Headers: map[string]string{
+			"Content-Type": contentType,
+			"x-amz-acl":    "public-read",
+		},
+		ObjectURL: objectURL.String(),
+		ExpiresAt: time.Now().Add(expires).UTC(),
+	}, nil
+}
+
+// PresignDownload returns a presigned GET URL for the object under key. The header
+// overrides become response-content-* parameters covered by the signature, so they
+// work on private buckets and can't be changed by the client.
+func (s *S3Storage) PresignDownload(key string, overrides ResponseHeaders, expires time.Duration) (string, error) {
+	sess, err := s.session()
+	if err != nil {
+		return "", err
+	}
+
+	input := &s3.GetObjectInput{
+		Bucket: aws.String(s.cfg.Bucket),
+		Key:    aws.String(key),
+	}
+	if overrides.ContentDisposition != "" {
+		input.ResponseContentDisposition = aws.String(overrides.ContentDisposition)
+	}
+	if overrides.ContentType != "" {
+		input.ResponseContentType = aws.String(overrides.ContentType)
+	}
+	req, _ := s3.New(sess).GetObjectRequest(input)
+	downloadURL, err := req.Presign(expires)
+	if err != nil {
+		return "", fmt.Errorf("failed to presign download: %v", err)
+	}
+	return downloadURL, nil
+}
@@ -0,0 +1,32 @@
+package storage
+
+import (
+	"testing"
+
+	"github.com/asset_upload_service/utils"
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/service/s3/s3manager"
+)
+
+func TestApplyChecksum(t *testing.T) {
+	tests := []struct {
+		alg        string
+		singlePart bool
+		// want is the checksum algorithm sent, contentMD5 and sha256 the values
+		want, contentMD5, sha256 string
+	}{
+		{utils.ChecksumSHA256, true, "SHA256", "", "checksum"},
+		{utils.ChecksumSHA256, false, "SHA256", "", ""},
+		{utils.ChecksumMD5, true, "", "checksum", ""},
+		// Content-MD5 doesn't cover multipart uploads, their parts still get verified
+		{utils.ChecksumMD5, false, "CRC32", "", ""},
+	}
+	for _, tt := range tests {
+		input := &s3manager.UploadInput{}
+		applyChecksum(input, tt.alg, "checksum", tt.singlePart)
+		got := [3]string{aws.StringValue(input.ChecksumAlgorithm), aws.StringValue(input.ContentMD5), aws.StringValue(input.ChecksumSHA256)}
+		if got != [3]string{tt.want, tt.contentMD5, tt.sha256} {
+			t.Errorf("%s single part %v: algorithm, Content-MD5, SHA-256 = %q, want %q", tt.alg, tt.singlePart, got, [3]string{tt.want, tt.contentMD5, tt.sha256})
+		}
+	}
+}
@@ -0,0 +1,211 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"maps"
+	"net/url"
+	"os"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/asset_upload_service/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ObjectStorage stores uploaded files in a cloud bucket or container
+type ObjectStorage interface {
+	// Upload stores file under key and returns where it can be fetched from
+	Upload(file *os.File, key string, opts UploadOptions) (*UploadResult, error)
+	// Download writes the object stored under key to file and returns its URL. It
+	// returns ErrNotFound when there is no such object.
+	Download(key string, file *os.File) (string, error)
+	// ObjectURL returns the URL of the object stored under key
+	ObjectURL(key string) (string, error)
+}
+
+// KeyFromURL returns the key of the object rawURL points to. It fails for URLs outside
+// the bucket or container of store, so clients can't name objects they don't own.
+func KeyFromURL(store ObjectStorage, rawURL string) (string, error) {
+	// The URL of any key starts with the one of the bucket
+	const probe = "k"
+	base, err := store.ObjectURL(probe)
+	if err != nil {
+		return "", err
+	}
+	bucketURL, err := url.Parse(strings.TrimSuffix(base, probe))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse bucket URL: %v", err)
+	}
+
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid URL: %v", err)
+	}
+	if !strings.EqualFold(u.Scheme, bucketURL.Scheme) || !strings.EqualFold(u.Host, bucketURL.Host) || !strings.HasPrefix(u.Path, bucketURL.Path) {
+		return "", fmt.Errorf("%s is not in the storage bucket", rawURL)
+	}
+	key := strings.TrimPrefix(u.Path, bucketURL.Path)
+	if key == "" {
+		return "", fmt.Errorf("%s does not name an object", rawURL)
+	}
+	return key, nil
+}
+
+// ErrNotFound is returned when an object doesn't exist
+var ErrNotFound = errors.New("object not found")
+
+// UploadOptions sets optional headers of the stored object
+type UploadOptions struct {
+	// ContentDisposition is returned as the Content-Disposition header, empty omits it
+	ContentDisposition string
+	// ContentType overrides the content type the backend would otherwise use
+	ContentType string
+	// ExpiresInDays tags the object with ExpiryTag for a lifecycle rule to delete it
+	// after that many days, 0 keeps it
+	ExpiresInDays int
+	// SHA256 is the hash of the file computed while the upload was read. The S3 backend
+	// uses it as the sha256 checksum instead of reading the file again, so it must only
+	// be set when the file is stored unmodified.
+	SHA256 []byte
+	// Metadata is stored as user metadata of the object (x-amz-meta-* on S3). Keys are
+	// lowercase letters, digits and dashes; see metadata for what gets dropped.
+	Metadata map[string]string
+}
+
+// MaxMetadataSize is the most user metadata S3 stores with an object, counted as the
+// bytes of all keys and values
+const MaxMetadataSize = 2048
+
+// metadata returns the user metadata to store with an upload, nil when there is none.
+// Entries with invalid keys or values that aren't printable ASCII (which S3 would
+// mangle) are dropped, as are the ones past MaxMetadataSize in key order.
+func (o UploadOptions) metadata() map[string]string {
+	if len(o.Metadata) == 0 {
+		return nil
+	}
+	meta := make(map[string]string, len(o.Metadata))
+	size := 0
+	for _, k := range slices.Sorted(maps.Keys(o.Metadata)) {
+		v := o.Metadata[k]
+		if !validMetadataKey(k) || strings.IndexFunc(v, func(r rune) bool { return r < ' ' || r > '~' }) >= 0 {
+			logrus.Warnf("Dropping invalid object metadata %q: %q", k, v)
+			continue
+		}
+		if size+len(k)+len(v) > MaxMetadataSize {
+			logrus.Warnf("Dropping object metadata %q, it exceeds the %d byte limit", k, MaxMetadataSize)
+			continue
+		}
+		size += len(k) + len(v)
+		meta[k] = v
+	}
+	return meta
+}
+
+func validMetadataKey(key string) bool {
+	if key == "" {
+		return false
+	}
+	for _, r := range key {
+		if !(r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// ExpiryTag is the object tag (blob index tag on Azure) that bucket lifecycle rules
+// match to expire objects uploaded with expires_in
+const ExpiryTag = "expires-in-days"
+
+// tags returns the object tags to store with an upload, nil when there are none
+func (o UploadOptions) tags() map[string]string {
+	if o.ExpiresInDays <= 0 {
+		return nil
+	}
+	return map[string]string{ExpiryTag: strconv.Itoa(o.ExpiresInDays)}
+}
+
+// UploadResult describes a stored object
+type UploadResult struct {
+	URL string
+	// Checksum is the base64 checksum the backend verified the object against,
+	// empty when the backend didn't receive one
+	Checksum          string
+	ChecksumAlgorithm string
+	// VerifiedSize is the size of the stored object when uploads are verified
+	VerifiedSize int64
+	// Fallback is set when the object went to the fallback bucket because the primary
+	// one was unavailable
+	Fallback bool
+}
+
+// Presigner is implemented by backends that can hand out URLs for clients to upload
+// and download objects directly, bypassing the service
+type Presigner interface {
+	PresignUpload(key, contentType string, expires time.Duration) (*PresignedUpload, error)
+	// PresignDownload returns a GET URL for the object under key. Non-empty
+	// overrides replace the headers the object is served with.
+	PresignDownload(key string, overrides ResponseHeaders, expires time.Duration) (string, error)
+}
+
+// ResponseHeaders override the headers a presigned download is served with
+type ResponseHeaders struct {
+	ContentDisposition string
+	ContentType        string
+}
+
+// PresignedUpload describes how a client uploads an object directly
+type PresignedUpload struct {
+	UploadURL string
+	Method    string
+	// Headers must be sent with the upload, they are covered by the signature
+	Headers   map[string]string
+	ObjectURL string
+	ExpiresAt time.Time
+}
+
+// New returns the backend selected by storage.backend
+func New(cfg *config.Config) (ObjectStorage, error) {
+	switch cfg.Storage.Backend {
+	case "s3":
+		return NewS3Storage(cfg.AWS, cfg.Storage.ChecksumAlgorithm, cfg.Storage.VerifyUpload), nil
+	case "azure":
+		return NewAzureStorage(cfg.Azure, cfg.Storage.VerifyUpload)
+	}
+	return nil, fmt.Errorf("unsupported storage backend: %s", cfg.Storage.Backend)
+}
+
+// verifySize checks that a stored object has the size of the uploaded file
+func verifySize(file *os.File, key string, storedSize int64) error {
+	info, err := file.Stat()
+	if err != nil {
+		return fmt.Errorf("failed to stat file: %v", err)
+	}
+	if storedSize != info.Size() {
+		return fmt.Errorf("upload verification failed: %s is %d bytes, expected %d", key, storedSize, info.Size())
+	}
+	logrus.Infof("Verified upload of %s (%d bytes)", key, storedSize)
+	return nil
+}
+
+// verifyMetadata checks that a stored object carries the metadata it was uploaded with.
+// Backends change the case of keys (and Azure doesn't allow dashes), so keys are
+// compared normalized.
+func verifyMetadata(key string, want map[string]string, stored map[string]*string) error {
+	normalize := func(k string) string { return strings.ReplaceAll(strings.ToLower(k), "_", "-") }
+	got := make(map[string]string, len(stored))
+	for k, v := range stored {
+		if v != nil {
+			got[normalize(k)] = *v
+		}
+	}
+	for k, v := range want {
+		if got[normalize(k)] != v {
+			return fmt.Errorf("upload verification failed: %s has metadata %s=%q, expected %q", key, k, got[normalize(k)], v)
+		}
+	}
+	return nil
+}
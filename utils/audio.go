@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/asset_upload_service/models"
+	"github.com/sirupsen/logrus"
+)
+
+// audioEncoders maps the extract_audio formats to their file extension and ffmpeg
+// encoder settings. AAC goes into an M4A container, which players handle better than
+// raw ADTS streams.
+var audioEncoders = map[string]struct {
+	ext  string
+	args []string
+}{
+	"mp3": {".mp3", []string{"-c:a", "libmp3lame", "-b:a", "192k"}},
+	"aac": {".m4a", []string{"-c:a", "aac", "-b:a", "160k", "-movflags", "+faststart"}},
+}
+
+// ValidAudioFormat reports whether format is a supported extract_audio format
+func ValidAudioFormat(format string) bool {
+	_, ok := audioEncoders[format]
+	return ok
+}
+
+// AudioExtension returns the file extension used for extracted audio of format
+func AudioExtension(format string) string {
+	return audioEncoders[format].ext
+}
+
+// ExtractAudio transcodes the first audio stream of inputPath to format at outputPath
+func ExtractAudio(inputPath, outputPath, format string) error {
+	encoder, ok := audioEncoders[format]
+	if !ok {
+		return fmt.Errorf("unsupported audio format: %s", format)
+	}
+
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is not installed: %w", err)
+	}
+
+	args := append([]string{"-i", inputPath, "-vn", "-map", "0:a:0"}, encoder.args...)
+	args = append(args, "-y", outputPath)
+	cmd, done := mediaexec.Command(context.Background(), ffmpegPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	logrus.Infof("Extracting %s audio: %s", format, cmd.String())
+	if err := done(cmd.Run()); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("ffmpeg failed to extract audio: %w, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
+
+// AudioInfo describes the first audio stream of a probed file, or returns nil when
+// there is none
+func AudioInfo(probe *ProbeResult) *models.ExtractedAudio {
+	streams := probe.StreamsOfType("audio")
+	if len(streams) == 0 {
+		return nil
+	}
+
+	stream := streams[0]
+	info := &models.ExtractedAudio{
+		Codec:    stream.CodecName,
+		Channels: stream.Channels,
+		Duration: probe.Duration(),
+	}
+	info.SampleRate, _ = strconv.Atoi(stream.SampleRate)
+	info.BitRate, _ = strconv.ParseInt(stream.BitRate, 10, 64)
+	return info
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+)
+
+// Checksum algorithms for upload integrity checks, matching S3's ChecksumAlgorithm
+// values (lowercased) plus MD5 for Content-MD5
+const (
+	ChecksumNone   = "none"
+	ChecksumCRC32  = "crc32"
+	ChecksumCRC32C = "crc32c"
+	ChecksumSHA1   = "sha1"
+	ChecksumSHA256 = "sha256"
+	ChecksumMD5    = "md5"
+)
+
+// ValidChecksumAlgorithm reports whether alg is a supported checksum algorithm
+func ValidChecksumAlgorithm(alg string) bool {
+	switch alg {
+	case ChecksumNone, ChecksumCRC32, ChecksumCRC32C, ChecksumSHA1, ChecksumSHA256, ChecksumMD5:
+		return true
+	}
+	return false
+}
+
+// NewChecksumHash returns a hash computing the checksum algorithm alg
+func NewChecksumHash(alg string) (hash.Hash, error) {
+	switch alg {
+	case ChecksumCRC32:
+		return crc32.NewIEEE(), nil
+	case ChecksumCRC32C:
+		return crc32.New(crc32.MakeTable(crc32.Castagnoli)), nil
+	case ChecksumSHA1:
+		return sha1.New(), nil
+	case ChecksumSHA256:
+		return sha256.New(), nil
+	case ChecksumMD5:
+		return md5.New(), nil
+	}
+	return nil, fmt.Errorf("unsupported checksum algorithm: %s", alg)
+}
+
+// ComputeChecksum returns the base64 encoded checksum of r, the encoding S3 expects in
+// its checksum headers, and rewinds r so it can be uploaded afterwards
+func ComputeChecksum(r io.ReadSeeker, alg string) (string, error) {
+	h, err := NewChecksumHash(alg)
+	if err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	if _, err := r.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(h.Sum(nil)), nil
+}
+
+// ReadAllSHA256 reads r like io.ReadAll and computes the SHA-256 of the bytes in the
+// same pass, so key naming and checksums don't have to hash the upload again. It
+// still holds the whole upload in memory: the hash key strategy needs the hash before
+// the object is stored, and detection, moderation and image processing read the bytes.
+func ReadAllSHA256(r io.Reader) ([]byte, []byte, error) {
+	h := sha256.New()
+	data, err := io.ReadAll(io.TeeReader(r, h))
+	if err != nil {
+		return nil, nil, err
+	}
+	return data, h.Sum(nil), nil
+}
@@ -0,0 +1,71 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/jpeg"
+
+	"github.com/disintegration/imaging"
+)
+
+// IsCMYKJPEG reports whether data is a JPEG with four color components (CMYK or
+// YCCK), as print tools write them. Decoders get their colors wrong or fail on them.
+func IsCMYKJPEG(data []byte) bool {
+	if !isJPEG(data) {
+		return false
+	}
+	cmyk := false
+	jpegSegments(data, func(marker byte, payload []byte) bool {
+		// SOF0-SOF15 except DHT, JPG and DAC: precision, height, width, component count
+		if marker >= 0xC0 && marker <= 0xCF && marker != 0xC4 && marker != 0xC8 && marker != 0xCC {
+			cmyk = len(payload) >= 6 && payload[5] == 4
+			return false
+		}
+		return true
+	})
+	return cmyk
+}
+
+// ConvertCMYKToRGB decodes a CMYK JPEG and encodes it again as an RGB JPEG, so the
+// rest of the pipeline sees ordinary images. Colors are converted without the
+// embedded CMYK profile, which is dropped.
+func ConvertCMYKToRGB(data []byte, quality int) ([]byte, error) {
+	img, err := decodeCMYKJPEG(data)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := jpeg.Encode(&buf, imaging.Clone(img), &jpeg.Options{Quality: quality}); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// decodeCMYKJPEG decodes a 4 component JPEG. The standard decoder only accepts them
+// with an Adobe APP14 segment and takes the data to be stored inverted, as Adobe
+// tools do. Files without the segment store plain CMYK (like libjpeg assumes), so
+// one is added for decoding and the inversion undone.
+func decodeCMYKJPEG(data []byte) (image.Image, error) {
+	hasAdobe := false
+	jpegSegments(data, func(marker byte, payload []byte) bool {
+		hasAdobe = marker == 0xEE && bytes.HasPrefix(payload, []byte("Adobe"))
+		return !hasAdobe
+	})
+	if hasAdobe {
+		return jpeg.Decode(bytes.NewReader(data))
+	}
+
+	// APP14: "Adobe", version 100, two flag words and transform 0 (plain CMYK)
+	app14 := []byte{0xFF, 0xEE, 0x00, 0x0E, 'A', 'd', 'o', 'b', 'e', 0x00, 0x64, 0x00, 0x00, 0x00, 0x00, 0x00}
+	patched := append(append(append([]byte{}, data[:2]...), app14...), data[2:]...)
+	img, err := jpeg.Decode(bytes.NewReader(patched))
+	if err != nil {
+		return nil, err
+	}
+	if cmyk, ok := img.(*image.CMYK); ok {
+		for i := range cmyk.Pix {
+			cmyk.Pix[i] = 255 - cmyk.Pix[i]
+		}
+	}
+	return img, nil
+}
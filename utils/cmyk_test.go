@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"os"
+	"testing"
+)
+
+// The fixtures are 16x8 four component JPEGs: a cyan block on the left and a
+// yellow one on the right. cmyk.jpg stores plain CMYK without an Adobe segment,
+// cmyk_adobe.jpg stores the inverted values with an APP14 segment.
+var cmykFixtures = []string{"testdata/cmyk.jpg", "testdata/cmyk_adobe.jpg"}
+
+func TestIsCMYKJPEG(t *testing.T) {
+	for _, name := range cmykFixtures {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !IsCMYKJPEG(data) {
+			t.Errorf("IsCMYKJPEG(%s) = false, want true", name)
+		}
+	}
+
+	var rgb bytes.Buffer
+	if err := jpeg.Encode(&rgb, image.NewRGBA(image.Rect(0, 0, 8, 8)), nil); err != nil {
+		t.Fatal(err)
+	}
+	if IsCMYKJPEG(rgb.Bytes()) {
+		t.Error("IsCMYKJPEG(RGB JPEG) = true, want false")
+	}
+}
+
+func TestConvertCMYKToRGB(t *testing.T) {
+	for _, name := range cmykFixtures {
+		t.Run(name, func(t *testing.T) {
+			data, err := os.ReadFile(name)
+			if err != nil {
+				t.Fatal(err)
+			}
+			out, err := ConvertCMYKToRGB(data, 95)
+			if err != nil {
+				t.Fatalf("ConvertCMYKToRGB: %v", err)
+			}
+			if IsCMYKJPEG(out) {
+				t.Error("output is still a CMYK JPEG")
+			}
+			img, err := jpeg.Decode(bytes.NewReader(out))
+			if err != nil {
+				t.Fatalf("decode output: %v", err)
+			}
+			if got := img.Bounds(); got != image.Rect(0, 0, 16, 8) {
+				t.Errorf("bounds = %v, want 16x8", got)
+			}
+			for _, tc := range []struct {
+				x    int
+				want color.RGBA
+			}{
+				{4, color.RGBA{0, 255, 255, 255}},
+				{12, color.RGBA{255, 255, 0, 255}},
+			} {
+				got := color.RGBAModel.Convert(img.At(tc.x, 4)).(color.RGBA)
+				if !closeColor(got, tc.want, 8) {
+					t.Errorf("pixel at x=%d = %v, want about %v", tc.x, got, tc.want)
+				}
+			}
+		})
+	}
+}
+
+func closeColor(a, b color.RGBA, tolerance int) bool {
+	near := func(x, y uint8) bool {
+		d := int(x) - int(y)
+		return d <= tolerance && d >= -tolerance
+	}
+	return near(a.R, b.R) && near(a.G, b.G) && near(a.B, b.B)
+}
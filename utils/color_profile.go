@@ -0,0 +1,291 @@
+package utils
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"image"
+	"io"
+	"math"
+	"sort"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/asset_upload_service/models"
+)
+
+var pngSignature = []byte("\x89PNG\r\n\x1a\n")
+
+// ExtractICCProfile returns the embedded ICC profile of a JPEG or PNG image, or nil
+// when the image has none
+func ExtractICCProfile(data []byte) []byte {
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xD8}):
+		return jpegICCProfile(data)
+	case bytes.HasPrefix(data, pngSignature):
+		return pngICCProfile(data)
+	}
+	return nil
+}
+
+// jpegSegments calls fn for each marker segment before the image data starts
+func jpegSegments(data []byte, fn func(marker byte, payload []byte) bool) {
+	pos := 2
+	for pos+4 <= len(data) {
+		if data[pos] != 0xFF {
+			return
+		}
+		marker := data[pos+1]
+		// Start of scan: the rest is entropy coded image data
+		if marker == 0xDA || marker == 0xD9 {
+			return
+		}
+		length := int(binary.BigEndian.Uint16(data[pos+2:]))
+		if length < 2 || pos+2+length > len(data) {
+			return
+		}
+		if !fn(marker, data[pos+4:pos+2+length]) {
+			return
+		}
+		pos += 2 + length
+	}
+}
+
+// jpegICCProfile reassembles an ICC profile split across APP2 segments
+func jpegICCProfile(data []byte) []byte {
+	const iccHeader = "ICC_PROFILE\x00"
+	chunks := map[int][]byte{}
+	jpegSegments(data, func(marker byte, payload []byte) bool {
+		if marker == 0xE2 && len(payload) > len(iccHeader)+2 && string(payload[:len(iccHeader)]) == iccHeader {
+			seq := int(payload[len(iccHeader)])
+			chunks[seq] = payload[len(iccHeader)+2:]
+		}
+		return true
+	})
+	if len(chunks) == 0 {
+		return nil
+	}
+
+	seqs := make([]int, 0, len(chunks))
+	for seq := range chunks {
+		seqs = append(seqs, seq)
+	}
+	sort.Ints(seqs)
+
+	var profile []byte
+	for _, seq := range seqs {
+		profile = append(profile, chunks[seq]...)
+	}
+	return profile
+}
+
+// pngChunks calls fn for each chunk of a PNG file
+func pngChunks(data []byte, fn func(typ string, payload []byte) bool) {
+	pos := len(pngSignature)
+	for pos+8 <= len(data) {
+		length := int(binary.BigEndian.Uint32(data[pos:]))
+		typ := string(data[pos+4 : pos+8])
+		if length < 0 || pos+12+length > len(data) {
+			return
+		}
+		if !fn(typ, data[pos+8:pos+8+length]) {
+			return
+		}
+		pos += 12 + length
+	}
+}
+
+// pngICCProfile decompresses the profile stored in the iCCP chunk
+func pngICCProfile(data []byte) []byte {
+	var profile []byte
+	pngChunks(data, func(typ string, payload []byte) bool {
+		if typ == "IDAT" {
+			return false
+		}
+		if typ != "iCCP" {
+			return true
+		}
+		// profile name, NUL, compression method, zlib data
+		nul := bytes.IndexByte(payload, 0)
+		if nul < 0 || nul+2 > len(payload) {
+			return false
+		}
+		r, err := zlib.NewReader(bytes.NewReader(payload[nul+2:]))
+		if err != nil {
+			return false
+		}
+		defer r.Close()
+		profile, _ = io.ReadAll(r)
+		return false
+	})
+	return profile
+}
+
+// ICCProfileDescription returns the human readable name stored in a profile's desc tag
+func ICCProfileDescription(profile []byte) string {
+	if len(profile) < 132 {
+		return ""
+	}
+	count := int(binary.BigEndian.Uint32(profile[128:]))
+	for i := 0; i < count; i++ {
+		entry := 132 + i*12
+		if entry+12 > len(profile) {
+			return ""
+		}
+		if string(profile[entry:entry+4]) != "desc" {
+			continue
+		}
+		offset := int(binary.BigEndian.Uint32(profile[entry+4:]))
+		size := int(binary.BigEndian.Uint32(profile[entry+8:]))
+		if offset < 0 || size < 12 || offset+size > len(profile) {
+			return ""
+		}
+		return parseDescTag(profile[offset : offset+size])
+	}
+	return ""
+}
+
+// parseDescTag decodes the ICC v2 "desc" and v4 "mluc" tag types
+func parseDescTag(tag []byte) string {
+	switch string(tag[:4]) {
+	case "desc":
+		n := int(binary.BigEndian.Uint32(tag[8:]))
+		if 12+n > len(tag) {
+			return ""
+		}
+		return strings.TrimRight(string(tag[12:12+n]), "\x00")
+	case "mluc":
+		if len(tag) < 28 {
+			return ""
+		}
+		// Use the first record
+		length := int(binary.BigEndian.Uint32(tag[20:]))
+		offset := int(binary.BigEndian.Uint32(tag[24:]))
+		if offset+length > len(tag) {
+			return ""
+		}
+		raw := tag[offset : offset+length]
+		units := make([]uint16, len(raw)/2)
+		for i := range units {
+			units[i] = binary.BigEndian.Uint16(raw[i*2:])
+		}
+		return strings.TrimRight(string(utf16.Decode(units)), "\x00")
+	}
+	return ""
+}
+
+// Color spaces the service knows how to identify and convert
+const (
+	ColorSpaceSRGB     = "sRGB"
+	ColorSpaceP3       = "Display P3"
+	ColorSpaceAdobeRGB = "Adobe RGB (1998)"
+)
+
+// GetColorInfo reports the color space and embedded ICC profile of an image.
+// Images without a profile are assumed to be sRGB, as browsers do.
+func GetColorInfo(data []byte) *models.ColorInfo {
+	profile := ExtractICCProfile(data)
+	if profile == nil {
+		return &models.ColorInfo{ColorSpace: ColorSpaceSRGB}
+	}
+
+	info := &models.ColorInfo{
+		ICCProfile:  true,
+		ProfileName: ICCProfileDescription(profile),
+	}
+
+	name := strings.ToLower(info.ProfileName)
+	switch {
+	case strings.Contains(name, "p3"):
+		info.ColorSpace = ColorSpaceP3
+	case strings.Contains(name, "adobe rgb"):
+		info.ColorSpace = ColorSpaceAdobeRGB
+	case strings.Contains(name, "srgb"):
+		info.ColorSpace = ColorSpaceSRGB
+	case len(profile) >= 20:
+		// Fall back to the profile's data color space (RGB, CMYK, GRAY)
+		info.ColorSpace = strings.TrimSpace(string(profile[16:20]))
+	}
+	return info
+}
+
+// toSRGBMatrices convert linear RGB in a given color space to linear sRGB (D65)
+var toSRGBMatrices = map[string][3][3]float64{
+	ColorSpaceP3: {
+		{1.2249, -0.2247, 0},
+		{-0.0420, 1.0419, 0},
+		{-0.0197, -0.0786, 1.0979},
+	},
+	ColorSpaceAdobeRGB: {
+		{1.3982, -0.3982, 0},
+		{0, 1, 0},
+		{0, -0.0429, 1.0429},
+	},
+}
+
+// CanConvertToSRGB reports whether ConvertToSRGB supports the color space
+func CanConvertToSRGB(colorSpace string) bool {
+	_, ok := toSRGBMatrices[colorSpace]
+	return ok
+}
+
+// ConvertToSRGB converts an image decoded from the given color space to sRGB
+func ConvertToSRGB(img image.Image, colorSpace string) *image.NRGBA {
+	m := toSRGBMatrices[colorSpace]
+
+	// Display P3 uses the sRGB transfer curve, Adobe RGB a pure 2.2 gamma
+	var decode [256]float64
+	for i := range decode {
+		v := float64(i) / 255
+		if colorSpace == ColorSpaceAdobeRGB {
+			decode[i] = math.Pow(v, 563.0/256.0)
+		} else {
+			decode[i] = srgbToLinear(v)
+		}
+	}
+
+	bounds := img.Bounds()
+	out := image.NewNRGBA(image.Rect(0, 0, bounds.Dx(), bounds.Dy()))
+	for y := bounds.Min.Y; y < bounds.Max.Y; y++ {
+		for x := bounds.Min.X; x < bounds.Max.X; x++ {
+			r16, g16, b16, a16 := img.At(x, y).RGBA()
+			if a16 == 0 {
+				continue
+			}
+			// Undo alpha premultiplication before converting
+			r := decode[uint8(r16*0xFF/a16)]
+			g := decode[uint8(g16*0xFF/a16)]
+			b := decode[uint8(b16*0xFF/a16)]
+
+			i := out.PixOffset(x-bounds.Min.X, y-bounds.Min.Y)
+			out.Pix[i+0] = linearToSRGB8(m[0][0]*r + m[0][1]*g + m[0][2]*b)
+			out.Pix[i+1] = linearToSRGB8(m[1][0]*r + m[1][1]*g + m[1][2]*b)
+			out.Pix[i+2] = linearToSRGB8(m[2][0]*r + m[2][1]*g + m[2][2]*b)
+			out.Pix[i+3] = uint8(a16 >> 8)
+		}
+	}
+	return out
+}
+
+func srgbToLinear(v float64) float64 {
+	if v <= 0.04045 {
+		return v / 12.92
+	}
+	return math.Pow((v+0.055)/1.055, 2.4)
+}
+
+func linearToSRGB8(v float64) uint8 {
+	// Out of gamut colors are clipped
+	if v <= 0 {
+		return 0
+	}
+	if v >= 1 {
+		return 255
+	}
+	if v <= 0.0031308 {
+		v *= 12.92
+	} else {
+		v = 1.055*math.Pow(v, 1/2.4) - 0.055
+	}
+	return uint8(math.Round(v * 255))
+}
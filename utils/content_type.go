@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+
+	"github.com/h2non/filetype"
+)
+
+// svgSniffBytes is how far into a file the <svg tag is looked for
+const svgSniffBytes = 1024
+
+// CheckContentType validates a content type the client declared for data against the
+// file's magic bytes and returns it normalized. It is accepted when sniffing detects the
+// same type or, for images, video and audio, at least the same kind of media, e.g.
+// video/quicktime for what sniffs as video/mp4. SVG sniffs as text and is accepted when
+// the file contains an <svg tag.
+func CheckContentType(declared string, data []byte) (string, error) {
+	mediaType, _, err := mime.ParseMediaType(declared)
+	if err != nil {
+		return "", fmt.Errorf("invalid content_type %q: %w", declared, err)
+	}
+
+	detected, _, _ := strings.Cut(http.DetectContentType(data), ";")
+	if mediaType == detected {
+		return mediaType, nil
+	}
+	if kind, err := filetype.Match(data); err == nil && kind.MIME.Value == mediaType {
+		return mediaType, nil
+	}
+
+	if mediaType == "image/svg+xml" {
+		head := data[:min(len(data), svgSniffBytes)]
+		if strings.HasPrefix(detected, "text/") && bytes.Contains(bytes.ToLower(head), []byte("<svg")) {
+			return mediaType, nil
+		}
+	} else {
+		declaredKind, _, _ := strings.Cut(mediaType, "/")
+		detectedKind, _, _ := strings.Cut(detected, "/")
+		switch declaredKind {
+		case "image", "video", "audio":
+			if declaredKind == detectedKind {
+				return mediaType, nil
+			}
+		}
+	}
+	return "", fmt.Errorf("content_type %s doesn't match the file's content (detected %s)", mediaType, detected)
+}
+
+// hintedVideoTypes are the content types of video extensions the mime package doesn't
+// know without a system mime.types file
+var hintedVideoTypes = map[string]string{
+	".mp4":  "video/mp4",
+	".m4v":  "video/x-m4v",
+	".mov":  "video/quicktime",
+	".mkv":  "video/x-matroska",
+	".webm": "video/webm",
+	".avi":  "video/x-msvideo",
+	".wmv":  "video/x-ms-wmv",
+	".flv":  "video/x-flv",
+	".3gp":  "video/3gpp",
+	".mpg":  "video/mpeg",
+	".mpeg": "video/mpeg",
+	".ts":   "video/mp2t",
+	".mts":  "video/mp2t",
+	".m2ts": "video/mp2t",
+	".ogv":  "video/ogg",
+}
+
+// HintedContentType applies the original_extension hint of clients that strip file
+// extensions. It only matters when neither http.DetectContentType nor filetype can
+// identify the file; the type is then looked up from the extension and hinted is true.
+// Files identified from their content keep the detected type.
+func HintedContentType(detected string, data []byte, extension string) (fileType string, hinted bool, err error) {
+	ext := "." + strings.ToLower(strings.TrimPrefix(extension, "."))
+	if len(ext) < 2 || len(ext) > 11 || strings.IndexFunc(ext[1:], func(r rune) bool {
+		return (r < 'a' || r > 'z') && (r < '0' || r > '9')
+	}) >= 0 {
+		return "", false, fmt.Errorf("invalid original_extension %q (expected letters and digits, e.g. mp4)", extension)
+	}
+
+	if mediaType, _, _ := strings.Cut(detected, ";"); mediaType != "application/octet-stream" {
+		return detected, false, nil
+	}
+	if kind, err := filetype.Match(data); err == nil && kind != filetype.Unknown {
+		return kind.MIME.Value, false, nil
+	}
+
+	if t, ok := hintedVideoTypes[ext]; ok {
+		return t, true, nil
+	}
+	if t := mime.TypeByExtension(ext); t != "" {
+		mediaType, _, _ := strings.Cut(t, ";")
+		return mediaType, true, nil
+	}
+	// Other video containers are still routed to the video pipeline
+	if videoExtensions[ext] {
+		return "video/x-" + ext[1:], true, nil
+	}
+	return "", false, fmt.Errorf("unsupported original_extension %q", extension)
+}
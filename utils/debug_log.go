@@ -0,0 +1,35 @@
+package utils
+
+import "fmt"
+
+// DebugLogLimit bounds the ffmpeg output returned to clients
+const DebugLogLimit = 16 << 10
+
+// TailBuffer keeps the last max bytes written to it. ffmpeg prints the interesting
+// part, the error or the final statistics, at the end.
+type TailBuffer struct {
+	max     int
+	buf     []byte
+	dropped int
+}
+
+func NewTailBuffer(max int) *TailBuffer {
+	return &TailBuffer{max: max}
+}
+
+func (t *TailBuffer) Write(p []byte) (int, error) {
+	t.buf = append(t.buf, p...)
+	if over := len(t.buf) - t.max; over > 0 {
+		t.dropped += over
+		t.buf = append(t.buf[:0], t.buf[over:]...)
+	}
+	return len(p), nil
+}
+
+// String returns the kept output, noting how much was cut off
+func (t *TailBuffer) String() string {
+	if t.dropped > 0 {
+		return fmt.Sprintf("[%d bytes truncated]\n%s", t.dropped, t.buf)
+	}
+	return string(t.buf)
+}
@@ -0,0 +1,188 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/disintegration/imaging"
+)
+
+// DocumentPreviewSize is the longest side of rendered document previews
+const DocumentPreviewSize = 1080
+
+// pdfRenderDPI is the resolution PDF pages are rendered at, at 72 the page size in
+// pixels is its size in points
+const pdfRenderDPI = 72
+
+// DocumentType returns the type of PDFs and TIFFs from their magic bytes, which
+// RenderFirstPage can render, or "" for other files. Content sniffing reports TIFFs
+// as application/octet-stream.
+func DocumentType(data []byte) string {
+	switch {
+	case bytes.HasPrefix(data, []byte("%PDF-")):
+		return "application/pdf"
+	case bytes.HasPrefix(data, []byte("II*\x00")), bytes.HasPrefix(data, []byte("MM\x00*")):
+		return "image/tiff"
+	}
+	return ""
+}
+
+// DocumentPage describes the first page of a PDF or TIFF and how many pages there are
+type DocumentPage struct {
+	// Pages is 0 when the page count couldn't be read
+	Pages  int
+	Width  int
+	Height int
+}
+
+// RenderFirstPage renders the first page of a PDF or multi-page TIFF to a JPEG at
+// outputPath, fitted within DocumentPreviewSize on white. TIFFs are decoded in process;
+// PDFs need pdftoppm (poppler) or ImageMagick. PDF page sizes are in points.
+func RenderFirstPage(data []byte, mimeType, outputPath string, quality int) (DocumentPage, error) {
+	var page image.Image
+	var info DocumentPage
+	var err error
+	switch mimeType {
+	case "image/tiff":
+		// The TIFF decoder only reads the first image file directory, i.e. the first page
+		if page, err = imaging.Decode(bytes.NewReader(data)); err != nil {
+			return info, fmt.Errorf("failed to decode TIFF: %w", err)
+		}
+		info.Pages = tiffPageCount(data)
+	case "application/pdf":
+		if page, info.Pages, err = renderPDF(data); err != nil {
+			return info, err
+		}
+	default:
+		return info, fmt.Errorf("unsupported document type %s", mimeType)
+	}
+	info.Width, info.Height = page.Bounds().Dx(), page.Bounds().Dy()
+
+	if info.Width > DocumentPreviewSize || info.Height > DocumentPreviewSize {
+		page = imaging.Fit(page, DocumentPreviewSize, DocumentPreviewSize, imaging.Lanczos)
+	}
+	// JPEG has no alpha, transparent areas would come out black
+	bounds := page.Bounds()
+	flattened := imaging.Overlay(imaging.New(bounds.Dx(), bounds.Dy(), color.White), page, image.Point{}, 1)
+
+	f, err := os.Create(outputPath)
+	if err != nil {
+		return info, fmt.Errorf("failed to create preview: %w", err)
+	}
+	defer f.Close()
+	if err := imaging.Encode(f, flattened, imaging.JPEG, imaging.JPEGQuality(quality)); err != nil {
+		os.Remove(outputPath)
+		return info, fmt.Errorf("failed to encode preview: %w", err)
+	}
+	return info, nil
+}
+
+// renderPDF rasterizes the first page of a PDF with pdftoppm, or ImageMagick when
+// poppler isn't installed, and counts its pages
+func renderPDF(data []byte) (image.Image, int, error) {
+	input, err := CreateTemp("document-*.pdf")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	defer os.Remove(input.Name())
+	_, err = input.Write(data)
+	if closeErr := input.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to write temporary file: %w", err)
+	}
+
+	dpi := strconv.Itoa(pdfRenderDPI)
+	var name string
+	var args []string
+	if path, err := exec.LookPath("pdftoppm"); err == nil {
+		name, args = path, []string{"-f", "1", "-l", "1", "-r", dpi, "-png", input.Name()}
+	} else if path, err := exec.LookPath("magick"); err == nil {
+		name, args = path, []string{"-density", dpi, input.Name() + "[0]", "png:-"}
+	} else if path, err := exec.LookPath("convert"); err == nil {
+		name, args = path, []string{"-density", dpi, input.Name() + "[0]", "png:-"}
+	} else {
+		return nil, 0, fmt.Errorf("rendering PDFs needs pdftoppm or ImageMagick, neither is installed")
+	}
+
+	cmd, done := mediaexec.Command(context.Background(), name, args...)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := done(cmd.Run()); err != nil {
+		return nil, 0, fmt.Errorf("failed to render PDF: %w, stderr: %s", err, stderr.String())
+	}
+	page, err := imaging.Decode(&stdout)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to decode rendered PDF page: %w", err)
+	}
+	return page, pdfPageCount(data, input.Name()), nil
+}
+
+// pdfPageObject matches page objects, but not the page tree nodes (/Type /Pages)
+var pdfPageObject = regexp.MustCompile(`/Type\s*/Page\b`)
+
+// pdfPageCount returns the page count pdfinfo reports. Without poppler the page objects
+// are counted, which misses pages in compressed object streams; then 0 is returned when
+// none are found.
+func pdfPageCount(data []byte, path string) int {
+	if pdfinfo, err := exec.LookPath("pdfinfo"); err == nil {
+		cmd, done := mediaexec.Command(context.Background(), pdfinfo, path)
+		if out, err := cmd.Output(); done(err) == nil {
+			for _, line := range strings.Split(string(out), "\n") {
+				if v, ok := strings.CutPrefix(line, "Pages:"); ok {
+					if n, err := strconv.Atoi(strings.TrimSpace(v)); err == nil {
+						return n
+					}
+				}
+			}
+		}
+	}
+	return len(pdfPageObject.FindAllIndex(data, -1))
+}
+
+// tiffPageCount counts the image file directories of a TIFF by following their chain,
+// returning 0 for data that isn't a TIFF
+func tiffPageCount(data []byte) int {
+	if len(data) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(data[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+
+	pages := 0
+	seen := map[uint32]bool{}
+	for offset := order.Uint32(data[4:]); offset != 0 && !seen[offset]; {
+		seen[offset] = true
+		pos := int(offset)
+		if pos+2 > len(data) {
+			break
+		}
+		pages++
+		// The entries are followed by the offset of the next directory
+		next := pos + 2 + int(order.Uint16(data[pos:]))*12
+		if next+4 > len(data) {
+			break
+		}
+		offset = order.Uint32(data[next:])
+	}
+	return pages
+}
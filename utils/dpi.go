@@ -0,0 +1,128 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+	"math"
+)
+
+// MaxDPI is the highest density JFIF can store (16-bit fields)
+const MaxDPI = math.MaxUint16
+
+// ErrDPIUnsupported is returned for images other than JPEG and PNG
+var ErrDPIUnsupported = errors.New("dpi can only be set on JPEG and PNG images")
+
+// inchesPerMeter converts PNG's pixels per meter to dots per inch
+const inchesPerMeter = 1 / 0.0254
+
+// SetDPI returns a copy of the encoded image data with its pixel density set to dpi,
+// without re-encoding. JPEGs get the density fields of their JFIF header (a header is
+// added when missing, Go's encoder doesn't write one), PNGs get a pHYs chunk.
+func SetDPI(data []byte, dpi int) ([]byte, error) {
+	switch {
+	case isJPEG(data):
+		return setJPEGDPI(data, dpi), nil
+	case bytes.HasPrefix(data, pngSignature):
+		return setPNGDPI(data, dpi)
+	default:
+		return nil, ErrDPIUnsupported
+	}
+}
+
+// ReadDPI returns the horizontal pixel density stored in a JPEG or PNG, ok is false
+// when the image doesn't declare one
+func ReadDPI(data []byte) (dpi int, ok bool) {
+	switch {
+	case isJPEG(data):
+		if !hasJFIF(data) {
+			return 0, false
+		}
+		density := float64(binary.BigEndian.Uint16(data[14:16]))
+		switch data[13] {
+		case 1: // dots per inch
+			return int(density), true
+		case 2: // dots per centimeter
+			return int(math.Round(density * 2.54)), true
+		}
+	case bytes.HasPrefix(data, pngSignature):
+		for offset := len(pngSignature); offset+8 <= len(data); {
+			length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+			chunkType := string(data[offset+4 : offset+8])
+			if chunkType == "IDAT" || offset+12+length > len(data) {
+				break
+			}
+			// Unit 1 is meters, 0 only gives the aspect ratio
+			if chunkType == "pHYs" && length == 9 && data[offset+16] == 1 {
+				ppm := float64(binary.BigEndian.Uint32(data[offset+8 : offset+12]))
+				return int(math.Round(ppm / inchesPerMeter)), true
+			}
+			offset += 12 + length
+		}
+	}
+	return 0, false
+}
+
+func isJPEG(data []byte) bool {
+	return len(data) > 4 && data[0] == 0xFF && data[1] == 0xD8
+}
+
+// hasJFIF reports whether a JPEG starts with a JFIF APP0 segment
+func hasJFIF(data []byte) bool {
+	return len(data) >= 18 && data[2] == 0xFF && data[3] == 0xE0 && string(data[6:11]) == "JFIF\x00"
+}
+
+func setJPEGDPI(data []byte, dpi int) []byte {
+	if hasJFIF(data) {
+		out := bytes.Clone(data)
+		out[13] = 1
+		binary.BigEndian.PutUint16(out[14:16], uint16(dpi))
+		binary.BigEndian.PutUint16(out[16:18], uint16(dpi))
+		return out
+	}
+
+	// JFIF 1.01 APP0 segment without a thumbnail, right after SOI
+	app0 := []byte{0xFF, 0xE0, 0x00, 0x10, 'J', 'F', 'I', 'F', 0x00, 0x01, 0x01, 0x01, 0, 0, 0, 0, 0x00, 0x00}
+	binary.BigEndian.PutUint16(app0[12:14], uint16(dpi))
+	binary.BigEndian.PutUint16(app0[14:16], uint16(dpi))
+
+	out := make([]byte, 0, len(data)+len(app0))
+	out = append(out, data[:2]...)
+	out = append(out, app0...)
+	return append(out, data[2:]...)
+}
+
+// setPNGDPI writes a pHYs chunk right after IHDR, replacing any existing one
+func setPNGDPI(data []byte, dpi int) ([]byte, error) {
+	phys := make([]byte, 21)
+	binary.BigEndian.PutUint32(phys[0:4], 9)
+	copy(phys[4:8], "pHYs")
+	ppm := uint32(math.Round(float64(dpi) * inchesPerMeter))
+	binary.BigEndian.PutUint32(phys[8:12], ppm)
+	binary.BigEndian.PutUint32(phys[12:16], ppm)
+	phys[16] = 1 // meters
+	binary.BigEndian.PutUint32(phys[17:21], crc32.ChecksumIEEE(phys[4:17]))
+
+	out := make([]byte, 0, len(data)+len(phys))
+	out = append(out, pngSignature...)
+	for offset := len(pngSignature); offset < len(data); {
+		if offset+12 > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		length := int(binary.BigEndian.Uint32(data[offset : offset+4]))
+		end := offset + 12 + length
+		if end > len(data) {
+			return nil, errors.New("truncated PNG chunk")
+		}
+		chunkType := string(data[offset+4 : offset+8])
+		if chunkType != "pHYs" {
+			out = append(out, data[offset:end]...)
+		}
+		if chunkType == "IHDR" {
+			out = append(out, phys...)
+		}
+		offset = end
+	}
+	return out, nil
+}
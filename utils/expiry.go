@@ -0,0 +1,46 @@
+package utils
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// MaxExpiresInDays is the longest expires_in accepted
+const MaxExpiresInDays = 3650
+
+// ParseExpiresIn parses an expires_in value, either days ("7d") or a duration ("36h"),
+// into whole days. Lifecycle rules only count days, so partial days are rounded up.
+func ParseExpiresIn(s string) (int, error) {
+	var days int
+	if n, ok := strings.CutSuffix(s, "d"); ok {
+		v, err := strconv.Atoi(n)
+		if err != nil {
+			return 0, fmt.Errorf("expires_in must be days like 7d or a duration like 36h, got %q", s)
+		}
+		days = v
+	} else {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return 0, fmt.Errorf("expires_in must be days like 7d or a duration like 36h, got %q", s)
+		}
+		days = int((d + 24*time.Hour - 1) / (24 * time.Hour))
+	}
+	if days < 1 || days > MaxExpiresInDays {
+		return 0, fmt.Errorf("expires_in must be between 1 and %d days", MaxExpiresInDays)
+	}
+	return days, nil
+}
+
+// ExpiryTime returns when an object created at created expires after days. S3 rounds
+// lifecycle expirations up to the next midnight UTC; the object is removed some time
+// after that.
+func ExpiryTime(created time.Time, days int) time.Time {
+	t := created.UTC().AddDate(0, 0, days)
+	midnight := t.Truncate(24 * time.Hour)
+	if midnight.Before(t) {
+		midnight = midnight.Add(24 * time.Hour)
+	}
+	return midnight
+}
@@ -2,26 +2,26 @@ package utils
 
 import (
 	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"io"
 	"math"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strconv"
 	"strings"
-	"time"
 
 	"github.com/disintegration/imaging"
 	"github.com/h2non/filetype"
 	"github.com/sirupsen/logrus"
 
+	"github.com/asset_upload_service/mediaexec"
 	"github.com/asset_upload_service/models"
 	"github.com/asset_upload_service/services"
-
-	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
 
 func ProcessFile(filePath string) (*models.FileInfo, error) {
@@ -31,9 +31,9 @@ func ProcessFile(filePath string) (*models.FileInfo, error) {
 	}
 	defer file.Close()
 
-	// Read first 261 bytes for file type detection
-	head := make([]byte, 261)
-	if _, err := file.Read(head); err != nil {
+	// Read the file header for file type detection
+	head, err := ReadFileHeader(file)
+	if err != nil {
 		return nil, fmt.Errorf("failed to read file header: %v", err)
 	}
 
@@ -65,6 +65,29 @@ func ProcessFile(filePath string) (*models.FileInfo, error) {
 	return info, nil
 }
 
+// headerReadSize is the number of leading bytes used for magic-number detection.
+// filetype needs 261 bytes; it can be raised with SetHeaderReadSize.
+var headerReadSize = 261
+
+// SetHeaderReadSize sets the number of bytes read by ReadFileHeader. It is meant
+// to be called once at startup from the loaded configuration.
+func SetHeaderReadSize(n int) {
+	if n >= 261 {
+		headerReadSize = n
+	}
+}
+
+// ReadFileHeader reads up to headerReadSize bytes from the start of r. Files shorter
+// than that are returned as-is so that tiny files are matched only on real content.
+func ReadFileHeader(r io.Reader) ([]byte, error) {
+	head := make([]byte, headerReadSize)
+	n, err := io.ReadFull(r, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return nil, err
+	}
+	return head[:n], nil
+}
+
 func processImage(filePath string, info *models.FileInfo) error {
 	img, err := imaging.Open(filePath)
 	if err != nil {
@@ -81,16 +104,16 @@ func processImage(filePath string, info *models.FileInfo) error {
 
 func processVideo(filePath string, info *models.FileInfo) error {
 	// Get video metadata using ffprobe
-	_, err := ffmpeg.Probe(filePath)
+	_, err := runFFprobe(filePath)
 	if err != nil {
 		return fmt.Errorf("failed to probe video: %w", err)
 	}
 
 	// Parse width and height
-	cmd := exec.Command("ffprobe", "-v", "error", "-select_streams", "v:0",
+	cmd, done := mediaexec.Command(context.Background(), "ffprobe", "-v", "error", "-select_streams", "v:0",
 		"-show_entries", "stream=width,height,duration", "-of", "csv=p=0", filePath)
 	out, err := cmd.Output()
-	if err != nil {
+	if err = done(err); err != nil {
 		return fmt.Errorf("failed to get video metadata: %w", err)
 	}
 
@@ -125,32 +148,58 @@ func calculateAspectRatio(width, height int) string {
 	return fmt.Sprintf("%d:%d", width/divisor, height/divisor)
 }
 
+// ErrNoVideoStream is returned for containers (e.g. .mp4/.mov) that only hold audio
+var ErrNoVideoStream = errors.New("file does not contain a video stream")
+
+// ErrProbeFailed is returned when a remote video was downloaded but its metadata
+// couldn't be read
+var ErrProbeFailed = errors.New("failed to get video metadata")
+
 type Dimensions struct {
 	Width    int
 	Height   int
 	Duration float64
+	// Rotation is the clockwise rotation players apply, in degrees
+	Rotation int
+}
+
+// Orientation classifies the dimensions as shown, after rotation
+func (d Dimensions) Orientation() string {
+	return ClassifyOrientation(DisplayDimensions(d.Width, d.Height, d.Rotation))
 }
 
 func GetVideoMetadata(filePath string) (Dimensions, error) {
 	// Get video metadata using ffprobe
-	_, err := ffmpeg.Probe(filePath)
+	probeOut, err := runFFprobe(filePath)
 	if err != nil {
 		return Dimensions{}, fmt.Errorf("failed to probe video: %w", err)
 	}
+	rotation := 0
+	var probe ProbeResult
+	if json.Unmarshal(probeOut, &probe) == nil {
+		if stream := probe.VideoStream(); stream != nil {
+			rotation = stream.Rotation()
+		}
+	}
 
 	// Parse width and height
-	cmd := exec.Command("ffprobe", "-v", "error",
+	cmd, done := mediaexec.Command(context.Background(), "ffprobe", "-v", "error",
 		"-select_streams", "v:0",
 		"-show_entries", "stream=width,height,duration",
 		"-of", "csv=p=0",
 		"-read_intervals", "%0:5", // Only analyze first 5 seconds
 		filePath)
 	out, err := cmd.Output()
-	if err != nil {
+	if err = done(err); err != nil {
 
 		return Dimensions{}, fmt.Errorf("failed to get video metadata: %w", err)
 	}
 
+	// No output at all means there is no video stream to select
+	if strings.TrimSpace(string(out)) == "" {
+		return Dimensions{}, ErrNoVideoStream
+	}
+
 	// Output format: width,height,duration
 	parts := strings.Split(strings.TrimSpace(string(out)), ",")
 	if len(parts) < 3 {
@@ -161,7 +210,7 @@ func GetVideoMetadata(filePath string) (Dimensions, error) {
 	height, _ := strconv.Atoi(parts[1])
 	duration, _ := strconv.ParseFloat(parts[2], 64)
 
-	return Dimensions{width, height, duration}, nil
+	return Dimensions{Width: width, Height: height, Duration: duration, Rotation: rotation}, nil
 }
 
 var videoExtensions = map[string]bool{
@@ -230,7 +279,7 @@ func GetImageDimensions(buffer []byte) (struct{ Width, Height int }, error) {
 	return struct{ Width, Height int }{img.Width, img.Height}, nil
 }
 
-// floatToRatio converts float64 to nearest fraction within max denominator
+// FloatToRatio converts float64 to nearest fraction within max denominator
 func FloatToRatio(f float64, maxDenominator int) (num, den int) {
 	minDiff := math.MaxFloat64
 	for d := 1; d <= maxDenominator; d++ {
@@ -246,6 +295,46 @@ func FloatToRatio(f float64, maxDenominator int) (num, den int) {
 	return num / g, den / g
 }
 
+// Aspect ratio reporting options, see SetRatioOptions
+var (
+	ratioMaxDenominator = 100
+	ratioTolerance      = 0.0
+)
+
+// SetRatioOptions sets the denominator cap and tolerance used by FormatRatio. It is
+// meant to be called once at startup from the loaded configuration.
+func SetRatioOptions(maxDenominator int, tolerance float64) {
+	if maxDenominator > 0 {
+		ratioMaxDenominator = maxDenominator
+	}
+	if tolerance >= 0 {
+		ratioTolerance = tolerance
+	}
+}
+
+// SimplestRatio returns the fraction with the smallest denominator up to maxDenominator
+// that is within tolerance of f, relative to f. This prefers e.g. 16:9 over 133:75 for
+// slightly off sizes. Without a tolerance, or when no fraction is close enough, it
+// returns the closest fraction like FloatToRatio.
+func SimplestRatio(f float64, maxDenominator int, tolerance float64) (num, den int) {
+	if tolerance > 0 {
+		for d := 1; d <= maxDenominator; d++ {
+			n := int(math.Round(f * float64(d)))
+			if n > 0 && math.Abs(f-float64(n)/float64(d)) <= tolerance*f {
+				// The first match has the smallest denominator, so it's already reduced
+				return n, d
+			}
+		}
+	}
+	return FloatToRatio(f, maxDenominator)
+}
+
+// FormatRatio formats an aspect ratio as "num:den" using the configured options
+func FormatRatio(ratio float64) string {
+	num, den := SimplestRatio(ratio, ratioMaxDenominator, ratioTolerance)
+	return fmt.Sprintf("%d:%d", num, den)
+}
+
 // gcd computes the greatest common divisor
 func gcd(a, b int) int {
 	for b != 0 {
@@ -254,15 +343,22 @@ func gcd(a, b int) int {
 	return a
 }
 
-// TrimVideoTo30Seconds trims a video file to the first 30 seconds using ffmpeg
-func TrimVideoTo30Seconds(inputPath, outputPath string) error {
+// SimpleVideoDuration is the 30 second cut /upload/simple applies to videos
+const SimpleVideoDuration = 30
+
+// TrimVideoTo30Seconds trims a video file to the first 30 seconds using ffmpeg. ffmpeg
+// overwrites output in place, so the caller's handle reads the trimmed video without
+// reopening it. The handle is left at the start and the trimmed size is returned.
+// ffmpeg is killed when ctx ends.
+func TrimVideoTo30Seconds(ctx context.Context, inputPath string, output *os.File) (int64, error) {
+	outputPath := output.Name()
 	logrus.Infof("Trimming video to 30 seconds: %s -> %s", inputPath, outputPath)
 
 	// Check if FFmpeg is available
 	ffmpegPath, err := exec.LookPath("ffmpeg")
 	if err != nil {
 		logrus.Errorf("FFmpeg not found: %v", err)
-		return fmt.Errorf("ffmpeg is not installed: %w", err)
+		return 0, fmt.Errorf("ffmpeg is not installed: %w", err)
 	}
 	logrus.Infof("Using FFmpeg at path: %s", ffmpegPath)
 
@@ -271,12 +367,12 @@ func TrimVideoTo30Seconds(inputPath, outputPath string) error {
 	// -t 30: duration of 30 seconds
 	// -c copy: copy streams without re-encoding (faster)
 	// -avoid_negative_ts make_zero: handle timestamp issues
-	cmd := exec.Command(ffmpegPath, 
+	cmd, done := mediaexec.Command(ctx, ffmpegPath,
 		"-i", inputPath,
-		"-t", "30",
+		"-t", fmt.Sprint(SimpleVideoDuration),
 		"-c", "copy",
 		"-avoid_negative_ts", "make_zero",
-		"-y", // overwrite output file if it exists
+		"-y", // overwrite the (empty) output file
 		outputPath,
 	)
 
@@ -285,75 +381,48 @@ func TrimVideoTo30Seconds(inputPath, outputPath string) error {
 	cmd.Stderr = &stderr
 
 	logrus.Infof("Running ffmpeg command: %s", cmd.String())
-	
-	if err := cmd.Run(); err != nil {
+
+	if err := done(cmd.Run()); err != nil {
 		logrus.Errorf("FFmpeg command failed: %v, stderr: %s", err, stderr.String())
-		return fmt.Errorf("ffmpeg failed to trim video: %w, stderr: %s", err, stderr.String())
+		return 0, fmt.Errorf("ffmpeg failed to trim video: %w, stderr: %s", err, stderr.String())
 	}
 
-	// Verify the output file was created
-	if _, err := os.Stat(outputPath); os.IsNotExist(err) {
-		return fmt.Errorf("trimmed video file was not created: %s", outputPath)
+	// Verify ffmpeg wrote into the file we hold
+	info, err := output.Stat()
+	if err != nil {
+		return 0, fmt.Errorf("failed to stat trimmed video: %w", err)
+	}
+	if info.Size() == 0 {
+		return 0, fmt.Errorf("trimmed video file is empty: %s", outputPath)
+	}
+	if _, err := output.Seek(0, io.SeekStart); err != nil {
+		return 0, fmt.Errorf("failed to rewind trimmed video: %w", err)
 	}
 
-	logrus.Infof("Successfully trimmed video to 30 seconds: %s", outputPath)
-	return nil
+	logrus.Infof("Successfully trimmed video to 30 seconds: %s (%d bytes)", outputPath, info.Size())
+	return info.Size(), nil
 }
 
 // GetVideoAspectRatioFromURL retrieves the aspect ratio of a video from a URL (such as S3)
 // It downloads the file temporarily to extract metadata then deletes it
-func GetVideoAspectRatioFromURL(videoURL string) (*models.VideoAspectRatio, error) {
+func GetVideoAspectRatioFromURL(ctx context.Context, videoURL string, opts FetchOptions) (*models.VideoAspectRatio, error) {
 	logrus.Infof("Getting aspect ratio for video at URL: %s", videoURL)
 
-	// Create a temporary file to store the downloaded video
-	tempFile, err := os.CreateTemp("", "video-*.mp4")
+	// Faststart files can be probed straight from the response without touching disk
+	dimensions, err := ProbeVideoStream(ctx, videoURL, opts)
 	if err != nil {
-		return nil, fmt.Errorf("failed to create temporary file: %w", err)
-	}
-	tempFilePath := tempFile.Name()
-	tempFile.Close() // Close it now as we'll reopen it for writing
-
-	// Clean up the temp file when done
-	defer os.Remove(tempFilePath)
-
-	// Create an HTTP client with timeout
-	client := http.Client{
-		Timeout: 30 * time.Second,
-	}
-
-	// Download just enough of the video to get metadata (first 1MB should be enough)
-	req, err := http.NewRequest("GET", videoURL, nil)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create request: %w", err)
-	}
-	req.Header.Set("Range", "bytes=0-1048576") // First 1MB
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return nil, fmt.Errorf("failed to download video: %w", err)
-	}
-	defer resp.Body.Close()
-
-	if resp.StatusCode != http.StatusOK && resp.StatusCode != http.StatusPartialContent {
-		return nil, fmt.Errorf("failed to download video, status code: %d", resp.StatusCode)
-	}
-
-	// Write the downloaded bytes to the temp file
-	out, err := os.Create(tempFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to create temp file for writing: %w", err)
-	}
-	defer out.Close()
-
-	_, err = io.Copy(out, resp.Body)
-	if err != nil {
-		return nil, fmt.Errorf("failed to save video file: %w", err)
-	}
-
-	// Get video metadata including dimensions
-	dimensions, err := GetVideoMetadata(tempFilePath)
-	if err != nil {
-		return nil, fmt.Errorf("failed to get video metadata: %w", err)
+		if errors.Is(err, ErrRemoteNotFound) || errors.Is(err, ErrBlockedAddress) || ctx.Err() != nil {
+			return nil, err
+		}
+		// ffprobe read the container and found only audio, the whole file wouldn't differ
+		if errors.Is(err, ErrNoVideoStream) {
+			return nil, fmt.Errorf("%w: %w", ErrProbeFailed, err)
+		}
+		logrus.Infof("Streaming probe of %s failed, falling back to a temp file: %v", videoURL, err)
+		dimensions, err = probeURLViaTempFile(ctx, videoURL, opts)
+		if err != nil {
+			return nil, err
+		}
 	}
 
 	// Calculate aspect ratio
@@ -362,15 +431,14 @@ func GetVideoAspectRatioFromURL(videoURL string) (*models.VideoAspectRatio, erro
 	if width > 0 && height > 0 {
 		originalRatio = float64(width) / float64(height)
 	} else {
-		return nil, fmt.Errorf("invalid video dimensions: width=%d, height=%d", width, height)
+		return nil, fmt.Errorf("%w: invalid video dimensions: width=%d, height=%d", ErrProbeFailed, width, height)
 	}
 
 	// Convert to formatted ratio (e.g. "16:9")
-	num, den := FloatToRatio(originalRatio, 100)
-	formattedRatio := fmt.Sprintf("%d:%d", num, den)
+	formattedRatio := FormatRatio(originalRatio)
 
 	// Get the closest standard format
-	resizer := services.NewResizer(90)
+	resizer := services.DefaultResizer()
 	standardFormat := resizer.DetectFormat(width, height)
 
 	return &models.VideoAspectRatio{
@@ -380,5 +448,54 @@ func GetVideoAspectRatioFromURL(videoURL string) (*models.VideoAspectRatio, erro
 		FormattedRatio: formattedRatio,
 		StandardFormat: standardFormat,
 		Duration:       dimensions.Duration,
+		Orientation:    dimensions.Orientation(),
 	}, nil
 }
+
+// probeURLViaTempFile downloads the start of the video to disk and probes it, fetching
+// the whole file when the metadata isn't in the first 1MB (moov atom at the end)
+func probeURLViaTempFile(ctx context.Context, videoURL string, opts FetchOptions) (Dimensions, error) {
+	// Create a temporary file to store the downloaded video
+	tempFile, err := CreateTemp("video-*.mp4")
+	if err != nil {
+		return Dimensions{}, fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tempFilePath := tempFile.Name()
+
+	// Clean up the temp file when done
+	defer os.Remove(tempFilePath)
+	defer tempFile.Close()
+
+	// Download just enough of the video to get metadata (first 1MB should be enough)
+	partial, err := DownloadToFile(ctx, videoURL, "bytes=0-1048576", tempFile, opts)
+	if err != nil {
+		return Dimensions{}, err
+	}
+
+	// Get video metadata including dimensions
+	dimensions, err := GetVideoMetadata(tempFilePath)
+	if err != nil && partial {
+		// The moov atom is probably at the end of the file, so the first 1MB
+		// doesn't contain the metadata. Fall back to fetching the whole file.
+		logrus.Warnf("Metadata not found in first 1MB of %s, downloading full file: %v", videoURL, err)
+		if err := tempFile.Truncate(0); err != nil {
+			return Dimensions{}, fmt.Errorf("failed to reset temporary file: %w", err)
+		}
+		if _, err := tempFile.Seek(0, io.SeekStart); err != nil {
+			return Dimensions{}, fmt.Errorf("failed to reset temporary file: %w", err)
+		}
+		if _, err := DownloadToFile(ctx, videoURL, "", tempFile, opts); err != nil {
+			return Dimensions{}, err
+		}
+		dimensions, err = GetVideoMetadata(tempFilePath)
+	}
+	if err != nil {
+		return Dimensions{}, fmt.Errorf("%w: %w", ErrProbeFailed, err)
+	}
+
+	tempFileProbes.Add(1)
+	if info, err := tempFile.Stat(); err == nil {
+		tempFileBytes.Add(info.Size())
+	}
+	return dimensions, nil
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+
+	"github.com/h2non/filetype"
+)
+
+func TestFloatToRatio(t *testing.T) {
+	tests := []struct {
+		ratio          float64
+		maxDenominator int
+		want           string
+	}{
+		{16.0 / 9, 100, "16:9"},
+		{16.0 / 9, 10, "16:9"},
+		{4.0 / 3, 100, "4:3"},
+		{1, 100, "1:1"},
+		{4.0 / 5, 100, "4:5"},
+		{9.0 / 16, 100, "9:16"},
+		{9.0 / 16, 10, "5:9"},
+		{1.85, 100, "37:20"},
+		{1.85, 10, "13:7"},
+		{2.39, 100, "239:100"},
+		{2.39, 10, "12:5"},
+		{1366.0 / 768, 1000, "683:384"},
+		{1366.0 / 768, 100, "169:95"},
+		{1366.0 / 768, 10, "16:9"},
+		{2560.0 / 1080, 100, "64:27"},
+		{2560.0 / 1080, 10, "19:8"},
+	}
+	for _, tt := range tests {
+		num, den := FloatToRatio(tt.ratio, tt.maxDenominator)
+		if got := fmtRatio(num, den); got != tt.want {
+			t.Errorf("FloatToRatio(%v, %d) = %s, want %s", tt.ratio, tt.maxDenominator, got, tt.want)
+		}
+	}
+}
+
+func TestSimplestRatio(t *testing.T) {
+	tests := []struct {
+		ratio          float64
+		maxDenominator int
+		tolerance      float64
+		want           string
+	}{
+		// Without a tolerance it is FloatToRatio
+		{1366.0 / 768, 100, 0, "169:95"},
+		{1366.0 / 768, 100, 0.01, "16:9"},
+		{1366.0 / 768, 1000, 0.01, "16:9"},
+		{2.39, 100, 0.01, "12:5"},
+		{1.85, 100, 0.01, "11:6"},
+		{1.85, 100, 0.001, "37:20"},
+		{2560.0 / 1080, 100, 0.01, "19:8"},
+		{4.0 / 3, 100, 0.01, "4:3"},
+		{1080.0 / 1350, 100, 0.01, "4:5"},
+		// Nothing within the tolerance below the cap falls back to the closest fraction
+		{2.39, 10, 0.0001, "12:5"},
+	}
+	for _, tt := range tests {
+		num, den := SimplestRatio(tt.ratio, tt.maxDenominator, tt.tolerance)
+		if got := fmtRatio(num, den); got != tt.want {
+			t.Errorf("SimplestRatio(%v, %d, %v) = %s, want %s", tt.ratio, tt.maxDenominator, tt.tolerance, got, tt.want)
+		}
+	}
+}
+
+func fmtRatio(num, den int) string {
+	return strconv.Itoa(num) + ":" + strconv.Itoa(den)
+}
+
+func TestReadFileHeaderShortFile(t *testing.T) {
+	// A 10 byte file is far shorter than the header read, only its bytes may be matched
+	data := []byte("GIF89a\x01\x00\x01\x00")
+	path := filepath.Join(t.TempDir(), "tiny.gif")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+
+	head, err := ReadFileHeader(file)
+	if err != nil {
+		t.Fatalf("ReadFileHeader failed on a 10 byte file: %v", err)
+	}
+	if !bytes.Equal(head, data) {
+		t.Fatalf("ReadFileHeader = %q, want %q", head, data)
+	}
+	kind, err := filetype.Match(head)
+	if err != nil || kind.MIME.Value != "image/gif" {
+		t.Errorf("filetype.Match = %q, %v, want image/gif", kind.MIME.Value, err)
+	}
+}
+
+func TestReadFileHeaderEmpty(t *testing.T) {
+	head, err := ReadFileHeader(bytes.NewReader(nil))
+	if err != nil || len(head) != 0 {
+		t.Errorf("ReadFileHeader(empty) = %q, %v, want no bytes and no error", head, err)
+	}
+}
@@ -0,0 +1,165 @@
+package utils
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"unicode"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// Filename strategies used when building object keys
+const (
+	FilenameKeep  = "keep"  // use the uploaded name as-is
+	FilenameASCII = "ascii" // transliterate to ASCII, replacing what can't be
+	FilenameSlug  = "slug"  // lowercase ASCII with dashes, safest for URLs
+)
+
+// ValidFilenameStrategy reports whether strategy is a supported filename strategy
+func ValidFilenameStrategy(strategy string) bool {
+	return strategy == FilenameKeep || strategy == FilenameASCII || strategy == FilenameSlug
+}
+
+// cyrillicToLatin transliterates Cyrillic letters, which NFKD can't decompose
+var cyrillicToLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo", 'ж': "zh",
+	'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m", 'н': "n", 'о': "o",
+	'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u", 'ф': "f", 'х': "kh", 'ц': "ts",
+	'ч': "ch", 'ш': "sh", 'щ': "shch", 'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu",
+	'я': "ya", 'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g",
+}
+
+// unsafeKeyChars are ASCII characters AWS recommends avoiding in object keys
+const unsafeKeyChars = "\\{}^%`[]\"<>~#|/"
+
+// NormalizeFilename rewrites name according to strategy so it makes a clean S3 key
+func NormalizeFilename(name, strategy string) string {
+	switch strategy {
+	case FilenameASCII:
+		return toASCII(name)
+	case FilenameSlug:
+		return slugify(name)
+	default:
+		return name
+	}
+}
+
+// toASCII strips accents, transliterates Cyrillic and replaces any other
+// non-ASCII or unsafe character with an underscore
+func toASCII(name string) string {
+	stripped, _, err := transform.String(transform.Chain(norm.NFKD, runes.Remove(runes.In(unicode.Mn))), name)
+	if err != nil {
+		stripped = name
+	}
+
+	var b strings.Builder
+	for _, r := range stripped {
+		lower := unicode.ToLower(r)
+		if latin, ok := cyrillicToLatin[lower]; ok {
+			if r != lower && latin != "" {
+				latin = strings.ToUpper(latin[:1]) + latin[1:]
+			}
+			b.WriteString(latin)
+			continue
+		}
+		if r > unicode.MaxASCII || unicode.IsControl(r) || strings.ContainsRune(unsafeKeyChars, r) {
+			b.WriteRune('_')
+			continue
+		}
+		b.WriteRune(r)
+	}
+
+	result := b.String()
+	ext := filepath.Ext(result)
+	if strings.Trim(strings.TrimSuffix(result, ext), "_. ") == "" {
+		return "file" + ext
+	}
+	return result
+}
+
+// slugify lowercases the ASCII form of the name and collapses everything that isn't
+// a letter or digit into single dashes, keeping the extension
+func slugify(name string) string {
+	ascii := strings.ToLower(toASCII(name))
+	ext := filepath.Ext(ascii)
+	base := strings.TrimSuffix(ascii, ext)
+
+	var b strings.Builder
+	dash := false
+	for _, r := range base {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+			dash = false
+		} else if !dash && b.Len() > 0 {
+			b.WriteRune('-')
+			dash = true
+		}
+	}
+
+	slug := strings.TrimSuffix(b.String(), "-")
+	if slug == "" {
+		slug = "file"
+	}
+	return slug + slugifyExt(ext)
+}
+
+func slugifyExt(ext string) string {
+	var b strings.Builder
+	for _, r := range strings.TrimPrefix(ext, ".") {
+		if (r >= 'a' && r <= 'z') || (r >= '0' && r <= '9') {
+			b.WriteRune(r)
+		}
+	}
+	if b.Len() == 0 {
+		return ""
+	}
+	return "." + b.String()
+}
+
+// Content-Disposition types
+const (
+	DispositionInline     = "inline"
+	DispositionAttachment = "attachment"
+)
+
+// ValidDisposition reports whether disposition is a supported Content-Disposition type
+func ValidDisposition(disposition string) bool {
+	return disposition == DispositionInline || disposition == DispositionAttachment
+}
+
+// ContentDisposition builds a Content-Disposition header value for name. The quoted
+// filename is an ASCII fallback; names with other characters also get an RFC 5987
+// encoded filename* parameter that current browsers prefer.
+func ContentDisposition(disposition, name string) string {
+	// Path separators and control characters never belong in a download name
+	name = strings.Map(func(r rune) rune {
+		if r == '/' || r == '\\' || unicode.IsControl(r) {
+			return '_'
+		}
+		return r
+	}, filepath.Base(name))
+
+	fallback := toASCII(name)
+	value := disposition + `; filename="` + fallback + `"`
+	if fallback != name {
+		value += "; filename*=UTF-8''" + encodeRFC5987(name)
+	}
+	return value
+}
+
+// encodeRFC5987 percent-encodes everything outside the RFC 5987 attr-char set
+func encodeRFC5987(s string) string {
+	const attrChars = "!#$&+-.^_`|~"
+	var b strings.Builder
+	for _, c := range []byte(s) {
+		if (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') || strings.IndexByte(attrChars, c) >= 0 {
+			b.WriteByte(c)
+		} else {
+			fmt.Fprintf(&b, "%%%02X", c)
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,30 @@
+package utils
+
+import "testing"
+
+func TestNormalizeFilenameMultibyte(t *testing.T) {
+	tests := []struct {
+		name, ascii, slug string
+	}{
+		{"Привет мир.jpg", "Privet mir.jpg", "privet-mir.jpg"},
+		{"Щука.png", "Shchuka.png", "shchuka.png"},
+		{"Ёлка.jpeg", "Elka.jpeg", "elka.jpeg"},
+		{"café crème.JPG", "cafe creme.JPG", "cafe-creme.jpg"},
+		{"naïve résumé.pdf", "naive resume.pdf", "naive-resume.pdf"},
+		{"🎉 party 🎉.mp4", "_ party _.mp4", "party.mp4"},
+		// Nothing is left of names without a Latin or Cyrillic letter
+		{"東京.png", "file.png", "file.png"},
+		{"😀.gif", "file.gif", "file.gif"},
+	}
+	for _, tt := range tests {
+		if got := NormalizeFilename(tt.name, FilenameKeep); got != tt.name {
+			t.Errorf("NormalizeFilename(%q, keep) = %q, want it unchanged", tt.name, got)
+		}
+		if got := NormalizeFilename(tt.name, FilenameASCII); got != tt.ascii {
+			t.Errorf("NormalizeFilename(%q, ascii) = %q, want %q", tt.name, got, tt.ascii)
+		}
+		if got := NormalizeFilename(tt.name, FilenameSlug); got != tt.slug {
+			t.Errorf("NormalizeFilename(%q, slug) = %q, want %q", tt.name, got, tt.slug)
+		}
+	}
+}
@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"image"
+	"image/color"
+
+	"github.com/asset_upload_service/models"
+	"github.com/disintegration/imaging"
+)
+
+// analysisMaxSize bounds the image used for analysis so scores are cheap to compute
+// and comparable across resolutions
+const analysisMaxSize = 512
+
+// SharpnessScore estimates how sharp an image is using the variance of the Laplacian
+// of a downscaled grayscale copy. Higher is sharper; blurry images have few edges and
+// therefore a low variance.
+func SharpnessScore(img image.Image) float64 {
+	small := imaging.Fit(img, analysisMaxSize, analysisMaxSize, imaging.Box)
+	gray := imaging.Grayscale(small)
+
+	w, h := gray.Bounds().Dx(), gray.Bounds().Dy()
+	if w < 3 || h < 3 {
+		return 0
+	}
+
+	// Grayscale leaves R=G=B, so reading one channel is enough
+	lum := func(x, y int) float64 {
+		return float64(gray.Pix[y*gray.Stride+x*4])
+	}
+
+	var sum, sumSq float64
+	n := 0
+	for y := 1; y < h-1; y++ {
+		for x := 1; x < w-1; x++ {
+			l := lum(x-1, y) + lum(x+1, y) + lum(x, y-1) + lum(x, y+1) - 4*lum(x, y)
+			sum += l
+			sumSq += l * l
+			n++
+		}
+	}
+
+	mean := sum / float64(n)
+	return sumSq/float64(n) - mean*mean
+}
+
+// MaxHistogramBins is the highest supported number of bins per channel
+const MaxHistogramBins = 256
+
+// Histogram counts the pixels of a downscaled copy of img per channel, with 8-bit values
+// grouped into that many equally sized ranges. Luminance uses the Rec. 601 weights. Fully
+// transparent pixels are not counted.
+func Histogram(img image.Image, bins int) *models.Histogram {
+	if bins <= 0 || bins > MaxHistogramBins {
+		bins = MaxHistogramBins
+	}
+	small := imaging.Fit(img, analysisMaxSize, analysisMaxSize, imaging.Box)
+
+	hist := &models.Histogram{
+		Bins:      bins,
+		Red:       make([]int, bins),
+		Green:     make([]int, bins),
+		Blue:      make([]int, bins),
+		Luminance: make([]int, bins),
+	}
+	bin := func(v uint8) int {
+		return int(v) * bins / 256
+	}
+
+	for i := 0; i+3 < len(small.Pix); i += 4 {
+		r, g, b, a := small.Pix[i], small.Pix[i+1], small.Pix[i+2], small.Pix[i+3]
+		if a == 0 {
+			continue
+		}
+		lum := uint8(0.299*float64(r) + 0.587*float64(g) + 0.114*float64(b) + 0.5)
+		hist.Red[bin(r)]++
+		hist.Green[bin(g)]++
+		hist.Blue[bin(b)]++
+		hist.Luminance[bin(lum)]++
+	}
+	return hist
+}
+
+// LQIPSize bounds the longest side of low-quality image placeholders in pixels
+const LQIPSize = 20
+
+// LQIP returns a tiny, low quality JPEG of img as a data URI that front-ends can show
+// blurred while the full image loads. Transparent areas are flattened onto white.
+func LQIP(img image.Image) (string, error) {
+	small := imaging.Fit(img, LQIPSize, LQIPSize, imaging.Box)
+	flat := imaging.Overlay(imaging.New(small.Bounds().Dx(), small.Bounds().Dy(), color.White), small, image.Point{}, 1)
+
+	var buf bytes.Buffer
+	if err := imaging.Encode(&buf, flat, imaging.JPEG, imaging.JPEGQuality(30)); err != nil {
+		return "", err
+	}
+	return "data:image/jpeg;base64," + base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
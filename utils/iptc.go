@@ -0,0 +1,312 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"io"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/asset_upload_service/models"
+)
+
+// IPTC-IIM datasets of the application record (2) that are reported
+const (
+	iptcObjectName = 5
+	iptcKeywords   = 25
+	iptcByline     = 80
+	iptcHeadline   = 105
+	iptcCopyright  = 116
+	iptcCaption    = 120
+)
+
+// ExtractIPTC returns the descriptive metadata stock and DAM workflows rely on, read
+// from the IPTC-IIM block (JPEG APP13) and the XMP packet of an image. XMP values win
+// where both are present, keywords are merged. Images without either block get an
+// empty result.
+func ExtractIPTC(data []byte) *models.IPTCInfo {
+	info := &models.IPTCInfo{}
+	if packet := xmpPacket(data); packet != nil {
+		parseXMP(packet, info)
+	}
+
+	if !isJPEG(data) {
+		return info
+	}
+	jpegSegments(data, func(marker byte, payload []byte) bool {
+		const photoshopHeader = "Photoshop 3.0\x00"
+		if marker == 0xED && bytes.HasPrefix(payload, []byte(photoshopHeader)) {
+			if iim := photoshopIPTC(payload[len(photoshopHeader):]); iim != nil {
+				parseIIM(iim, info)
+			}
+			return false
+		}
+		return true
+	})
+	return info
+}
+
+// xmpPacket finds the XMP packet of a JPEG (APP1) or PNG (iTXt). Other formats embed
+// it as plain XML, so they are searched for the x:xmpmeta element.
+func xmpPacket(data []byte) []byte {
+	var packet []byte
+	switch {
+	case isJPEG(data):
+		const xmpHeader = "http://ns.adobe.com/xap/1.0/\x00"
+		jpegSegments(data, func(marker byte, payload []byte) bool {
+			if marker == 0xE1 && bytes.HasPrefix(payload, []byte(xmpHeader)) {
+				packet = payload[len(xmpHeader):]
+				return false
+			}
+			return true
+		})
+	case bytes.HasPrefix(data, pngSignature):
+		pngChunks(data, func(typ string, payload []byte) bool {
+			if typ == "IDAT" {
+				return false
+			}
+			// keyword, NUL, compression flag and method, language, NUL, translated keyword, NUL, text
+			const xmpKeyword = "XML:com.adobe.xmp\x00"
+			if typ != "iTXt" || !bytes.HasPrefix(payload, []byte(xmpKeyword)) {
+				return true
+			}
+			rest := payload[len(xmpKeyword):]
+			if len(rest) < 2 || rest[0] != 0 {
+				// Compressed XMP is rare and not supported
+				return false
+			}
+			rest = rest[2:]
+			for i := 0; i < 2; i++ {
+				nul := bytes.IndexByte(rest, 0)
+				if nul < 0 {
+					return false
+				}
+				rest = rest[nul+1:]
+			}
+			packet = rest
+			return false
+		})
+	default:
+		start := bytes.Index(data, []byte("<x:xmpmeta"))
+		if start < 0 {
+			return nil
+		}
+		end := bytes.Index(data[start:], []byte("</x:xmpmeta>"))
+		if end < 0 {
+			return nil
+		}
+		packet = data[start : start+end+len("</x:xmpmeta>")]
+	}
+	return packet
+}
+
+// XMP properties that are reported, by namespace and local name
+const (
+	nsDC        = "http://purl.org/dc/elements/1.1/"
+	nsPhotoshop = "http://ns.adobe.com/photoshop/1.0/"
+	nsRDF       = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+)
+
+// parseXMP fills info from the RDF of an XMP packet. Properties can be elements holding
+// text or an rdf:Alt/Bag/Seq of rdf:li items, or attributes of rdf:Description.
+func parseXMP(packet []byte, info *models.IPTCInfo) {
+	set := func(name xml.Name, values []string) {
+		if len(values) == 0 {
+			return
+		}
+		switch {
+		case name.Space == nsDC && name.Local == "title":
+			info.Title = values[0]
+		case name.Space == nsDC && name.Local == "description":
+			info.Caption = values[0]
+		case name.Space == nsDC && name.Local == "rights":
+			info.Copyright = values[0]
+		case name.Space == nsDC && name.Local == "creator":
+			info.Creator = strings.Join(values, ", ")
+		case name.Space == nsDC && name.Local == "subject":
+			info.Keywords = appendUnique(info.Keywords, values...)
+		case name.Space == nsPhotoshop && name.Local == "Headline":
+			info.Headline = values[0]
+		}
+	}
+
+	decoder := xml.NewDecoder(bytes.NewReader(packet))
+	// The packet wrapper declares no encoding other than UTF-8 in practice
+	decoder.CharsetReader = func(_ string, r io.Reader) (io.Reader, error) { return r, nil }
+
+	var property *xml.Name
+	var values []string
+	var text strings.Builder
+	depth, descriptionDepth, propertyDepth := 0, -1, 0
+	for {
+		token, err := decoder.Token()
+		if err != nil {
+			return
+		}
+		switch t := token.(type) {
+		case xml.StartElement:
+			depth++
+			if property == nil && t.Name.Space == nsRDF && t.Name.Local == "Description" {
+				descriptionDepth = depth
+				for _, attr := range t.Attr {
+					if value := strings.TrimSpace(attr.Value); value != "" {
+						set(attr.Name, []string{value})
+					}
+				}
+				continue
+			}
+			if property == nil && depth == descriptionDepth+1 {
+				name := t.Name
+				property, propertyDepth, values = &name, depth, nil
+			}
+			text.Reset()
+		case xml.CharData:
+			if property != nil {
+				text.Write(t)
+			}
+		case xml.EndElement:
+			if property != nil {
+				isItem := t.Name.Space == nsRDF && t.Name.Local == "li"
+				if value := strings.TrimSpace(text.String()); value != "" && (isItem || depth == propertyDepth) {
+					values = append(values, value)
+				}
+				text.Reset()
+				if depth == propertyDepth {
+					set(*property, values)
+					property = nil
+				}
+			}
+			depth--
+		}
+	}
+}
+
+// photoshopIPTC returns the IPTC-IIM data stored in the 0x0404 resource of a Photoshop
+// image resource block
+func photoshopIPTC(resources []byte) []byte {
+	pos := 0
+	for pos+12 <= len(resources) {
+		if string(resources[pos:pos+4]) != "8BIM" {
+			return nil
+		}
+		id := binary.BigEndian.Uint16(resources[pos+4:])
+		// Pascal string name, padded to an even length including the length byte
+		nameLen := int(resources[pos+6])
+		pos += 6 + (nameLen+2)&^1
+		if pos+4 > len(resources) {
+			return nil
+		}
+		size := int(binary.BigEndian.Uint32(resources[pos:]))
+		pos += 4
+		if size < 0 || pos+size > len(resources) {
+			return nil
+		}
+		if id == 0x0404 {
+			return resources[pos : pos+size]
+		}
+		pos += (size + 1) &^ 1
+	}
+	return nil
+}
+
+// parseIIM fills the fields of info XMP left empty from IPTC-IIM datasets
+func parseIIM(iim []byte, info *models.IPTCInfo) {
+	var title, headline, caption, copyright string
+	var creators, keywords []string
+	utf8Declared := false
+
+	for pos := 0; pos+5 <= len(iim); {
+		if iim[pos] != 0x1C {
+			break
+		}
+		record, dataset := iim[pos+1], iim[pos+2]
+		size := int(binary.BigEndian.Uint16(iim[pos+3:]))
+		pos += 5
+		// Extended datasets store the length in the next size&0x7FFF bytes
+		if size&0x8000 != 0 {
+			n := size & 0x7FFF
+			if n > 4 || pos+n > len(iim) {
+				break
+			}
+			size = 0
+			for _, b := range iim[pos : pos+n] {
+				size = size<<8 | int(b)
+			}
+			pos += n
+		}
+		if pos+size > len(iim) {
+			break
+		}
+		value := iim[pos : pos+size]
+		pos += size
+
+		// Record 1 dataset 90 declares the coded character set, ESC % G is UTF-8
+		if record == 1 && dataset == 90 {
+			utf8Declared = bytes.Equal(value, []byte("\x1b%G"))
+			continue
+		}
+		if record != 2 {
+			continue
+		}
+		text := strings.TrimSpace(iimString(value, utf8Declared))
+		if text == "" {
+			continue
+		}
+		switch dataset {
+		case iptcObjectName:
+			title = text
+		case iptcHeadline:
+			headline = text
+		case iptcCaption:
+			caption = text
+		case iptcCopyright:
+			copyright = text
+		case iptcByline:
+			creators = append(creators, text)
+		case iptcKeywords:
+			keywords = append(keywords, text)
+		}
+	}
+
+	fill := func(field *string, value string) {
+		if *field == "" {
+			*field = value
+		}
+	}
+	fill(&info.Title, title)
+	fill(&info.Headline, headline)
+	fill(&info.Caption, caption)
+	fill(&info.Copyright, copyright)
+	fill(&info.Creator, strings.Join(creators, ", "))
+	info.Keywords = appendUnique(info.Keywords, keywords...)
+}
+
+// iimString decodes an IIM value. Without a UTF-8 declaration most writers still use
+// UTF-8, anything that isn't valid UTF-8 is read as Latin-1.
+func iimString(value []byte, utf8Declared bool) string {
+	if utf8Declared || utf8.Valid(value) {
+		return string(value)
+	}
+	runes := make([]rune, len(value))
+	for i, b := range value {
+		runes[i] = rune(b)
+	}
+	return string(runes)
+}
+
+func appendUnique(list []string, values ...string) []string {
+	for _, v := range values {
+		found := false
+		for _, existing := range list {
+			if strings.EqualFold(existing, v) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			list = append(list, v)
+		}
+	}
+	return list
+}
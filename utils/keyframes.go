@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// KeyframeInterval is the distance between keyframes (the GOP size) of processed
+// videos, in frames or in seconds. The zero value leaves it to the encoder.
+type KeyframeInterval struct {
+	Frames  int
+	Seconds float64
+}
+
+// ParseKeyframeInterval parses intervals like "48" (frames) or "2s" (seconds)
+func ParseKeyframeInterval(s string) (KeyframeInterval, error) {
+	if secs, ok := strings.CutSuffix(s, "s"); ok {
+		seconds, err := strconv.ParseFloat(secs, 64)
+		if err != nil || seconds <= 0 || math.IsInf(seconds, 0) {
+			return KeyframeInterval{}, fmt.Errorf("invalid keyframe interval: %s", s)
+		}
+		return KeyframeInterval{Seconds: seconds}, nil
+	}
+
+	frames, err := strconv.Atoi(s)
+	if err != nil || frames <= 0 {
+		return KeyframeInterval{}, fmt.Errorf("invalid keyframe interval: %s (expected frames like 48 or seconds like 2s)", s)
+	}
+	return KeyframeInterval{Frames: frames}, nil
+}
+
+// Resolve converts an interval in seconds to frames at frameRate. It is returned
+// unchanged when the frame rate is unknown.
+func (k KeyframeInterval) Resolve(frameRate float64) KeyframeInterval {
+	if k.Seconds > 0 && frameRate > 0 {
+		return KeyframeInterval{Frames: max(1, int(math.Round(k.Seconds*frameRate)))}
+	}
+	return k
+}
+
+// ffmpegArgs returns the encoder options that enforce the interval. Scene cut detection
+// is disabled so keyframes, and therefore HLS/DASH segments, land exactly on the interval.
+func (k KeyframeInterval) ffmpegArgs() [][2]string {
+	switch {
+	case k.Frames > 0:
+		n := strconv.Itoa(k.Frames)
+		return [][2]string{{"g", n}, {"keyint_min", n}, {"sc_threshold", "0"}}
+	case k.Seconds > 0:
+		// Without a known frame rate the keyframes are forced by timestamp instead
+		expr := "expr:gte(t,n_forced*" + strconv.FormatFloat(k.Seconds, 'f', -1, 64) + ")"
+		return [][2]string{{"force_key_frames", expr}, {"sc_threshold", "0"}}
+	}
+	return nil
+}
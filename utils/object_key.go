@@ -0,0 +1,88 @@
+package utils
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Partition schemes for object keys
+const (
+	PartitionNone = "none" // no date partitioning
+	PartitionYMD  = "ymd"  // 2024/06/15/
+	PartitionHive = "hive" // year=2024/month=06/day=15/
+)
+
+// ValidPartitionScheme reports whether scheme is a supported partition scheme
+func ValidPartitionScheme(scheme string) bool {
+	return scheme == PartitionNone || scheme == PartitionYMD || scheme == PartitionHive
+}
+
+// Key case modes
+const (
+	KeyCasePreserve = "preserve"
+	KeyCaseLower    = "lower"
+	KeyCaseUpper    = "upper"
+)
+
+// ValidKeyCase reports whether mode is a supported key case mode
+func ValidKeyCase(mode string) bool {
+	return mode == KeyCasePreserve || mode == KeyCaseLower || mode == KeyCaseUpper
+}
+
+// ApplyKeyCase converts an object key or part of one to the case of mode
+func ApplyKeyCase(key, mode string) string {
+	switch mode {
+	case KeyCaseLower:
+		return strings.ToLower(key)
+	case KeyCaseUpper:
+		return strings.ToUpper(key)
+	}
+	return key
+}
+
+// PartitionPrefix returns the date partition for t using scheme. The date is always
+// taken in UTC so keys don't depend on the server's timezone.
+func PartitionPrefix(scheme string, t time.Time) string {
+	t = t.UTC()
+	switch scheme {
+	case PartitionYMD:
+		return t.Format("2006/01/02") + "/"
+	case PartitionHive:
+		return fmt.Sprintf("year=%04d/month=%02d/day=%02d/", t.Year(), int(t.Month()), t.Day())
+	}
+	return ""
+}
+
+// BuildKeyPrefix combines an explicit prefix with the date partition, e.g.
+// "uploads/year=2024/month=06/day=15/". The result is empty or ends with a slash.
+func BuildKeyPrefix(prefix, scheme string, t time.Time) (string, error) {
+	prefix = strings.Trim(prefix, "/")
+	for _, segment := range strings.Split(prefix, "/") {
+		if segment == "." || segment == ".." {
+			return "", fmt.Errorf("key prefix must not contain %q segments", segment)
+		}
+	}
+	if prefix != "" {
+		prefix += "/"
+	}
+	return prefix + PartitionPrefix(scheme, t), nil
+}
+
+// SplitObjectKey validates a client supplied object key and splits it into its prefix
+// (empty or ending with a slash) and file name. The key must lie under requiredPrefix
+// and must not contain empty, "." or ".." segments.
+func SplitObjectKey(key, requiredPrefix string) (prefix, name string, err error) {
+	segments := strings.Split(key, "/")
+	for _, segment := range segments {
+		if segment == "" || segment == "." || segment == ".." {
+			return "", "", fmt.Errorf("invalid object key: %q", key)
+		}
+	}
+	if requiredPrefix = strings.Trim(requiredPrefix, "/"); requiredPrefix != "" && !strings.HasPrefix(key, requiredPrefix+"/") {
+		return "", "", fmt.Errorf("object key must start with %s/", requiredPrefix)
+	}
+
+	name = segments[len(segments)-1]
+	return strings.TrimSuffix(key, name), name, nil
+}
@@ -0,0 +1,137 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math"
+	"strconv"
+)
+
+// Orientation labels reported for images and videos
+const (
+	OrientationSquare    = "square"
+	OrientationPortrait  = "portrait"
+	OrientationLandscape = "landscape"
+)
+
+// squareTolerance is how far the long side may exceed the short one, relative to it,
+// for a file to still be square, see SetSquareTolerance
+var squareTolerance = 0.05
+
+// SetSquareTolerance sets the tolerance used by ClassifyOrientation. It is meant to be
+// called once at startup from the loaded configuration.
+func SetSquareTolerance(tolerance float64) {
+	if tolerance >= 0 {
+		squareTolerance = tolerance
+	}
+}
+
+// ClassifyOrientation labels display dimensions as square, portrait (taller than wide)
+// or landscape (wider than tall). Sizes within the square tolerance of 1:1, e.g.
+// 1080x1050 with the default 0.05, count as square. Unknown sizes get "".
+func ClassifyOrientation(width, height int) string {
+	if width <= 0 || height <= 0 {
+		return ""
+	}
+	long, short := float64(max(width, height)), float64(min(width, height))
+	switch {
+	case long/short-1 <= squareTolerance:
+		return OrientationSquare
+	case height > width:
+		return OrientationPortrait
+	default:
+		return OrientationLandscape
+	}
+}
+
+// DisplayDimensions returns the size a file is shown at, swapping width and height
+// when it is rotated by 90 or 270 degrees
+func DisplayDimensions(width, height, rotation int) (int, int) {
+	if rotation%180 != 0 && rotation%90 == 0 {
+		return height, width
+	}
+	return width, height
+}
+
+// ImageOrientation classifies an image of the given stored size as it is shown, after
+// its EXIF orientation
+func ImageOrientation(data []byte, width, height int) string {
+	return ClassifyOrientation(DisplayDimensions(width, height, ExifRotation(data)))
+}
+
+// ExifRotation returns how many degrees a viewer rotates an image by to honour its EXIF
+// orientation. Only JPEGs carry EXIF in practice; other formats and images without an
+// orientation tag get 0. Mirrored orientations count like their rotated counterparts,
+// since mirroring doesn't change the size.
+func ExifRotation(data []byte) int {
+	if !isJPEG(data) {
+		return 0
+	}
+	rotation := 0
+	jpegSegments(data, func(marker byte, payload []byte) bool {
+		const exifHeader = "Exif\x00\x00"
+		if marker == 0xE1 && bytes.HasPrefix(payload, []byte(exifHeader)) {
+			switch exifOrientation(payload[len(exifHeader):]) {
+			case 3, 4:
+				rotation = 180
+			case 5, 6:
+				rotation = 90
+			case 7, 8:
+				rotation = 270
+			}
+			return false
+		}
+		return true
+	})
+	return rotation
+}
+
+// exifOrientation reads the Orientation tag (0x0112) from IFD0 of a TIFF structure,
+// returning 0 when it is missing
+func exifOrientation(tiff []byte) int {
+	if len(tiff) < 8 {
+		return 0
+	}
+	var order binary.ByteOrder
+	switch string(tiff[:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return 0
+	}
+	ifd := int(order.Uint32(tiff[4:]))
+	if ifd < 8 || ifd+2 > len(tiff) {
+		return 0
+	}
+	count := int(order.Uint16(tiff[ifd:]))
+	for i := 0; i < count; i++ {
+		entry := ifd + 2 + i*12
+		if entry+12 > len(tiff) {
+			return 0
+		}
+		// SHORT values are stored left-aligned in the value field
+		if order.Uint16(tiff[entry:]) == 0x0112 {
+			return int(order.Uint16(tiff[entry+8:]))
+		}
+	}
+	return 0
+}
+
+// Rotation returns the clockwise rotation in degrees players apply to the stream, from
+// its display matrix or, for files written by older muxers, its rotate tag
+func (s ProbeStream) Rotation() int {
+	degrees := 0
+	for _, sd := range s.SideDataList {
+		if sd.Rotation != 0 {
+			// The display matrix rotates counterclockwise
+			degrees = -int(math.Round(sd.Rotation))
+			break
+		}
+	}
+	if degrees == 0 {
+		degrees, _ = strconv.Atoi(s.Tags["rotate"])
+	}
+	return (degrees%360 + 360) % 360
+}
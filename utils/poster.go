@@ -0,0 +1,136 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/disintegration/imaging"
+	"github.com/sirupsen/logrus"
+)
+
+// PosterTime is where the poster frame is taken from when it isn't selected by score
+const PosterTime = 1.0
+
+// posterCandidates is how many frames SelectPosterFrame compares
+const posterCandidates = 5
+
+// ExtractFrame writes the frame at the given number of seconds into the video to
+// outputPath as a JPEG
+func ExtractFrame(inputPath, outputPath string, at float64) error {
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is not installed: %w", err)
+	}
+
+	cmd, done := mediaexec.Command(context.Background(), ffmpegPath,
+		"-ss", strconv.FormatFloat(at, 'f', 3, 64),
+		"-i", inputPath,
+		"-frames:v", "1",
+		"-q:v", "2",
+		"-y", outputPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if err := done(cmd.Run()); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("ffmpeg failed to extract frame: %w, stderr: %s", err, stderr.String())
+	}
+
+	// Seeking past the last frame succeeds without writing anything
+	if info, err := os.Stat(outputPath); err != nil || info.Size() == 0 {
+		return fmt.Errorf("no frame at %.3fs", at)
+	}
+	return nil
+}
+
+// FixedPosterTime returns PosterTime, or the middle of videos shorter than that
+func FixedPosterTime(duration float64) float64 {
+	if duration > 0 && duration <= PosterTime {
+		return duration / 2
+	}
+	return PosterTime
+}
+
+// SelectPosterFrame extracts frames spread over the video, writes the best one to
+// outputPath and returns its timestamp. Frames are scored by FrameScore so black
+// frames, fades and motion blur lose against detailed, well exposed ones.
+func SelectPosterFrame(inputPath, outputPath string, duration float64) (float64, error) {
+	if duration <= 0 {
+		return 0, fmt.Errorf("video duration is unknown")
+	}
+
+	bestScore, bestTime := -1.0, 0.0
+	for i := 1; i <= posterCandidates; i++ {
+		// Skip the very start and end, they are often black or a title card
+		at := duration * float64(i) / float64(posterCandidates+1)
+		candidatePath := filepath.Join(TempDir(), fmt.Sprintf("%s_poster_%d.jpg", filepath.Base(inputPath), i))
+		if err := ExtractFrame(inputPath, candidatePath, at); err != nil {
+			logrus.Warnf("Skipping poster candidate at %.3fs: %v", at, err)
+			continue
+		}
+
+		score, err := scoreFrameFile(candidatePath)
+		if err != nil || score <= bestScore {
+			if err != nil {
+				logrus.Warnf("Skipping poster candidate at %.3fs: %v", at, err)
+			}
+			os.Remove(candidatePath)
+			continue
+		}
+		logrus.Debugf("Poster candidate at %.3fs scored %.2f", at, score)
+
+		if err := os.Rename(candidatePath, outputPath); err != nil {
+			os.Remove(candidatePath)
+			return 0, fmt.Errorf("failed to keep poster candidate: %w", err)
+		}
+		bestScore, bestTime = score, at
+	}
+
+	if bestScore <= 0 {
+		os.Remove(outputPath)
+		return 0, fmt.Errorf("no usable poster frame among %d candidates", posterCandidates)
+	}
+	return bestTime, nil
+}
+
+func scoreFrameFile(path string) (float64, error) {
+	img, err := imaging.Open(path)
+	if err != nil {
+		return 0, fmt.Errorf("failed to decode frame: %w", err)
+	}
+	hist := Histogram(img, MaxHistogramBins)
+	return FrameScore(hist.Luminance, SharpnessScore(img)), nil
+}
+
+// FrameScore rates a frame from its luminance histogram and sharpness. Frames that are
+// nearly black or white score 0, otherwise the entropy of the luminance (how much
+// detail there is) is weighted by the log of the sharpness.
+func FrameScore(luminance []int, sharpness float64) float64 {
+	total, sum := 0, 0
+	for v, n := range luminance {
+		total += n
+		sum += v * n
+	}
+	if total == 0 {
+		return 0
+	}
+	mean := float64(sum) / float64(total) * 256 / float64(len(luminance))
+	if mean < 16 || mean > 240 {
+		return 0
+	}
+
+	entropy := 0.0
+	for _, n := range luminance {
+		if n > 0 {
+			p := float64(n) / float64(total)
+			entropy -= p * math.Log2(p)
+		}
+	}
+	return entropy * math.Log1p(sharpness)
+}
@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/sirupsen/logrus"
+)
+
+// Limits applied to animated previews so they stay small enough for listings
+const (
+	PreviewMaxLength = 6.0             // seconds
+	PreviewMaxWidth  = 480             // pixels
+	PreviewFPS       = 10              // frames per second
+	PreviewMaxBytes  = 5 * 1024 * 1024 // 5MB
+)
+
+// PreviewOptions controls animated preview generation
+type PreviewOptions struct {
+	Format string  // "webp" or "gif"
+	Start  float64 // seconds into the video
+	Length float64 // seconds of video to include
+	Width  int     // output width, height keeps the aspect ratio
+}
+
+// ValidPreviewFormat reports whether format is a supported animated preview format
+func ValidPreviewFormat(format string) bool {
+	return format == "webp" || format == "gif"
+}
+
+// GenerateAnimatedPreview extracts a short clip from the video and encodes it as a
+// looping animated WebP or GIF at outputPath
+func GenerateAnimatedPreview(inputPath, outputPath string, opts PreviewOptions) error {
+	if !ValidPreviewFormat(opts.Format) {
+		return fmt.Errorf("unsupported preview format: %s", opts.Format)
+	}
+	if opts.Length <= 0 || opts.Length > PreviewMaxLength {
+		opts.Length = PreviewMaxLength
+	}
+	if opts.Start < 0 {
+		opts.Start = 0
+	}
+	if opts.Width <= 0 || opts.Width > PreviewMaxWidth {
+		opts.Width = PreviewMaxWidth
+	}
+
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is not installed: %w", err)
+	}
+
+	maxFrames := int(opts.Length * PreviewFPS)
+	filter := fmt.Sprintf("fps=%d,scale=%d:-2:flags=lanczos", PreviewFPS, opts.Width)
+
+	args := []string{
+		"-ss", strconv.FormatFloat(opts.Start, 'f', 3, 64),
+		"-t", strconv.FormatFloat(opts.Length, 'f', 3, 64),
+		"-i", inputPath,
+		"-an",
+		"-frames:v", strconv.Itoa(maxFrames),
+		"-loop", "0",
+	}
+	if opts.Format == "gif" {
+		// Generate a palette on the fly for much better GIF colors
+		args = append(args, "-vf", filter+",split[s0][s1];[s0]palettegen[p];[s1][p]paletteuse")
+	} else {
+		args = append(args, "-vf", filter, "-c:v", "libwebp", "-quality", "70")
+	}
+	args = append(args, "-y", outputPath)
+
+	cmd, done := mediaexec.Command(context.Background(), ffmpegPath, args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	logrus.Infof("Generating %s preview: %s", opts.Format, cmd.String())
+	if err := done(cmd.Run()); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("ffmpeg failed to generate preview: %w, stderr: %s", err, stderr.String())
+	}
+
+	info, err := os.Stat(outputPath)
+	if err != nil {
+		return fmt.Errorf("preview file was not created: %w", err)
+	}
+	if info.Size() == 0 {
+		return fmt.Errorf("preview file has zero size")
+	}
+	if info.Size() > PreviewMaxBytes {
+		return fmt.Errorf("preview is too large (%d bytes, maximum is %d)", info.Size(), PreviewMaxBytes)
+	}
+
+	return nil
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/asset_upload_service/mediaexec"
+)
+
+// ProbeStream is the subset of an ffprobe stream entry the service uses
+type ProbeStream struct {
+	Index         int               `json:"index"`
+	CodecType     string            `json:"codec_type"`
+	CodecName     string            `json:"codec_name"`
+	Width         int               `json:"width"`
+	Height        int               `json:"height"`
+	Channels      int               `json:"channels"`
+	ChannelLayout string            `json:"channel_layout"`
+	SampleRate    string            `json:"sample_rate"`
+	BitRate       string            `json:"bit_rate"`
+	RFrameRate    string            `json:"r_frame_rate"`
+	PixFmt        string            `json:"pix_fmt"`
+	Duration      string            `json:"duration"`
+	Tags          map[string]string `json:"tags"`
+	Disposition   map[string]int    `json:"disposition"`
+	SideDataList  []ProbeSideData   `json:"side_data_list"`
+}
+
+// ProbeSideData is the subset of an ffprobe stream side data entry the service uses
+type ProbeSideData struct {
+	SideDataType string  `json:"side_data_type"`
+	Rotation     float64 `json:"rotation"`
+}
+
+// ProbeFormat is the subset of the ffprobe format section the service uses
+type ProbeFormat struct {
+	FormatName string `json:"format_name"`
+	Duration   string `json:"duration"`
+	BitRate    string `json:"bit_rate"`
+	Size       string `json:"size"`
+}
+
+// ProbeResult is the parsed output of ffprobe -show_format -show_streams
+type ProbeResult struct {
+	Streams []ProbeStream `json:"streams"`
+	Format  ProbeFormat   `json:"format"`
+}
+
+// ProbeMedia runs ffprobe on filePath and parses its stream and format information
+func ProbeMedia(filePath string) (*ProbeResult, error) {
+	out, err := runFFprobe(filePath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to probe media: %w", err)
+	}
+
+	var result ProbeResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return nil, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	return &result, nil
+}
+
+// runFFprobe returns the format and streams of filePath as ffprobe JSON
+func runFFprobe(filePath string) ([]byte, error) {
+	cmd, done := mediaexec.Command(context.Background(), "ffprobe",
+		"-of", "json", "-show_format", "-show_streams", filePath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err = done(err); err != nil {
+		return nil, fmt.Errorf("[%s] %w", stderr.String(), err)
+	}
+	return out, nil
+}
+
+// StreamsOfType returns the streams with the given codec type ("video", "audio", "subtitle")
+func (p *ProbeResult) StreamsOfType(codecType string) []ProbeStream {
+	var streams []ProbeStream
+	for _, s := range p.Streams {
+		if s.CodecType == codecType {
+			streams = append(streams, s)
+		}
+	}
+	return streams
+}
+
+// HasVideoStream reports whether the file contains a video stream. Cover art
+// attached to audio files is reported as a video stream but isn't one.
+func (p *ProbeResult) HasVideoStream() bool {
+	return p.VideoStream() != nil
+}
+
+// BitRate returns the overall bitrate in bits per second, or 0 when unknown
+func (p *ProbeResult) BitRate() int64 {
+	n, _ := strconv.ParseInt(p.Format.BitRate, 10, 64)
+	return n
+}
+
+// Duration returns the container duration in seconds, or 0 when unknown
+func (p *ProbeResult) Duration() float64 {
+	d, _ := strconv.ParseFloat(p.Format.Duration, 64)
+	return d
+}
+
+// VideoStream returns the first real video stream, or nil when there is none
+func (p *ProbeResult) VideoStream() *ProbeStream {
+	for i, s := range p.Streams {
+		if s.CodecType == "video" && s.Disposition["attached_pic"] == 0 {
+			return &p.Streams[i]
+		}
+	}
+	return nil
+}
+
+// NormalizedStreamCount returns how many streams remain when only the first video
+// and first audio stream are kept
+func (p *ProbeResult) NormalizedStreamCount() int {
+	n := 0
+	if len(p.StreamsOfType("video")) > 0 {
+		n++
+	}
+	if len(p.StreamsOfType("audio")) > 0 {
+		n++
+	}
+	return n
+}
+
+// FrameRate returns the stream's frame rate in frames per second, or 0 when unknown
+func (s ProbeStream) FrameRate() float64 {
+	num, den, ok := strings.Cut(s.RFrameRate, "/")
+	n, err := strconv.ParseFloat(num, 64)
+	if err != nil {
+		return 0
+	}
+	if !ok {
+		return n
+	}
+	d, err := strconv.ParseFloat(den, 64)
+	if err != nil || d == 0 {
+		return 0
+	}
+	return n / d
+}
+
+// Layout returns the audio stream's channel layout (e.g. "5.1(side)"), or the number
+// of channels when ffprobe doesn't name one
+func (s ProbeStream) Layout() string {
+	if s.ChannelLayout != "" {
+		return s.ChannelLayout
+	}
+	if s.Channels == 1 {
+		return "mono"
+	}
+	return fmt.Sprintf("%d channels", s.Channels)
+}
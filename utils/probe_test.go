@@ -0,0 +1,85 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/asset_upload_service/mediaexec"
+)
+
+// audioOnlyProbe is what ffprobe -show_format -show_streams reports for an MP4 that
+// only holds an AAC track, trimmed to the fields ProbeResult reads
+const audioOnlyProbe = `{
+	"streams": [{
+		"index": 0,
+		"codec_name": "aac",
+		"codec_type": "audio",
+		"sample_rate": "44100",
+		"channels": 2,
+		"channel_layout": "stereo",
+		"duration": "2.000000",
+		"disposition": {"default": 1, "attached_pic": 0}
+	}],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "2.000000", "bit_rate": "130000"}
+}`
+
+// coverArtProbe is an M4A with embedded cover art, which ffprobe lists as a video stream
+const coverArtProbe = `{
+	"streams": [
+		{"index": 0, "codec_name": "aac", "codec_type": "audio", "disposition": {"attached_pic": 0}},
+		{"index": 1, "codec_name": "mjpeg", "codec_type": "video", "width": 600, "height": 600, "disposition": {"attached_pic": 1}}
+	],
+	"format": {"format_name": "mov,mp4,m4a,3gp,3g2,mj2", "duration": "180.5"}
+}`
+
+func TestProbeResultAudioOnly(t *testing.T) {
+	for name, output := range map[string]string{"audio only": audioOnlyProbe, "cover art": coverArtProbe} {
+		var probe ProbeResult
+		if err := json.Unmarshal([]byte(output), &probe); err != nil {
+			t.Fatalf("%s: %v", name, err)
+		}
+		if probe.HasVideoStream() {
+			t.Errorf("%s: HasVideoStream() = true, want false", name)
+		}
+		if len(probe.StreamsOfType("audio")) != 1 {
+			t.Errorf("%s: want one audio stream, got %d", name, len(probe.StreamsOfType("audio")))
+		}
+	}
+}
+
+// audioOnlyMP4 writes a one second MP4 holding only an AAC track with ffmpeg, skipping
+// the test when ffmpeg or ffprobe aren't installed
+func audioOnlyMP4(t *testing.T) string {
+	t.Helper()
+	for _, tool := range []string{"ffmpeg", "ffprobe"} {
+		if _, err := exec.LookPath(tool); err != nil {
+			t.Skipf("%s is not installed", tool)
+		}
+	}
+	path := filepath.Join(t.TempDir(), "audio.mp4")
+	cmd, done := mediaexec.Command(context.Background(), "ffmpeg", "-v", "error",
+		"-f", "lavfi", "-i", "anullsrc=r=44100:cl=stereo", "-t", "1", "-c:a", "aac", "-y", path)
+	if out, err := cmd.CombinedOutput(); done(err) != nil {
+		t.Fatalf("failed to create audio-only MP4: %v: %s", err, out)
+	}
+	return path
+}
+
+func TestAudioOnlyMP4(t *testing.T) {
+	path := audioOnlyMP4(t)
+
+	probe, err := ProbeMedia(path)
+	if err != nil {
+		t.Fatalf("ProbeMedia failed: %v", err)
+	}
+	if probe.HasVideoStream() {
+		t.Error("HasVideoStream() = true for an audio-only MP4")
+	}
+	if _, err := GetVideoMetadata(path); !errors.Is(err, ErrNoVideoStream) {
+		t.Errorf("GetVideoMetadata error = %v, want ErrNoVideoStream", err)
+	}
+}
@@ -0,0 +1,286 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+var (
+	// ErrRemoteTimeout is returned when the remote source did not respond in time
+	ErrRemoteTimeout = errors.New("timed out downloading remote file")
+	// ErrRemoteNotFound is returned when the remote source responds with 404
+	ErrRemoteNotFound = errors.New("remote file not found")
+	// ErrInsecureSource is returned for plaintext source URLs when HTTPS is required
+	ErrInsecureSource = errors.New("source URL must use https")
+	// ErrSourceTooLarge is returned when a remote file exceeds FetchOptions.MaxBytes
+	ErrSourceTooLarge = errors.New("remote file exceeds the maximum download size")
+	// ErrBlockedAddress is returned when a source resolves to a loopback, private,
+	// link-local or other internal address
+	ErrBlockedAddress = errors.New("source address is not allowed")
+)
+
+// ValidateSourceURL checks that rawURL is an absolute http(s) URL that remote files
+// may be fetched from. Plaintext URLs are rejected when requireHTTPS is set.
+func ValidateSourceURL(rawURL string, requireHTTPS bool) error {
+	u, err := url.ParseRequestURI(rawURL)
+	if err != nil || u.Host == "" {
+		return fmt.Errorf("invalid URL format")
+	}
+
+	switch strings.ToLower(u.Scheme) {
+	case "https":
+	case "http":
+		if requireHTTPS {
+			return ErrInsecureSource
+		}
+	default:
+		return fmt.Errorf("unsupported URL scheme: %s", u.Scheme)
+	}
+
+	return nil
+}
+
+// FetchOptions controls how remote files are downloaded
+type FetchOptions struct {
+	Timeout    time.Duration
+	MaxRetries int
+	Backoff    time.Duration
+	// MaxBytes is the most that is read from a response, 0 means no limit
+	MaxBytes int64
+	// AllowPrivateNetworks lets sources resolve to internal addresses, which are
+	// refused otherwise so URLs can't reach the host, its network or cloud metadata
+	AllowPrivateNetworks bool
+}
+
+// blockedPrefixes are ranges that aren't reachable on the internet but aren't covered
+// by the netip.Addr checks: "this network", carrier-grade NAT (used for metadata by
+// some clouds) and benchmarking
+var blockedPrefixes = []netip.Prefix{
+	netip.MustParsePrefix("0.0.0.0/8"),
+	netip.MustParsePrefix("100.64.0.0/10"),
+	netip.MustParsePrefix("198.18.0.0/15"),
+}
+
+// blockedAddress reports whether addr is loopback, private (RFC 1918, fc00::/7),
+// link-local (including 169.254.169.254), multicast, unspecified or in blockedPrefixes
+func blockedAddress(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	if !addr.IsGlobalUnicast() || addr.IsPrivate() {
+		return true
+	}
+	for _, prefix := range blockedPrefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkDialAddress is a net.Dialer Control function refusing blocked addresses. It
+// runs for every connection after name resolution, so redirects and DNS answers that
+// change between requests are checked too.
+func checkDialAddress(network, address string, _ syscall.RawConn) error {
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, address)
+	}
+	addr, err := netip.ParseAddr(host)
+	if err != nil || blockedAddress(addr) {
+		return fmt.Errorf("%w: %s", ErrBlockedAddress, host)
+	}
+	return nil
+}
+
+// Transports of remote downloads, shared so connections are reused. Sources are dialed
+// directly, a proxy would be the only address checked.
+var (
+	publicTransport  = newFetchTransport(checkDialAddress, nil)
+	privateTransport = newFetchTransport(nil, http.ProxyFromEnvironment)
+)
+
+func newFetchTransport(control func(network, address string, c syscall.RawConn) error, proxy func(*http.Request) (*url.URL, error)) *http.Transport {
+	dialer := &net.Dialer{
+		Timeout:   30 * time.Second,
+		KeepAlive: 30 * time.Second,
+		Control:   control,
+	}
+	return &http.Transport{
+		Proxy:                 proxy,
+		DialContext:           dialer.DialContext,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: time.Second,
+	}
+}
+
+// fetchClient returns the client remote files are downloaded with
+func fetchClient(opts FetchOptions) *http.Client {
+	transport := publicTransport
+	if opts.AllowPrivateNetworks {
+		transport = privateTransport
+	}
+	return &http.Client{Timeout: opts.Timeout, Transport: transport}
+}
+
+// fetchBackoff is the delay before the first retry, doubled on every attempt
+const fetchBackoff = 500 * time.Millisecond
+
+// maxRetryAfter caps the delay a Retry-After header can ask for, so a hostile or
+// misconfigured origin can't hold the request for hours
+const maxRetryAfter = 30 * time.Second
+
+// retryableError marks a failed attempt that may succeed when retried
+type retryableError struct {
+	err        error
+	retryAfter time.Duration
+}
+
+func (e *retryableError) Error() string { return e.err.Error() }
+func (e *retryableError) Unwrap() error { return e.err }
+
+// DownloadToFile downloads sourceURL into dst, retrying transient failures (network errors,
+// 5xx and 429) with exponential backoff. rangeHeader is sent as the Range header when
+// set. It returns true when the server answered with partial content.
+func DownloadToFile(ctx context.Context, sourceURL, rangeHeader string, dst *os.File, opts FetchOptions) (bool, error) {
+	client := fetchClient(opts)
+
+	var lastErr error
+	for attempt := 0; attempt <= opts.MaxRetries; attempt++ {
+		if attempt > 0 {
+			backoff := opts.Backoff
+			if backoff <= 0 {
+				backoff = fetchBackoff
+			}
+			wait := backoff << (attempt - 1)
+			var re *retryableError
+			if errors.As(lastErr, &re) && re.retryAfter > 0 {
+				wait = min(re.retryAfter, maxRetryAfter)
+				if opts.Timeout > 0 {
+					wait = min(wait, opts.Timeout)
+				}
+			}
+			logrus.Warnf("Retrying download of %s in %s (attempt %d/%d): %v", sourceURL, wait, attempt, opts.MaxRetries, lastErr)
+
+			select {
+			case <-ctx.Done():
+				return false, ctx.Err()
+			case <-time.After(wait):
+			}
+
+			// Discard whatever the failed attempt managed to write
+			if err := dst.Truncate(0); err != nil {
+				return false, fmt.Errorf("failed to reset download file: %w", err)
+			}
+			if _, err := dst.Seek(0, io.SeekStart); err != nil {
+				return false, fmt.Errorf("failed to reset download file: %w", err)
+			}
+		}
+
+		partial, err := downloadOnce(ctx, client, sourceURL, rangeHeader, dst, opts.MaxBytes)
+		if err == nil {
+			return partial, nil
+		}
+		lastErr = err
+
+		var re *retryableError
+		if !errors.As(err, &re) {
+			return false, err
+		}
+	}
+
+	if isTimeout(lastErr) {
+		return false, fmt.Errorf("%w after %d attempts: %v", ErrRemoteTimeout, opts.MaxRetries+1, lastErr)
+	}
+	return false, fmt.Errorf("failed to download video after %d attempts: %w", opts.MaxRetries+1, lastErr)
+}
+
+func downloadOnce(ctx context.Context, client *http.Client, sourceURL, rangeHeader string, dst io.Writer, maxBytes int64) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sourceURL, nil)
+	if err != nil {
+		return false, fmt.Errorf("failed to create request: %w", err)
+	}
+	if rangeHeader != "" {
+		req.Header.Set("Range", rangeHeader)
+	}
+
+	resp, err := client.Do(req)
+	if errors.Is(err, ErrBlockedAddress) {
+		return false, ErrBlockedAddress
+	}
+	if err != nil {
+		// Network level failures are considered transient
+		return false, &retryableError{err: fmt.Errorf("failed to download video: %w", err)}
+	}
+	defer resp.Body.Close()
+
+	switch {
+	case resp.StatusCode == http.StatusOK || resp.StatusCode == http.StatusPartialContent:
+	case resp.StatusCode == http.StatusNotFound:
+		return false, ErrRemoteNotFound
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return false, &retryableError{
+			err:        fmt.Errorf("failed to download video, status code: %d", resp.StatusCode),
+			retryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	default:
+		return false, fmt.Errorf("failed to download video, status code: %d", resp.StatusCode)
+	}
+
+	// Sources too large for the temp disk are refused up front when they declare their
+	// size, and cut off once they pass it when they don't
+	body := io.Reader(resp.Body)
+	if maxBytes > 0 {
+		if resp.ContentLength > maxBytes {
+			return false, fmt.Errorf("%w: %d bytes, the limit is %d", ErrSourceTooLarge, resp.ContentLength, maxBytes)
+		}
+		body = io.LimitReader(resp.Body, maxBytes+1)
+	}
+	n, err := io.Copy(dst, body)
+	if err != nil {
+		return false, &retryableError{err: fmt.Errorf("failed to save video file: %w", err)}
+	}
+	if maxBytes > 0 && n > maxBytes {
+		return false, fmt.Errorf("%w: more than %d bytes", ErrSourceTooLarge, maxBytes)
+	}
+
+	return resp.StatusCode == http.StatusPartialContent, nil
+}
+
+// parseRetryAfter understands both the delay-seconds and HTTP-date forms
+func parseRetryAfter(v string) time.Duration {
+	if v == "" {
+		return 0
+	}
+	if secs, err := strconv.Atoi(v); err == nil && secs >= 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if t, err := http.ParseTime(v); err == nil {
+		if d := time.Until(t); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
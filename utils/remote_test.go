@@ -0,0 +1,133 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestBlockedAddress(t *testing.T) {
+	tests := []struct {
+		addr    string
+		blocked bool
+	}{
+		{"127.0.0.1", true},
+		{"::1", true},
+		{"10.1.2.3", true},
+		{"172.16.0.1", true},
+		{"192.168.1.1", true},
+		{"169.254.169.254", true},
+		{"100.100.100.200", true},
+		{"0.0.0.0", true},
+		{"fe80::1", true},
+		{"fd00:ec2::254", true},
+		{"::ffff:127.0.0.1", true},
+		{"224.0.0.1", true},
+		{"93.184.216.34", false},
+		{"2606:2800:220:1:248:1893:25c8:1946", false},
+	}
+	for _, tt := range tests {
+		if got := blockedAddress(netip.MustParseAddr(tt.addr)); got != tt.blocked {
+			t.Errorf("blockedAddress(%s) = %v, want %v", tt.addr, got, tt.blocked)
+		}
+	}
+}
+
+func TestDownloadToFileRefusesPrivateAddresses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("video"))
+	}))
+	defer server.Close()
+
+	dst, err := os.Create(filepath.Join(t.TempDir(), "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	opts := FetchOptions{Timeout: 5 * time.Second, MaxRetries: 2, Backoff: time.Millisecond}
+	if _, err := DownloadToFile(context.Background(), server.URL, "", dst, opts); !errors.Is(err, ErrBlockedAddress) {
+		t.Fatalf("DownloadToFile(%s) error = %v, want ErrBlockedAddress", server.URL, err)
+	}
+
+	opts.AllowPrivateNetworks = true
+	if _, err := DownloadToFile(context.Background(), server.URL, "", dst, opts); err != nil {
+		t.Fatalf("DownloadToFile(%s) with private networks allowed failed: %v", server.URL, err)
+	}
+}
+
+func TestCheckDialAddress(t *testing.T) {
+	// Dialed addresses are already resolved, so host names never get here
+	for _, address := range []string{"127.0.0.1:8080", "[::1]:443", "169.254.169.254:80", "localhost:80"} {
+		if err := checkDialAddress("tcp", address, nil); !errors.Is(err, ErrBlockedAddress) {
+			t.Errorf("checkDialAddress(%s) error = %v, want ErrBlockedAddress", address, err)
+		}
+	}
+	if err := checkDialAddress("tcp", "93.184.216.34:443", nil); err != nil {
+		t.Errorf("checkDialAddress(93.184.216.34:443) error = %v, want nil", err)
+	}
+}
+
+func TestDownloadToFileCapsRetryAfter(t *testing.T) {
+	attempts := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts++
+		if attempts == 1 {
+			w.Header().Set("Retry-After", "3600")
+			w.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		w.Write([]byte("video"))
+	}))
+	defer server.Close()
+
+	dst, err := os.Create(filepath.Join(t.TempDir(), "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	// The wait is capped at the timeout instead of the hour the server asked for
+	opts := FetchOptions{Timeout: 100 * time.Millisecond, MaxRetries: 1, AllowPrivateNetworks: true}
+	start := time.Now()
+	if _, err := DownloadToFile(context.Background(), server.URL, "", dst, opts); err != nil {
+		t.Fatalf("DownloadToFile failed: %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("DownloadToFile took %s, Retry-After wasn't capped", elapsed)
+	}
+	if attempts != 2 {
+		t.Errorf("got %d attempts, want 2", attempts)
+	}
+}
+
+func TestDownloadToFileRetryWaitStopsOnCancel(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Retry-After", "3600")
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	dst, err := os.Create(filepath.Join(t.TempDir(), "download"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	opts := FetchOptions{Timeout: time.Minute, MaxRetries: 3, AllowPrivateNetworks: true}
+	start := time.Now()
+	if _, err := DownloadToFile(ctx, server.URL, "", dst, opts); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("DownloadToFile error = %v, want context.DeadlineExceeded", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("DownloadToFile took %s after its context ended", elapsed)
+	}
+}
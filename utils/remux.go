@@ -0,0 +1,69 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/sirupsen/logrus"
+)
+
+// CanRemux reports whether a video can be moved into an MP4 container without
+// re-encoding: H.264 (yuv420p) video and audio MP4 can hold. When it can't, the
+// returned reason says why.
+func CanRemux(probe *ProbeResult) (bool, string) {
+	video := probe.VideoStream()
+	if video == nil {
+		return false, "no video stream"
+	}
+	if video.CodecName != "h264" {
+		return false, "video codec is " + video.CodecName
+	}
+	if video.PixFmt != "yuv420p" {
+		return false, "pixel format is " + video.PixFmt
+	}
+	if !MP4AudioCompatible(probe) {
+		return false, "audio codec can't be stored in MP4"
+	}
+	return true, ""
+}
+
+// RemuxToMP4 copies the video and audio of inputPath into an MP4 container without
+// re-encoding, cut to MaxVideoDuration like transcoded videos. Subtitle and data
+// streams are dropped. Only Faststart, NormalizeStreams and Log of opts apply.
+func RemuxToMP4(inputPath string, opts VideoProcessOptions) (string, error) {
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return "", fmt.Errorf("ffmpeg is not installed: %w", err)
+	}
+
+	outputPath := strings.TrimSuffix(inputPath, filepath.Ext(inputPath)) + "_processed.mp4"
+	args := []string{"-i", inputPath, "-t", fmt.Sprint(MaxVideoDuration)}
+	if opts.NormalizeStreams {
+		for _, m := range normalizeStreamMaps {
+			args = append(args, "-map", m)
+		}
+	}
+	args = append(args, "-c", "copy", "-sn", "-dn")
+	if opts.Faststart {
+		args = append(args, "-movflags", "+faststart")
+	}
+	args = append(args, "-y", outputPath)
+
+	logrus.Infof("Remuxing %s to MP4: %v", inputPath, args)
+	cmd, done := mediaexec.Command(context.Background(), ffmpegPath, args...)
+	output, err := cmd.CombinedOutput()
+	err = done(err)
+	if opts.Log != nil {
+		fmt.Fprintf(opts.Log, "$ %s %s\n%s", ffmpegPath, strings.Join(args, " "), output)
+	}
+	if err != nil {
+		os.Remove(outputPath)
+		return "", fmt.Errorf("ffmpeg failed to remux: %w, output: %s", err, output)
+	}
+	return outputPath, nil
+}
@@ -0,0 +1,107 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/sirupsen/logrus"
+)
+
+// streamProbeLimit is how much of the response is piped into ffprobe. It matches the
+// range the temp file path downloads first, so both paths transfer the same amount.
+const streamProbeLimit = 1 << 20
+
+// Published on /debug/vars to compare how often probing avoided the disk
+var (
+	streamedProbes = expvar.NewInt("remote_probes_streamed")
+	tempFileProbes = expvar.NewInt("remote_probes_temp_file")
+	tempFileBytes  = expvar.NewInt("remote_probe_temp_file_bytes")
+)
+
+// countingReader counts the bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// ProbeVideoStream reads the dimensions of the first video stream of sourceURL by
+// piping the start of the response straight into ffprobe, without a temp file.
+// This works for formats that can be probed sequentially (faststart MP4, WebM,
+// MPEG-TS, ...). MP4 files with the moov atom at the end need seeking and fail
+// here, callers should fall back to downloading to disk.
+func ProbeVideoStream(ctx context.Context, sourceURL string, opts FetchOptions) (Dimensions, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", sourceURL, nil)
+	if err != nil {
+		return Dimensions{}, fmt.Errorf("failed to create request: %w", err)
+	}
+	req.Header.Set("Range", fmt.Sprintf("bytes=0-%d", streamProbeLimit-1))
+
+	resp, err := fetchClient(opts).Do(req)
+	if errors.Is(err, ErrBlockedAddress) {
+		return Dimensions{}, ErrBlockedAddress
+	}
+	if err != nil {
+		return Dimensions{}, fmt.Errorf("failed to download video: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK, http.StatusPartialContent:
+	case http.StatusNotFound:
+		return Dimensions{}, ErrRemoteNotFound
+	default:
+		return Dimensions{}, fmt.Errorf("failed to download video, status code: %d", resp.StatusCode)
+	}
+
+	limit := int64(streamProbeLimit)
+	if opts.MaxBytes > 0 {
+		limit = min(limit, opts.MaxBytes)
+	}
+	body := &countingReader{r: io.LimitReader(resp.Body, limit)}
+	cmd, done := mediaexec.Command(ctx, "ffprobe", "-v", "error",
+		"-select_streams", "v:0",
+		"-show_entries", "stream=width,height,duration:stream_tags=rotate:stream_side_data=rotation:format=duration",
+		"-of", "json",
+		"-i", "pipe:0")
+	cmd.Stdin = body
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	out, err := cmd.Output()
+	if err = done(err); err != nil {
+		return Dimensions{}, fmt.Errorf("ffprobe failed on streamed input: %w, stderr: %s", err, stderr.String())
+	}
+
+	var result ProbeResult
+	if err := json.Unmarshal(out, &result); err != nil {
+		return Dimensions{}, fmt.Errorf("failed to parse ffprobe output: %w", err)
+	}
+	if len(result.Streams) == 0 {
+		return Dimensions{}, ErrNoVideoStream
+	}
+
+	stream := result.Streams[0]
+	duration, err := strconv.ParseFloat(stream.Duration, 64)
+	if err != nil {
+		// Matroska and friends only report the duration on the container
+		duration = result.Duration()
+	}
+
+	logrus.Infof("Probed %s from a %d byte stream without a temp file", sourceURL, body.n)
+	streamedProbes.Add(1)
+	return Dimensions{Width: stream.Width, Height: stream.Height, Duration: duration, Rotation: stream.Rotation()}, nil
+}
@@ -0,0 +1,217 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubFFprobe stands in for ffprobe when it isn't installed. Like the real one it
+// reads streamed input sequentially, so an MP4 only probes from a pipe when the moov
+// atom comes before the media data, and it fails on files without a moov atom. Files
+// whose moov holds a sound handler but no video one have no video stream.
+const stubFFprobe = `for input; do :; done
+if [ "$input" = pipe:0 ]; then
+	input=$(mktemp)
+	trap 'rm -f "$input"' EXIT
+	cat > "$input"
+	streamed=1
+fi
+moov=$(grep -abo moov "$input" | head -n 1 | cut -d: -f1)
+mdat=$(grep -abo mdat "$input" | head -n 1 | cut -d: -f1)
+[ -n "$moov" ] || exit 1
+if [ -n "$streamed" ] && [ -n "$mdat" ] && [ "$mdat" -lt "$moov" ]; then exit 1; fi
+if ! grep -q vide "$input"; then
+	case "$*" in *csv*) ;; *) echo '{"streams":[]}' ;; esac
+	exit 0
+fi
+case "$*" in
+*csv*) echo 320,240,2.000000 ;;
+*) echo '{"streams":[{"codec_type":"video","width":320,"height":240,"duration":"2.000000"}],"format":{"duration":"2.000000"}}' ;;
+esac
+`
+
+// mp4Box encodes an MP4 box of the given type around payload
+func mp4Box(boxType string, payload []byte) []byte {
+	box := binary.BigEndian.AppendUint32(nil, uint32(8+len(payload)))
+	return append(append(box, boxType...), payload...)
+}
+
+// probeFixtures returns a faststart MP4, the same video with the moov atom at the
+// end and a faststart audio-only MP4, each larger than streamProbeLimit. They are encoded by
+// ffmpeg when it is installed; otherwise they are box skeletons probed by
+// stubFFprobe. Either way the video is 320x240.
+func probeFixtures(tb testing.TB) (faststart, moovAtEnd, audioOnly []byte) {
+	tb.Helper()
+	_, ffmpegErr := exec.LookPath("ffmpeg")
+	_, ffprobeErr := exec.LookPath("ffprobe")
+	if ffmpegErr == nil && ffprobeErr == nil {
+		dir := tb.TempDir()
+		encode := func(name string, args ...string) []byte {
+			path := filepath.Join(dir, name)
+			args = append(append([]string{"-v", "error"}, args...), "-y", path)
+			if out, err := exec.Command("ffmpeg", args...).CombinedOutput(); err != nil {
+				tb.Fatalf("failed to create %s: %v: %s", name, err, out)
+			}
+			data, err := os.ReadFile(path)
+			if err != nil {
+				tb.Fatal(err)
+			}
+			return data
+		}
+		video := []string{"-f", "lavfi", "-i", "testsrc2=size=320x240:rate=30", "-t", "10", "-c:v", "mpeg4", "-b:v", "4M"}
+		faststart = encode("faststart.mp4", append(video, "-movflags", "+faststart")...)
+		moovAtEnd = encode("moov_at_end.mp4", video...)
+		audioOnly = encode("audio.mp4", "-f", "lavfi", "-i", "anoisesrc=r=48000", "-t", "10", "-c:a", "aac", "-b:a", "1M", "-movflags", "+faststart")
+		return faststart, moovAtEnd, audioOnly
+	}
+
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		tb.Skip("neither ffprobe nor sh is installed")
+	}
+	bin := tb.TempDir()
+	if err := os.WriteFile(filepath.Join(bin, "ffprobe"), []byte("#!"+sh+"\n"+stubFFprobe), 0o755); err != nil {
+		tb.Fatal(err)
+	}
+	tb.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	ftyp := mp4Box("ftyp", []byte("isom\x00\x00\x02\x00isomiso2mp41"))
+	mdat := mp4Box("mdat", make([]byte, 3*streamProbeLimit))
+	video := mp4Box("moov", mp4Box("trak", mp4Box("hdlr", []byte("\x00\x00\x00\x00\x00\x00\x00\x00vide"))))
+	audio := mp4Box("moov", mp4Box("trak", mp4Box("hdlr", []byte("\x00\x00\x00\x00\x00\x00\x00\x00soun"))))
+	faststart = bytes.Join([][]byte{ftyp, video, mdat}, nil)
+	moovAtEnd = bytes.Join([][]byte{ftyp, mdat, video}, nil)
+	audioOnly = bytes.Join([][]byte{ftyp, audio, mdat}, nil)
+	return faststart, moovAtEnd, audioOnly
+}
+
+// videoServer serves data with range support, counting requests and bytes sent
+type videoServer struct {
+	*httptest.Server
+	requests atomic.Int64
+	sent     atomic.Int64
+}
+
+// countingWriter counts the body bytes written to a response
+type countingWriter struct {
+	http.ResponseWriter
+	n *atomic.Int64
+}
+
+func (w countingWriter) Write(p []byte) (int, error) {
+	n, err := w.ResponseWriter.Write(p)
+	w.n.Add(int64(n))
+	return n, err
+}
+
+func newVideoServer(tb testing.TB, data []byte) *videoServer {
+	s := &videoServer{}
+	s.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.requests.Add(1)
+		http.ServeContent(countingWriter{w, &s.sent}, r, "video.mp4", time.Time{}, bytes.NewReader(data))
+	}))
+	tb.Cleanup(s.Close)
+	return s
+}
+
+// probeCounters are the /debug/vars counters of the probe paths
+type probeCounters struct {
+	streamed, tempFile, tempFileBytes int64
+}
+
+func readProbeCounters() probeCounters {
+	return probeCounters{streamedProbes.Value(), tempFileProbes.Value(), tempFileBytes.Value()}
+}
+
+var probeTestOptions = FetchOptions{Timeout: 30 * time.Second, AllowPrivateNetworks: true}
+
+func TestGetVideoAspectRatioFromURLProbePaths(t *testing.T) {
+	faststart, moovAtEnd, _ := probeFixtures(t)
+	tests := []struct {
+		name string
+		data []byte
+		// want is the change of the counters
+		want probeCounters
+		// maxSent bounds what the server sends
+		maxSent int
+	}{
+		// Piped into ffprobe, nothing touches the disk
+		{"faststart", faststart, probeCounters{streamed: 1}, streamProbeLimit},
+		// The stream probe and the first 1MB on disk fail, the whole file is fetched
+		{"moov at end", moovAtEnd, probeCounters{tempFile: 1, tempFileBytes: int64(len(moovAtEnd))}, 2*streamProbeLimit + 1 + len(moovAtEnd)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := newVideoServer(t, tt.data)
+			before := readProbeCounters()
+
+			ratio, err := GetVideoAspectRatioFromURL(context.Background(), server.URL, probeTestOptions)
+			if err != nil {
+				t.Fatalf("GetVideoAspectRatioFromURL: %v", err)
+			}
+			if ratio.Width != 320 || ratio.Height != 240 {
+				t.Errorf("dimensions = %dx%d, want 320x240", ratio.Width, ratio.Height)
+			}
+
+			after := readProbeCounters()
+			got := probeCounters{after.streamed - before.streamed, after.tempFile - before.tempFile, after.tempFileBytes - before.tempFileBytes}
+			if got != tt.want {
+				t.Errorf("counters changed by %+v, want %+v", got, tt.want)
+			}
+			if sent := server.sent.Load(); sent > int64(tt.maxSent) {
+				t.Errorf("server sent %d bytes, want at most %d", sent, tt.maxSent)
+			}
+		})
+	}
+}
+
+func TestGetVideoAspectRatioFromURLAudioOnly(t *testing.T) {
+	_, _, audioOnly := probeFixtures(t)
+	server := newVideoServer(t, audioOnly)
+
+	_, err := GetVideoAspectRatioFromURL(context.Background(), server.URL, probeTestOptions)
+	if !errors.Is(err, ErrProbeFailed) || !errors.Is(err, ErrNoVideoStream) {
+		t.Fatalf("err = %v, want ErrProbeFailed wrapping ErrNoVideoStream", err)
+	}
+	// The streamed probe read the container, refetching it wouldn't find video either
+	if n := server.requests.Load(); n != 1 {
+		t.Errorf("server got %d requests, want 1", n)
+	}
+}
+
+// BenchmarkGetVideoAspectRatioFromURL reports the size of the temp file a lookup ends
+// with (tempfile-B/op) and the bytes it fetches (net-B/op), for a faststart MP4 and one
+// with the moov atom at the end
+func BenchmarkGetVideoAspectRatioFromURL(b *testing.B) {
+	faststart, moovAtEnd, _ := probeFixtures(b)
+	for _, bm := range []struct {
+		name string
+		data []byte
+	}{
+		{"faststart", faststart},
+		{"moov_at_end", moovAtEnd},
+	} {
+		b.Run(bm.name, func(b *testing.B) {
+			server := newVideoServer(b, bm.data)
+			before := tempFileBytes.Value()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, err := GetVideoAspectRatioFromURL(context.Background(), server.URL, probeTestOptions); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(tempFileBytes.Value()-before)/float64(b.N), "tempfile-B/op")
+			b.ReportMetric(float64(server.sent.Load())/float64(b.N), "net-B/op")
+		})
+	}
+}
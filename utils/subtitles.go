@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/asset_upload_service/models"
+	"github.com/sirupsen/logrus"
+)
+
+// textSubtitleCodecs can be converted to WebVTT; bitmap subtitles (PGS, DVD) cannot
+var textSubtitleCodecs = map[string]bool{
+	"subrip":   true,
+	"srt":      true,
+	"ass":      true,
+	"ssa":      true,
+	"mov_text": true,
+	"webvtt":   true,
+	"text":     true,
+}
+
+// ListSubtitleTracks returns the subtitle streams found in a probed file. Index is the
+// position among subtitle streams, as used by ffmpeg's 0:s:N stream specifier.
+func ListSubtitleTracks(probe *ProbeResult) []models.SubtitleTrack {
+	var tracks []models.SubtitleTrack
+	for i, s := range probe.StreamsOfType("subtitle") {
+		tracks = append(tracks, models.SubtitleTrack{
+			Index:    i,
+			Language: s.Tags["language"],
+			Codec:    s.CodecName,
+		})
+	}
+	return tracks
+}
+
+// CanExtractSubtitle reports whether a subtitle codec can be converted to WebVTT
+func CanExtractSubtitle(codec string) bool {
+	return textSubtitleCodecs[codec]
+}
+
+// ExtractSubtitle demuxes the index-th subtitle stream of inputPath to a WebVTT file
+func ExtractSubtitle(inputPath string, index int, outputPath string) error {
+	ffmpegPath, err := exec.LookPath("ffmpeg")
+	if err != nil {
+		return fmt.Errorf("ffmpeg is not installed: %w", err)
+	}
+
+	cmd, done := mediaexec.Command(context.Background(), ffmpegPath,
+		"-i", inputPath,
+		"-map", "0:s:"+strconv.Itoa(index),
+		"-c:s", "webvtt",
+		"-y", outputPath,
+	)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+
+	logrus.Infof("Extracting subtitle track %d: %s", index, cmd.String())
+	if err := done(cmd.Run()); err != nil {
+		os.Remove(outputPath)
+		return fmt.Errorf("ffmpeg failed to extract subtitles: %w, stderr: %s", err, stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,124 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"sync"
+
+	"github.com/sirupsen/logrus"
+)
+
+// tempDir is where uploads and ffmpeg intermediates are written, empty means os.TempDir()
+var tempDir string
+
+// tempFileMode is the mode of files created with CreateTemp
+var tempFileMode os.FileMode = 0o600
+
+// SetTempDir creates the directory of this process for temporary files inside dir, or
+// the system temp dir when dir is empty, and sets the mode of temp files. The
+// directory gets fileMode plus the matching search bits, e.g. 0700 for 0600, so other
+// local users can't list or open uploads. It is meant to be called once at startup
+// from the loaded configuration.
+func SetTempDir(dir string, fileMode os.FileMode) error {
+	if dir == "" {
+		dir = os.TempDir()
+	}
+
+	info, err := os.Stat(dir)
+	if err != nil {
+		return fmt.Errorf("temp dir %s is not usable: %w", dir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("temp dir %s is not a directory", dir)
+	}
+
+	processDir, err := os.MkdirTemp(dir, "asset-upload-*")
+	if err != nil {
+		return fmt.Errorf("temp dir %s is not writable: %w", dir, err)
+	}
+	dirMode := fileMode | fileMode&0o444>>2 | 0o700
+	if err := os.Chmod(processDir, dirMode); err != nil {
+		os.Remove(processDir)
+		return fmt.Errorf("failed to set the mode of temp dir %s: %w", processDir, err)
+	}
+
+	tempDir = processDir
+	tempFileMode = fileMode
+	return nil
+}
+
+// TempDir returns the directory for temporary files
+func TempDir() string {
+	if tempDir == "" {
+		return os.TempDir()
+	}
+	return tempDir
+}
+
+// CreateTemp creates a temp file like os.CreateTemp in TempDir, with the configured
+// temp file mode
+func CreateTemp(pattern string) (*os.File, error) {
+	f, err := os.CreateTemp(TempDir(), pattern)
+	if err != nil {
+		return nil, err
+	}
+	// os.CreateTemp always uses 0600
+	if tempFileMode != 0o600 {
+		if err := f.Chmod(tempFileMode); err != nil {
+			f.Close()
+			os.Remove(f.Name())
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+// TempFiles tracks the temp files of a request, so every one of them is removed
+// whether the request succeeds, fails or is canceled
+type TempFiles struct {
+	mu      sync.Mutex
+	paths   []string
+	removed bool
+	stop    func() bool
+}
+
+// NewTempFiles returns a tracker whose files are removed by RemoveAll or as soon as
+// ctx ends, e.g. when the client disconnects while the file is still uploading.
+// Handles that are already open stay readable after the files are removed.
+func NewTempFiles(ctx context.Context) *TempFiles {
+	t := &TempFiles{}
+	t.stop = context.AfterFunc(ctx, t.RemoveAll)
+	return t
+}
+
+// Create creates a temp file like CreateTemp and tracks it. It fails once the files
+// were removed, so nothing created afterwards is left behind.
+func (t *TempFiles) Create(pattern string) (*os.File, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.removed {
+		return nil, errors.New("request ended, temp files were removed")
+	}
+	f, err := CreateTemp(pattern)
+	if err != nil {
+		return nil, err
+	}
+	t.paths = append(t.paths, f.Name())
+	return f, nil
+}
+
+// RemoveAll removes the tracked files. It is safe to call more than once.
+func (t *TempFiles) RemoveAll() {
+	t.stop()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.removed = true
+	for _, path := range t.paths {
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			logrus.Warnf("Failed to remove temp file %s: %v", path, err)
+		}
+	}
+	t.paths = nil
+}
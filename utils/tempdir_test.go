@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"io"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestTempFilesRemovedWhenContextEnds(t *testing.T) {
+	if err := SetTempDir(t.TempDir(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	files := NewTempFiles(ctx)
+
+	var paths []string
+	for _, pattern := range []string{"video-*", "trimmed-*"} {
+		f, err := files.Create(pattern)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer f.Close()
+		if _, err := f.WriteString("data"); err != nil {
+			t.Fatal(err)
+		}
+		paths = append(paths, f.Name())
+	}
+	held, err := os.Open(paths[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+
+	// The client disconnected, nothing calls RemoveAll
+	cancel()
+	deadline := time.Now().Add(5 * time.Second)
+	for _, path := range paths {
+		for {
+			if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+				break
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("%s still exists after the context ended", path)
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+	}
+
+	// Files created afterwards would never be removed
+	if f, err := files.Create("late-*"); err == nil {
+		f.Close()
+		os.Remove(f.Name())
+		t.Error("Create succeeded after the context ended")
+	}
+	// An open handle, e.g. one still being uploaded, stays readable
+	if data, err := io.ReadAll(held); err != nil || string(data) != "data" {
+		t.Errorf("read removed file = %q, %v", data, err)
+	}
+	files.RemoveAll()
+}
@@ -0,0 +1,93 @@
+package utils
+
+import (
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// MaxVideoDuration matches the 59 second cut applied when transcoding
+const MaxVideoDuration = 59
+
+// IsFaststart reports whether an MP4/MOV file has its moov atom before the media
+// data, so playback can start before the whole file is downloaded
+func IsFaststart(path string) (bool, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return false, err
+	}
+	defer f.Close()
+
+	var header [16]byte
+	var offset int64
+	for {
+		if _, err := f.ReadAt(header[:8], offset); err != nil {
+			return false, fmt.Errorf("no moov or mdat box found: %w", err)
+		}
+		size := int64(binary.BigEndian.Uint32(header[:4]))
+		switch string(header[4:8]) {
+		case "moov":
+			return true, nil
+		case "mdat":
+			return false, nil
+		}
+
+		switch size {
+		case 0:
+			// The box extends to the end of the file
+			return false, fmt.Errorf("no moov or mdat box found")
+		case 1:
+			// 64-bit size follows the box type
+			if _, err := f.ReadAt(header[8:16], offset+8); err != nil {
+				return false, err
+			}
+			size = int64(binary.BigEndian.Uint64(header[8:16]))
+		}
+		if size < 8 {
+			return false, fmt.Errorf("invalid box size %d at offset %d", size, offset)
+		}
+		offset += size
+	}
+}
+
+// CanSkipTranscode reports whether a video is already web-optimized: an H.264 (yuv420p)
+// MP4 with AAC or no audio, faststart, at most maxBitRate bits per second and no longer
+// than the processing cut. When it isn't, the returned reason says why.
+func CanSkipTranscode(path string, probe *ProbeResult, maxBitRate int64) (bool, string) {
+	if !strings.Contains(probe.Format.FormatName, "mp4") {
+		return false, "container is " + probe.Format.FormatName
+	}
+
+	video := probe.VideoStream()
+	if video == nil {
+		return false, "no video stream"
+	}
+	if video.CodecName != "h264" {
+		return false, "video codec is " + video.CodecName
+	}
+	if video.PixFmt != "yuv420p" {
+		return false, "pixel format is " + video.PixFmt
+	}
+	for _, audio := range probe.StreamsOfType("audio") {
+		if audio.CodecName != "aac" {
+			return false, "audio codec is " + audio.CodecName
+		}
+	}
+
+	if bitRate := probe.BitRate(); bitRate == 0 || bitRate > maxBitRate {
+		return false, fmt.Sprintf("bitrate %d exceeds %d", bitRate, maxBitRate)
+	}
+	if duration := probe.Duration(); duration == 0 || duration > MaxVideoDuration {
+		return false, fmt.Sprintf("duration %.1fs exceeds %ds", duration, MaxVideoDuration)
+	}
+
+	faststart, err := IsFaststart(path)
+	if err != nil {
+		return false, "could not check faststart: " + err.Error()
+	}
+	if !faststart {
+		return false, "moov atom is not at the start"
+	}
+	return true, ""
+}
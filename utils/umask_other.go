@@ -0,0 +1,6 @@
+//go:build !linux && !darwin
+
+package utils
+
+// SetUmask does nothing, this platform has no umask
+func SetUmask(mask int) {}
@@ -0,0 +1,11 @@
+//go:build linux || darwin
+
+package utils
+
+import "syscall"
+
+// SetUmask sets the file mode creation mask of the process, which also applies to the
+// files ffmpeg writes
+func SetUmask(mask int) {
+	syscall.Umask(mask)
+}
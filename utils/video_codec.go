@@ -0,0 +1,118 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/asset_upload_service/mediaexec"
+)
+
+// DefaultVideoCodec is the ffmpeg encoder videos are transcoded with unless an upload
+// asks for another
+const DefaultVideoCodec = "libx264"
+
+// ErrEncoderUnavailable is returned for encoders the installed ffmpeg wasn't built with
+var ErrEncoderUnavailable = errors.New("encoder is not available in the installed ffmpeg")
+
+// Quality levels (CRF and its equivalents) videos are encoded at; higher means a lower
+// bitrate. The default 28 gives a significant reduction, relaxed sources are already
+// below the bitrate floor and only get ffmpeg's default 23.
+const (
+	defaultVideoQuality = 28
+	relaxedVideoQuality = 23
+)
+
+// videoEncoderArgs returns the ffmpeg output options for encoding with codec. Encoder
+// families take different rate control options; the fallback settings trade quality
+// for speed, relaxed ones keep closer to the source. Unknown encoders only get the
+// codec and pixel format.
+func videoEncoderArgs(codec string, fallback, relaxed bool) [][2]string {
+	if codec == "" {
+		codec = DefaultVideoCodec
+	}
+	quality := defaultVideoQuality
+	if relaxed {
+		quality = relaxedVideoQuality
+	}
+	if fallback {
+		quality += 2
+	}
+	q := strconv.Itoa(quality)
+	args := [][2]string{{"c:v", codec}}
+	switch {
+	case codec == "libx264" || codec == "libx265":
+		preset := "veryfast"
+		if fallback {
+			preset = "ultrafast"
+		}
+		args = append(args, [2]string{"preset", preset}, [2]string{"crf", q}, [2]string{"pix_fmt", "yuv420p"})
+	case strings.HasSuffix(codec, "_nvenc"):
+		preset := "p4"
+		if fallback {
+			preset = "p1"
+		}
+		args = append(args, [2]string{"preset", preset}, [2]string{"rc", "vbr"}, [2]string{"cq", q}, [2]string{"pix_fmt", "yuv420p"})
+	case strings.HasSuffix(codec, "_qsv"):
+		// Quick Sync encodes NV12, not planar yuv420p
+		args = append(args, [2]string{"preset", "veryfast"}, [2]string{"global_quality", q}, [2]string{"pix_fmt", "nv12"})
+	default:
+		args = append(args, [2]string{"pix_fmt", "yuv420p"})
+	}
+	// Apple players only play HEVC in MP4 tagged hvc1
+	if codec == "libx265" || strings.HasPrefix(codec, "hevc_") {
+		args = append(args, [2]string{"tag:v", "hvc1"})
+	}
+	return args
+}
+
+// The video encoders of the installed ffmpeg, listed on first use
+var (
+	encodersMu sync.Mutex
+	encoders   map[string]bool
+)
+
+// CheckVideoEncoder returns ErrEncoderUnavailable when the installed ffmpeg has no
+// encoder named codec. Hardware encoders are listed whenever ffmpeg was built with
+// them, a missing GPU only shows when encoding.
+func CheckVideoEncoder(codec string) error {
+	encodersMu.Lock()
+	defer encodersMu.Unlock()
+	if encoders == nil {
+		ffmpegPath, err := exec.LookPath("ffmpeg")
+		if err != nil {
+			return fmt.Errorf("ffmpeg is not installed: %w", err)
+		}
+		cmd, done := mediaexec.Command(context.Background(), ffmpegPath, "-hide_banner", "-encoders")
+		output, err := cmd.Output()
+		if err = done(err); err != nil {
+			return fmt.Errorf("failed to list ffmpeg encoders: %w", err)
+		}
+		encoders = parseEncoders(string(output))
+	}
+	if !encoders[codec] {
+		return fmt.Errorf("%s: %w", codec, ErrEncoderUnavailable)
+	}
+	return nil
+}
+
+// parseEncoders reads the video encoder names from the output of ffmpeg -encoders,
+// which lists them after a dashed line as flags (V for video), name and description
+func parseEncoders(output string) map[string]bool {
+	names := map[string]bool{}
+	listing := false
+	for _, line := range strings.Split(output, "\n") {
+		if !listing {
+			listing = strings.HasPrefix(strings.TrimSpace(line), "---")
+			continue
+		}
+		if fields := strings.Fields(line); len(fields) >= 2 && strings.HasPrefix(fields[0], "V") {
+			names[fields[1]] = true
+		}
+	}
+	return names
+}
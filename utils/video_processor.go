@@ -1,19 +1,74 @@
 package utils
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"os"
 	"os/exec"
 	"path/filepath"
 	"strings"
 
+	"github.com/asset_upload_service/mediaexec"
+	"github.com/asset_upload_service/models"
 	"github.com/h2non/filetype"
 	"github.com/sirupsen/logrus"
 	ffmpeg "github.com/u2takey/ffmpeg-go"
 )
 
+// VideoProcessOptions tweaks how ProcessVideoWithBitrateReduction encodes the video
+type VideoProcessOptions struct {
+	// Filter is an optional ffmpeg video filter chain, e.g. to reframe to a target format
+	Filter string
+	// NormalizeStreams keeps only the first video and first audio stream
+	NormalizeStreams bool
+	// Keyframes sets a fixed keyframe interval, e.g. to align HLS/DASH segments
+	Keyframes KeyframeInterval
+	// Faststart moves the moov atom to the front so playback can start while
+	// downloading. It costs a second pass over the output.
+	Faststart bool
+	// TranscodeAudio re-encodes the audio to AAC instead of copying it, for sources
+	// whose audio codec MP4 can't hold
+	TranscodeAudio bool
+	// AudioBitrate is the AAC bitrate used when the audio is re-encoded, e.g. 96k
+	AudioBitrate string
+	// DownmixStereo mixes the audio down to two channels, which re-encodes it
+	DownmixStereo bool
+	// VideoCodec is the ffmpeg encoder used, DefaultVideoCodec when empty
+	VideoCodec string
+	// FullLength keeps the whole video instead of cutting it to MaxVideoDuration, for
+	// stored videos that are encoded again
+	FullLength bool
+	// RelaxedQuality encodes closer to the source quality, for sources whose bitrate is
+	// already so low that the usual compression would degrade them for little saving
+	RelaxedQuality bool
+	// Log receives the ffmpeg commands and their output when set, for debugging
+	Log io.Writer
+	// Warn receives problems that didn't fail processing, e.g. that the fallback encode
+	// was used, to report them to the client
+	Warn func(string)
+}
+
+// normalizeStreamMaps selects the first video stream and the first audio stream, if any
+var normalizeStreamMaps = []string{"0:v:0", "0:a:0?"}
+
+// mp4AudioCodecs can be copied into an MP4 container as they are
+var mp4AudioCodecs = map[string]bool{"aac": true, "mp3": true, "ac3": true, "eac3": true, "alac": true}
+
+// MP4AudioCompatible reports whether all audio streams of probe can be copied into MP4
+func MP4AudioCompatible(probe *ProbeResult) bool {
+	for _, audio := range probe.StreamsOfType("audio") {
+		if !mp4AudioCodecs[audio.CodecName] {
+			return false
+		}
+	}
+	return true
+}
+
 // ProcessVideoWithBitrateReduction compresses a video by reducing its bitrate without changing resolution
-func ProcessVideoWithBitrateReduction(inputPath string) (string, bool, error) {
+// (unless opts.Filter changes it). It also returns how the audio was encoded.
+func ProcessVideoWithBitrateReduction(inputPath string, opts VideoProcessOptions) (string, bool, *models.AudioSettings, error) {
 	// First check if it's a video
 	isVideo := false
 
@@ -25,15 +80,15 @@ func ProcessVideoWithBitrateReduction(inputPath string) (string, bool, error) {
 		file, err := os.Open(inputPath)
 		if err != nil {
 			logrus.Errorf("Failed to open file for type detection: %v", err)
-			return "", false, fmt.Errorf("failed to open file for type detection: %w", err)
+			return "", false, nil, fmt.Errorf("failed to open file for type detection: %w", err)
 		}
 		defer file.Close()
 
 		// Read enough bytes for detection
-		head := make([]byte, 261)
-		if _, err := file.Read(head); err != nil {
+		head, err := ReadFileHeader(file)
+		if err != nil {
 			logrus.Errorf("Failed to read file header: %v", err)
-			return "", false, fmt.Errorf("failed to read file header: %w", err)
+			return "", false, nil, fmt.Errorf("failed to read file header: %w", err)
 		}
 
 		kind, err := filetype.Match(head)
@@ -46,7 +101,7 @@ func ProcessVideoWithBitrateReduction(inputPath string) (string, bool, error) {
 	if !isVideo {
 		// Not a video or unrecognized format
 		logrus.Infof("Not a video or unrecognized format")
-		return inputPath, false, nil
+		return inputPath, false, nil, nil
 	}
 
 	// Check if the file is already an MP4
@@ -62,7 +117,9 @@ func ProcessVideoWithBitrateReduction(inputPath string) (string, bool, error) {
 	}
 
 	dimensions, err := GetVideoMetadata(inputPath)
-	if err != nil {
+	if errors.Is(err, ErrNoVideoStream) {
+		return "", false, nil, err
+	} else if err != nil {
 		logrus.Warnf("Failed to get video metadata: %v, proceeding with conversion anyway", err)
 	} else if dimensions.Width > 0 && dimensions.Height > 0 {
 		logrus.Infof("Original video dimensions: %dx%d, preserving original resolution", dimensions.Width, dimensions.Height)
@@ -75,94 +132,147 @@ func ProcessVideoWithBitrateReduction(inputPath string) (string, bool, error) {
 	ffmpegPath, err := exec.LookPath("ffmpeg")
 	if err != nil {
 		logrus.Errorf("FFmpeg not found: %v", err)
-		return "", false, fmt.Errorf("ffmpeg is not installed: %w", err)
+		return "", false, nil, fmt.Errorf("ffmpeg is not installed: %w", err)
 	}
 
 	// Try a simpler ffmpeg command first to check if the input file is valid
-	probeCmd := exec.Command(ffmpegPath, "-i", inputPath, "-f", "null", "-")
+	probeCmd, probeDone := mediaexec.Command(context.Background(), ffmpegPath, "-i", inputPath, "-f", "null", "-")
 	probeOutput, probeErr := probeCmd.CombinedOutput()
-	if probeErr != nil {
+	if probeErr = probeDone(probeErr); probeErr != nil {
 		logrus.Errorf("FFmpeg probe failed: %v, output: %s", probeErr, string(probeOutput))
-		return "", false, fmt.Errorf("failed to process video - input file may be corrupted: %w", probeErr)
+		return "", false, nil, fmt.Errorf("failed to process video - input file may be corrupted: %w", probeErr)
 	}
 
 	// Process video with ffmpeg to reduce bitrate while maintaining original resolution
 	logrus.Infof("Starting video processing with bitrate reduction (original resolution maintained)")
 
 	// Build the ffmpeg command that maintains resolution but reduces bitrate
+	outputArgs := ffmpeg.KwArgs{
+		"c:a": "copy", // Use copy codec for audio
+	}
+	if !opts.FullLength {
+		outputArgs["t"] = "59" // Cut to 59 seconds
+	}
+	// H.264 by default, with a preset and quality suited to the encoder
+	for _, arg := range videoEncoderArgs(opts.VideoCodec, false, opts.RelaxedQuality) {
+		outputArgs[arg[0]] = arg[1]
+	}
+	audio := &models.AudioSettings{Codec: "copy"}
+	if opts.TranscodeAudio || opts.DownmixStereo {
+		outputArgs["c:a"] = "aac"
+		outputArgs["b:a"] = opts.AudioBitrate
+		audio = &models.AudioSettings{Codec: "aac", BitRate: opts.AudioBitrate}
+		logrus.Infof("Transcoding audio to AAC at %s", opts.AudioBitrate)
+	}
+	if opts.DownmixStereo {
+		outputArgs["ac"] = "2"
+	}
+	if opts.Faststart {
+		outputArgs["movflags"] = "+faststart" // Optimize for web playback
+	}
+	if opts.Filter != "" {
+		outputArgs["vf"] = opts.Filter
+		logrus.Infof("Applying video filter: %s", opts.Filter)
+	}
+	if opts.NormalizeStreams {
+		outputArgs["map"] = normalizeStreamMaps
+	}
+	for _, arg := range opts.Keyframes.ffmpegArgs() {
+		outputArgs[arg[0]] = arg[1]
+	}
 	ffmpegCmd := ffmpeg.Input(inputPath).
-		Output(outputPath, ffmpeg.KwArgs{
-			"t":        "59",         // Cut to 59 seconds
-			"c:v":      "libx264",    // Use H.264 codec for video
-			"preset":   "veryfast",   // Use veryfast preset for better compatibility
-			"crf":      "28",         // Higher CRF value = lower bitrate (default is 23, 28 gives significant reduction)
-			"c:a":      "copy",       // Use copy codec for audio
-			"movflags": "+faststart", // Optimize for web playback
-			"pix_fmt":  "yuv420p",    // Pixel format for maximum compatibility
-		}).
+		Output(outputPath, outputArgs).
 		OverWriteOutput()
 
 	// Log the actual command that will be executed
 	cmdString := ffmpegCmd.String()
 	logrus.Infof("Running FFmpeg command: %s", cmdString)
+	cmd, done := mediaexec.Command(context.Background(), ffmpegPath, ffmpegCmd.GetArgs()...)
+	if opts.Log != nil {
+		fmt.Fprintf(opts.Log, "$ %s\n", cmdString)
+		cmd.Stderr = opts.Log
+	}
 	// Run the command
-	err = ffmpegCmd.Run()
+	err = done(cmd.Run())
+	if errors.Is(err, mediaexec.ErrTimeout) {
+		// The fallback would hang on the same input
+		os.Remove(outputPath)
+		return "", false, nil, fmt.Errorf("failed to process video: %w", err)
+	}
 	if err != nil {
 		logrus.Errorf("Failed to process video: %v", err)
 		// Try a more basic conversion as a fallback
 		logrus.Infof("Trying fallback conversion with simpler settings")
 
-		// Get input file extension
-		inputExt := strings.ToLower(filepath.Ext(inputPath))
-
-		// Fallback options based on file type
-		audioOpts := []string{"-c:a", "aac", "-b:a", "96k"}
-
-		// For WebM, MKV, and other formats that might have VP8/VP9/AV1 video and Opus/Vorbis audio
-		if inputExt == ".webm" || inputExt == ".mkv" || inputExt == ".ogg" || inputExt == ".ogv" {
-			logrus.Infof("Using special handling for %s format", inputExt)
-			// Force audio transcoding for these formats
-			audioOpts = []string{"-c:a", "aac", "-b:a", "96k"}
+		// The audio may be what failed to copy, so the fallback always re-encodes it
+		audioOpts := []string{"-c:a", "aac", "-b:a", opts.AudioBitrate}
+		if opts.DownmixStereo {
+			audioOpts = append(audioOpts, "-ac", "2")
 		}
+		audio = &models.AudioSettings{Codec: "aac", BitRate: opts.AudioBitrate}
 		// Fallback with simpler settings but still maintaining resolution
-		fallbackArgs := []string{
-			"-i", inputPath,
-			"-t", "59",
-			"-c:v", "libx264",
-			"-preset", "ultrafast", // Faster encoding for compatibility
-			"-crf", "30", // Even higher CRF for more bitrate reduction
+		fallbackArgs := []string{"-i", inputPath}
+		if !opts.FullLength {
+			fallbackArgs = append(fallbackArgs, "-t", "59")
+		}
+		// Faster encoding and more bitrate reduction
+		for _, arg := range videoEncoderArgs(opts.VideoCodec, true, opts.RelaxedQuality) {
+			fallbackArgs = append(fallbackArgs, "-"+arg[0], arg[1])
+		}
+
+		if opts.Filter != "" {
+			fallbackArgs = append(fallbackArgs, "-vf", opts.Filter)
+		}
+		if opts.NormalizeStreams {
+			for _, m := range normalizeStreamMaps {
+				fallbackArgs = append(fallbackArgs, "-map", m)
+			}
+		}
+		for _, arg := range opts.Keyframes.ffmpegArgs() {
+			fallbackArgs = append(fallbackArgs, "-"+arg[0], arg[1])
 		}
 
 		// Add audio options
 		fallbackArgs = append(fallbackArgs, audioOpts...)
 
-		// Add the remaining options
-		fallbackArgs = append(fallbackArgs,
-			"-pix_fmt", "yuv420p",
-			"-y", outputPath)
+		if opts.Faststart {
+			fallbackArgs = append(fallbackArgs, "-movflags", "+faststart")
+		}
+
+		// Add the output
+		fallbackArgs = append(fallbackArgs, "-y", outputPath)
 
 		logrus.Infof("Fallback command args: %v", fallbackArgs)
-		fallbackCmd := exec.Command(ffmpegPath, fallbackArgs...)
+		fallbackCmd, fallbackDone := mediaexec.Command(context.Background(), ffmpegPath, fallbackArgs...)
 
 		logrus.Infof("Running fallback FFmpeg command")
 		fallbackOutput, fallbackErr := fallbackCmd.CombinedOutput()
+		fallbackErr = fallbackDone(fallbackErr)
+		if opts.Log != nil {
+			fmt.Fprintf(opts.Log, "$ %s %s\n%s", ffmpegPath, strings.Join(fallbackArgs, " "), fallbackOutput)
+		}
 		if fallbackErr != nil {
 			logrus.Errorf("Fallback conversion also failed: %v, output: %s", fallbackErr, string(fallbackOutput))
-			return "", false, fmt.Errorf("failed to process video (all methods): %w", fallbackErr)
+			os.Remove(outputPath)
+			return "", false, nil, fmt.Errorf("failed to process video (all methods): %w", fallbackErr)
 		}
 		logrus.Infof("Fallback conversion with bitrate reduction succeeded")
-		return outputPath, true, nil
+		if opts.Warn != nil {
+			opts.Warn("Video was encoded with the fallback encoder settings at a lower quality")
+		}
+		return outputPath, true, audio, nil
 	}
 
 	// Check if the output file exists and has non-zero size
 	if outInfo, err := os.Stat(outputPath); err != nil {
 		logrus.Errorf("Output file doesn't exist after processing: %v", err)
-		return "", false, fmt.Errorf("output file not created: %w", err)
+		return "", false, nil, fmt.Errorf("output file not created: %w", err)
 	} else if outInfo.Size() == 0 {
 		logrus.Errorf("Output file has zero size")
-		return "", false, fmt.Errorf("output file has zero size")
+		os.Remove(outputPath)
+		return "", false, nil, fmt.Errorf("output file has zero size")
 	}
 
 	logrus.Infof("Video processing with bitrate reduction completed successfully")
-	return outputPath, true, nil
+	return outputPath, true, audio, nil
 }
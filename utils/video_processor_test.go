@@ -0,0 +1,61 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// fakeFFmpeg puts an ffmpeg on PATH that logs its arguments and writes a non-empty
+// .mp4 output file, and an ffprobe that fails. It returns the path of the log.
+func fakeFFmpeg(t *testing.T) string {
+	t.Helper()
+	sh, err := exec.LookPath("sh")
+	if err != nil {
+		t.Skip("sh is not installed")
+	}
+	bin := t.TempDir()
+	log := filepath.Join(bin, "args")
+	scripts := map[string]string{
+		"ffmpeg":  "echo \"$*\" >> " + log + "\nfor a; do case $a in *.mp4) echo video > \"$a\";; esac; done\n",
+		"ffprobe": "exit 1\n",
+	}
+	for name, script := range scripts {
+		if err := os.WriteFile(filepath.Join(bin, name), []byte("#!"+sh+"\n"+script), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("PATH", bin+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return log
+}
+
+func TestProcessVideoFullLength(t *testing.T) {
+	for _, fullLength := range []bool{false, true} {
+		log := fakeFFmpeg(t)
+		input := filepath.Join(t.TempDir(), "clip.mov")
+		if err := os.WriteFile(input, []byte("video"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+
+		output, processed, _, err := ProcessVideoWithBitrateReduction(input, VideoProcessOptions{FullLength: fullLength})
+		if err != nil || !processed {
+			t.Fatalf("FullLength %v: processed %v, %v", fullLength, processed, err)
+		}
+		os.Remove(output)
+
+		data, err := os.ReadFile(log)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// The first run only checks that the input decodes
+		runs := strings.Split(strings.TrimSpace(string(data)), "\n")
+		if len(runs) != 2 {
+			t.Fatalf("FullLength %v: ffmpeg runs %q, want 2", fullLength, runs)
+		}
+		if cut := strings.Contains(" "+runs[1]+" ", " -t 59 "); cut == fullLength {
+			t.Errorf("FullLength %v: ffmpeg %s", fullLength, runs[1])
+		}
+	}
+}